@@ -0,0 +1,36 @@
+package main
+
+// BatchProcessor collects items and hands them to its flush function once size of them are
+// buffered. Every flush gets its own slice, so it may keep the items after returning.
+type BatchProcessor[T any] struct {
+	size  int
+	items []T
+	flush func(items []T) error
+}
+
+// NewBatchProcessor returns a processor calling flush with batches of size items
+func NewBatchProcessor[T any](size int, flush func(items []T) error) *BatchProcessor[T] {
+	if size < 1 {
+		size = 1
+	}
+	return &BatchProcessor[T]{size: size, items: make([]T, 0, size), flush: flush}
+}
+
+// Add buffers item, flushing the batch when it is full
+func (b *BatchProcessor[T]) Add(item T) error {
+	b.items = append(b.items, item)
+	if len(b.items) >= b.size {
+		return b.Flush()
+	}
+	return nil
+}
+
+// Flush hands the buffered items to the flush function, if there are any
+func (b *BatchProcessor[T]) Flush() error {
+	if len(b.items) == 0 {
+		return nil
+	}
+	items := b.items
+	b.items = make([]T, 0, b.size)
+	return b.flush(items)
+}
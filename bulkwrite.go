@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// BatchTally counts the documents of one or more unordered bulk writes MongoDB accepted and rejected
+type BatchTally struct {
+	Written int
+	Failed  int
+}
+
+func (t *BatchTally) add(other BatchTally) {
+	t.Written += other.Written
+	t.Failed += other.Failed
+}
+
+// failedWrites returns the messages of the writes err rejects, by their index in the batch, when
+// err is a BulkWriteException of write errors only: the other writes of an unordered batch went
+// through. Any other error, e.g. a write concern, network or context error, is returned as it is.
+func failedWrites(err error) (map[int]string, error) {
+	if err == nil {
+		return nil, nil
+	}
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil || len(bulkErr.WriteErrors) == 0 {
+		return nil, err
+	}
+	failed := make(map[int]string, len(bulkErr.WriteErrors))
+	for _, writeErr := range bulkErr.WriteErrors {
+		failed[writeErr.Index] = writeErr.Message
+	}
+	return failed, nil
+}
+
+// describeFailedWrites lists the failed writes in batch order, naming each one with describe
+func describeFailedWrites(failed map[int]string, describe func(i int) string) string {
+	indexes := make([]int, 0, len(failed))
+	for i := range failed {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	lines := make([]string, len(indexes))
+	for n, i := range indexes {
+		lines[n] = fmt.Sprintf("%s: %s", describe(i), failed[i])
+	}
+	return strings.Join(lines, "; ")
+}
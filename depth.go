@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// MongoDB rejects documents nested deeper than this
+	maxDocumentDepth = 100
+
+	// depthPolicySkip stores over-limit documents in depthDeadLetterCollection instead of inserting them
+	depthPolicySkip = "skip"
+	// depthPolicyFlatten replaces everything nested below flattenDepth with its extended JSON string
+	depthPolicyFlatten = "flatten"
+
+	cloneDepthPolicy          = depthPolicySkip
+	flattenDepth              = 90
+	depthDeadLetterCollection = "CloneDeadLetter"
+)
+
+// DeadLetterDocument keeps a document that could not be written as is.
+// The document is stored as extended JSON since it is too deep to store as BSON.
+type DeadLetterDocument struct {
+	Collection string    `bson:"Collection"`
+	DocumentId string    `bson:"DocumentId"`
+	Document   string    `bson:"Document"`
+	Depth      int       `bson:"Depth"`
+	Reason     string    `bson:"Reason"`
+	CreatedAt  time.Time `bson:"CreatedAt"`
+}
+
+// documentDepth returns how many levels of documents and arrays a value nests, a flat document being 1
+func documentDepth(value interface{}) int {
+	maxChild := 0
+	switch v := value.(type) {
+	case primitive.D:
+		for _, elem := range v {
+			maxChild = max(maxChild, documentDepth(elem.Value))
+		}
+	case primitive.M:
+		for _, elem := range v {
+			maxChild = max(maxChild, documentDepth(elem))
+		}
+	case map[string]interface{}:
+		for _, elem := range v {
+			maxChild = max(maxChild, documentDepth(elem))
+		}
+	case primitive.A:
+		for _, elem := range v {
+			maxChild = max(maxChild, documentDepth(elem))
+		}
+	case []interface{}:
+		for _, elem := range v {
+			maxChild = max(maxChild, documentDepth(elem))
+		}
+	default:
+		return 0
+	}
+	return maxChild + 1
+}
+
+// flattenBeyond replaces documents and arrays nested deeper than limit with their extended JSON string
+func flattenBeyond(value interface{}, limit int) interface{} {
+	if limit <= 0 && documentDepth(value) > 0 {
+		return toExtJSON(value)
+	}
+
+	switch v := value.(type) {
+	case primitive.D:
+		out := make(primitive.D, len(v))
+		for i, elem := range v {
+			out[i] = primitive.E{Key: elem.Key, Value: flattenBeyond(elem.Value, limit-1)}
+		}
+		return out
+	case primitive.M:
+		out := make(primitive.M, len(v))
+		for key, elem := range v {
+			out[key] = flattenBeyond(elem, limit-1)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			out[key] = flattenBeyond(elem, limit-1)
+		}
+		return out
+	case primitive.A:
+		out := make(primitive.A, len(v))
+		for i, elem := range v {
+			out[i] = flattenBeyond(elem, limit-1)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, elem := range v {
+			out[i] = flattenBeyond(elem, limit-1)
+		}
+		return out
+	}
+	return value
+}
+
+func toExtJSON(value interface{}) string {
+	// MarshalExtJSON only accepts documents, so wrap the value and strip the wrapper
+	data, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: value}}, false, false)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(string(data), `{"v":`), "}")
+}
+
+// checkDocumentDepths applies cloneDepthPolicy to documents over maxDocumentDepth and
+// returns the documents that can be inserted along with how many were over the limit
+func checkDocumentDepths(ctx context.Context, collName string, docs []interface{}, deadLetter *mongo.Collection) ([]interface{}, int, error) {
+	kept := docs[:0]
+	overLimit := 0
+
+	for _, doc := range docs {
+		depth := documentDepth(doc)
+		if depth <= maxDocumentDepth {
+			kept = append(kept, doc)
+			continue
+		}
+		overLimit++
+
+		if cloneDepthPolicy == depthPolicyFlatten {
+			kept = append(kept, flattenBeyond(doc, flattenDepth))
+			continue
+		}
+
+		var id interface{}
+		if d, ok := doc.(primitive.D); ok {
+			id = d.Map()["_id"]
+		}
+		_, err := deadLetter.InsertOne(ctx, DeadLetterDocument{
+			Collection: collName,
+			DocumentId: fmt.Sprint(id),
+			Document:   toExtJSON(doc),
+			Depth:      depth,
+			Reason:     fmt.Sprintf("document nests %d levels, limit is %d", depth, maxDocumentDepth),
+			CreatedAt:  time.Now(),
+		})
+		if err != nil {
+			return nil, overLimit, fmt.Errorf("failed to dead-letter document %v from %s: %v", id, collName, err)
+		}
+	}
+
+	return kept, overLimit, nil
+}
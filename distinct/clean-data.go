@@ -3,28 +3,38 @@ package main
 import (
 	"context"
 	"database/sql"
+	"flag"
+	"fmt"
 	"log"
-	"os"
-	"time"
 
-	_ "github.com/go-sql-driver/mysql"
-	"github.com/joho/godotenv"
+	appdb "github.com/duymanh3602/migrate-tool/internal/db"
+	"github.com/duymanh3602/migrate-tool/internal/env"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// Lấy toàn bộ user ID từ MySQL và lưu vào map
-func getAllUserIDsFromMySQL(mysqlDB *sql.DB) (map[string]struct{}, error) {
+const (
+	// userIDsProgressEvery is how many ids getAllUserIDsFromMySQL reads between progress logs
+	userIDsProgressEvery = 100000
+)
+
+// Lấy toàn bộ ID mà query trả về từ MySQL và lưu vào map. The query is usually a
+// ReferenceConfig.idsQuery. It returns the number of rows read as well, logging the progress
+// every userIDsProgressEvery rows, and is cancelled after timeouts.Scan.
+func getAllUserIDsFromMySQL(ctx context.Context, mysqlDB *sql.DB, query string) (map[string]struct{}, int, error) {
+	ctx, cancel := timeouts.ScanContext(ctx)
+	defer cancel()
+
 	userMap := make(map[string]struct{})
 
-	rows, err := mysqlDB.Query("SELECT Id FROM AspNetUsers")
+	rows, err := mysqlDB.QueryContext(ctx, query)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
+	count := 0
 	for rows.Next() {
 		var id string
 		if err := rows.Scan(&id); err != nil {
@@ -32,174 +42,288 @@ func getAllUserIDsFromMySQL(mysqlDB *sql.DB) (map[string]struct{}, error) {
 			continue
 		}
 		userMap[id] = struct{}{}
+		count++
+		if count%userIDsProgressEvery == 0 {
+			log.Printf("Read %d user IDs from MySQL...", count)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, count, fmt.Errorf("failed to read user IDs after %d rows: %v", count, err)
 	}
 
-	return userMap, nil
+	return userMap, count, nil
 }
 
-func cleanInvalidUserAssignments(mongoURI, dbName, mysqlDSN string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
+// CleanupSummary counts what cleanOrphanedReferences did with the scanned documents
+type CleanupSummary struct {
+	// with userIDsServerSide only the documents without a user are scanned
+	Scanned int
+	Valid   int
+	Invalid int
+	// Deleted stays 0 in a dry run
+	Deleted int
+	Errored int
+}
+
+const (
+	// dryRunSampleSize is the number of invalid documents a dry run lists
+	dryRunSampleSize = 20
+
+	// deleteBatchSize is the number of _ids removed per DeleteMany
+	deleteBatchSize = 1000
+)
+
+// cleanInvalidUserAssignments deletes the ItemAssignmentData documents whose UserId is not in MySQL.
+// With dryRun nothing is deleted, only the count and a sample of the documents are logged.
+func cleanInvalidUserAssignments(mongoURI, dbName, mysqlDSN string, dryRun bool) (CleanupSummary, error) {
+	return cleanOrphanedReferences(mongoURI, dbName, mysqlDSN, userAssignments, dryRun)
+}
+
+// cleanOrphanedReferences deletes the documents of ref.Collection whose ref.Field has no row in
+// ref.Table, like cleanInvalidUserAssignments does for ItemAssignmentData.
+func cleanOrphanedReferences(mongoURI, dbName, mysqlDSN string, ref ReferenceConfig, dryRun bool) (CleanupSummary, error) {
+	var summary CleanupSummary
+	if err := ref.validate(); err != nil {
+		return summary, err
+	}
+	ctx := context.Background()
 
 	// MongoDB
-	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	mongoClient, err := appdb.ConnectMongo(mongoURI)
 	if err != nil {
-		return err
+		return summary, err
 	}
 	defer mongoClient.Disconnect(ctx)
 
 	db := mongoClient.Database(dbName)
-	itemAssignmentCol := db.Collection("ItemAssignmentData")
+	col := db.Collection(ref.Collection)
+	errorsCol := db.Collection(ref.errorsCollection())
 
 	// MySQL
-	mysqlDB, err := sql.Open("mysql", mysqlDSN)
+	mysqlDB, err := appdb.ConnectMySQL(mysqlDSN)
 	if err != nil {
-		return err
+		return summary, err
 	}
 	defer mysqlDB.Close()
 
 	// Lấy danh sách hợp lệ từ MySQL
-	validUserIDs, err := getAllUserIDsFromMySQL(mysqlDB)
+	validUserIDs, err := newUserIDChecker(ctx, mysqlDB, db, ref, userIDStrategy)
 	if err != nil {
-		return err
+		return summary, err
 	}
-	log.Printf("Loaded %d valid user IDs from MySQL", len(validUserIDs))
+	defer validUserIDs.Close()
 
-	// Duyệt tất cả document trong ItemAssignmentData
-	cursor, err := itemAssignmentCol.Find(ctx, bson.M{})
+	// Duyệt tất cả document trong collection
+	cursor, err := findAssignments(ctx, col, ref.Field, validUserIDs)
 	if err != nil {
-		return err
+		return summary, err
 	}
 	defer cursor.Close(ctx)
 
-	for cursor.Next(ctx) {
+	// Xoá sau khi duyệt xong để không thay đổi collection trong lúc cursor đang chạy
+	var invalidIDs []primitive.ObjectID
+	var sample []string
+	err = eachDocument(ctx, cursor, func(ctx context.Context) {
+		summary.Scanned++
+
 		var doc bson.M
 		if err := cursor.Decode(&doc); err != nil {
 			log.Printf("Decode error: %v", err)
-			continue
+			recordAssignmentError(ctx, errorsCol, cursor.Current, "clean", fmt.Sprintf("decode error: %v", err))
+			summary.Errored++
+			return
 		}
 
-		userId, ok := doc["UserId"].(string)
-		if !ok {
-			log.Printf("UserId không hợp lệ: %v", doc["UserId"])
-			continue
+		refId, err := ref.normalizeID(doc[ref.Field])
+		if err != nil {
+			log.Printf("%s không hợp lệ: %v", ref.Field, err)
+			recordAssignmentError(ctx, errorsCol, cursor.Current, "clean", err.Error())
+			summary.Errored++
+			return
+		}
+
+		exists, err := validUserIDs.Exists(ctx, refId)
+		if err != nil {
+			log.Printf("%v", err)
+			summary.Errored++
+			return
+		}
+		if exists {
+			summary.Valid++
+			return
 		}
+		summary.Invalid++
 
 		// Nếu không có trong danh sách hợp lệ → xóa
-		if _, exists := validUserIDs[userId]; !exists {
-			id, ok := doc["_id"].(primitive.ObjectID)
-			if !ok {
-				log.Printf("Document _id không hợp lệ: %v", doc["_id"])
-				continue
-			}
+		id, ok := doc["_id"].(primitive.ObjectID)
+		if !ok {
+			log.Printf("Document _id không hợp lệ: %v", doc["_id"])
+			recordAssignmentError(ctx, errorsCol, cursor.Current, "clean", fmt.Sprintf("_id is %T, not an ObjectID", doc["_id"]))
+			summary.Errored++
+			return
+		}
 
-			_, err := itemAssignmentCol.DeleteOne(ctx, bson.M{"_id": id})
-			if err != nil {
-				log.Printf("Xoá thất bại _id=%v: %v", id.Hex(), err)
-			} else {
-				log.Printf("❌ Đã xoá document với UserId không hợp lệ: %s", userId)
+		if dryRun {
+			if len(sample) < dryRunSampleSize {
+				sample = append(sample, fmt.Sprintf("_id=%s %s=%s", id.Hex(), ref.Field, refId))
 			}
+			return
+		}
+		invalidIDs = append(invalidIDs, id)
+	})
+	if err != nil {
+		return summary, err
+	}
+
+	for start := 0; start < len(invalidIDs); start += deleteBatchSize {
+		batch := invalidIDs[start:min(start+deleteBatchSize, len(invalidIDs))]
+		batchCtx, cancel := timeouts.Step(ctx)
+		res, err := col.DeleteMany(batchCtx, bson.M{"_id": bson.M{"$in": batch}})
+		cancel()
+		if err != nil {
+			log.Printf("Xoá thất bại %d documents: %v", len(batch), err)
+			summary.Errored += len(batch)
+			continue
 		}
+		summary.Deleted += int(res.DeletedCount)
+		log.Printf("❌ Đã xoá %d documents với %s không hợp lệ", res.DeletedCount, ref.Field)
 	}
 
-	return nil
+	if dryRun {
+		log.Printf("Dry run: %d documents would be deleted", summary.Invalid)
+		for _, doc := range sample {
+			log.Printf("  %s", doc)
+		}
+	}
+	log.Printf("Scanned %d documents: %d valid, %d invalid, %d deleted, %d errors",
+		summary.Scanned, summary.Valid, summary.Invalid, summary.Deleted, summary.Errored)
+	return summary, nil
 }
 
-// func main() {
-// 	if err := godotenv.Load(); err != nil {
-// 		log.Fatal("Lỗi khi đọc .env file")
-// 	}
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only count and list the documents that would be deleted")
+	flag.Parse()
 
-// 	mongoURI := os.Getenv("MONGO_URI")
-// 	dbName := os.Getenv("DB_NAME")
-// 	mysqlDSN := os.Getenv("MYSQL_DSN")
+	if err := env.Load(); err != nil {
+		log.Fatalf("Lỗi cấu hình: %v", err)
+	}
+	settings, err := env.Require(env.MongoURI, env.DBName, env.MySQLDSN)
+	if err != nil {
+		log.Fatalf("Lỗi cấu hình: %v", err)
+	}
+	if timeouts, err = appdb.TimeoutsFromEnv(); err != nil {
+		log.Fatalf("Lỗi cấu hình: %v", err)
+	}
 
-// 	if mongoURI == "" || dbName == "" || mysqlDSN == "" {
-// 		log.Fatal("Thiếu biến môi trường: MONGO_URI, DB_NAME, MYSQL_DSN")
-// 	}
+	if _, err := cleanInvalidUserAssignments(settings[0], settings[1], settings[2], *dryRun); err != nil {
+		log.Fatalf("Lỗi thực thi: %v", err)
+	}
+}
 
-// 	if err := cleanInvalidUserAssignments(mongoURI, dbName, mysqlDSN); err != nil {
-// 		log.Fatalf("Lỗi thực thi: %v", err)
-// 	}
-// }
+// moveInvalidUserAssignments moves the ItemAssignmentData documents whose UserId is not in MySQL
+// to InvalidItemAssignmentData
+func moveInvalidUserAssignments(mongoURI, dbName, mysqlDSN string) error {
+	return moveOrphanedReferences(mongoURI, dbName, mysqlDSN, userAssignments)
+}
 
+// moveOrphanedReferences moves the documents of ref.Collection whose ref.Field has no row in
+// ref.Table to Invalid<Collection>
+func moveOrphanedReferences(mongoURI, dbName, mysqlDSN string, ref ReferenceConfig) error {
+	if err := ref.validate(); err != nil {
+		return err
+	}
+	ctx := context.Background()
 
-func moveInvalidUserAssignments(mongoURI, dbName, mysqlDSN string) error {
-    ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-    defer cancel()
-
-    // MongoDB setup
-    mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
-    if err != nil {
-        return err
-    }
-    defer mongoClient.Disconnect(ctx)
-
-    db := mongoClient.Database(dbName)
-    itemAssignmentCol := db.Collection("ItemAssignmentData")
-    invalidAssignmentCol := db.Collection("ValidItemAssignmentData")
-
-    // MySQL setup
-    mysqlDB, err := sql.Open("mysql", mysqlDSN)
-    if err != nil {
-        return err
-    }
-    defer mysqlDB.Close()
-
-    // Load valid user IDs from MySQL
-    validUserIDs, err := getAllUserIDsFromMySQL(mysqlDB)
-    if err != nil {
-        return err
-    }
-    log.Printf("Loaded %d valid user IDs from MySQL", len(validUserIDs))
-
-    cursor, err := itemAssignmentCol.Find(ctx, bson.M{})
-    if err != nil {
-        return err
-    }
-    defer cursor.Close(ctx)
-
-    for cursor.Next(ctx) {
-        var doc bson.M
-        if err := cursor.Decode(&doc); err != nil {
-            log.Printf("Decode error: %v", err)
-            continue
-        }
-
-        userId, ok := doc["UserId"].(string)
-        if !ok {
-            log.Printf("UserId format not valid: %v", doc["UserId"])
-            continue
-        }
-
-        if _, exists := validUserIDs[userId]; !exists {
-            // Save document to InvalidItemAssignmentData
-            _, err := invalidAssignmentCol.InsertOne(ctx, doc)
-            if err != nil {
-                log.Printf("Insert to InvalidItemAssignmentData failed: %v", err)
-            } else {
-                log.Printf("🔁 Moved invalid UserId %s to InvalidItemAssignmentData", userId)
-            }
-        }
-    }
-
-    return nil
+	// MongoDB setup
+	mongoClient, err := appdb.ConnectMongo(mongoURI)
+	if err != nil {
+		return err
+	}
+	defer mongoClient.Disconnect(ctx)
+
+	db := mongoClient.Database(dbName)
+	col := db.Collection(ref.Collection)
+	invalidCol := db.Collection(ref.invalidCollection())
+	errorsCol := db.Collection(ref.errorsCollection())
+
+	// MySQL setup
+	mysqlDB, err := appdb.ConnectMySQL(mysqlDSN)
+	if err != nil {
+		return err
+	}
+	defer mysqlDB.Close()
+
+	// Load valid IDs from MySQL
+	validUserIDs, err := newUserIDChecker(ctx, mysqlDB, db, ref, userIDStrategy)
+	if err != nil {
+		return err
+	}
+	defer validUserIDs.Close()
+
+	// Each document is inserted and deleted in one transaction when the deployment supports it
+	var session mongo.Session
+	if supportsTransactions(ctx, mongoClient) {
+		session, err = mongoClient.StartSession()
+		if err != nil {
+			return err
+		}
+		defer session.EndSession(ctx)
+	} else {
+		log.Printf("MongoDB is not a replica set, moving documents without a transaction")
+	}
+
+	cursor, err := findAssignments(ctx, col, ref.Field, validUserIDs)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	return eachDocument(ctx, cursor, func(ctx context.Context) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			log.Printf("Decode error: %v", err)
+			recordAssignmentError(ctx, errorsCol, cursor.Current, "move", fmt.Sprintf("decode error: %v", err))
+			return
+		}
+
+		refId, err := ref.normalizeID(doc[ref.Field])
+		if err != nil {
+			log.Printf("%s format not valid: %v", ref.Field, err)
+			recordAssignmentError(ctx, errorsCol, cursor.Current, "move", err.Error())
+			return
+		}
+
+		exists, err := validUserIDs.Exists(ctx, refId)
+		if err != nil {
+			log.Printf("%v", err)
+			return
+		}
+
+		if !exists {
+			// Move document to Invalid<Collection>
+			err := moveAssignment(ctx, session, col, invalidCol, doc)
+			if err != nil {
+				log.Printf("Moving document %v to %s failed: %v", doc["_id"], invalidCol.Name(), err)
+			} else {
+				log.Printf("🔁 Moved invalid %s %s to %s", ref.Field, refId, invalidCol.Name())
+			}
+		}
+	})
 }
 
 // func main() {
-//     if err := godotenv.Load(); err != nil {
-//         log.Fatal("Error loading .env file")
-//     }
-
-//     mongoURI := os.Getenv("MONGO_URI")
-//     dbName := os.Getenv("DB_NAME")
-//     mysqlDSN := os.Getenv("MYSQL_DSN")
-
-//     if mongoURI == "" || dbName == "" || mysqlDSN == "" {
-//         log.Fatal("Missing required environment variables: MONGO_URI, DB_NAME, MYSQL_DSN")
-//     }
-
-//     if err := moveInvalidUserAssignments(mongoURI, dbName, mysqlDSN); err != nil {
-//         log.Fatalf("Execution failed: %v", err)
-//     }
-// }
\ No newline at end of file
+// 	if err := env.Load(); err != nil {
+// 		log.Fatalf("Configuration failed: %v", err)
+// 	}
+// 	settings, err := env.Require(env.MongoURI, env.DBName, env.MySQLDSN)
+// 	if err != nil {
+// 		log.Fatalf("Configuration failed: %v", err)
+// 	}
+// 	if timeouts, err = appdb.TimeoutsFromEnv(); err != nil {
+// 		log.Fatalf("Configuration failed: %v", err)
+// 	}
+
+// 	if err := moveInvalidUserAssignments(settings[0], settings[1], settings[2]); err != nil {
+// 		log.Fatalf("Execution failed: %v", err)
+// 	}
+// }
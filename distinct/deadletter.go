@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// assignmentError keeps the raw BSON of a document that could not be checked and why
+type assignmentError struct {
+	DocumentId interface{}      `bson:"DocumentId,omitempty"`
+	Raw        primitive.Binary `bson:"Raw"`
+	Reason     string           `bson:"Reason"`
+	Source     string           `bson:"Source"`
+	CreatedAt  time.Time        `bson:"CreatedAt"`
+}
+
+// recordAssignmentError stores a document in the errors collection of its reference instead of dropping it.
+// A failed insert is only logged, so the cleanup goes on.
+func recordAssignmentError(ctx context.Context, col *mongo.Collection, raw bson.Raw, source, reason string) {
+	entry := assignmentError{
+		Raw:       primitive.Binary{Data: append([]byte(nil), raw...)},
+		Reason:    reason,
+		Source:    source,
+		CreatedAt: time.Now(),
+	}
+	if id, err := raw.LookupErr("_id"); err == nil {
+		entry.DocumentId = id
+	}
+
+	if _, err := col.InsertOne(ctx, entry); err != nil {
+		log.Printf("Failed to store document in %s: %v (reason: %s)", col.Name(), err, reason)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// supportsTransactions reports whether the deployment is a replica set or sharded cluster,
+// since standalone servers reject transactions
+func supportsTransactions(ctx context.Context, client *mongo.Client) bool {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		log.Printf("Could not check the MongoDB deployment type: %v", err)
+		return false
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid"
+}
+
+// moveAssignment inserts doc into to and deletes it from from. With a session both happen in one
+// transaction; without one the insert runs first, so a crash can leave a copy but never lose the document.
+func moveAssignment(ctx context.Context, session mongo.Session, from, to *mongo.Collection, doc bson.M) error {
+	move := func(ctx context.Context) error {
+		if _, err := to.InsertOne(ctx, doc); err != nil {
+			return fmt.Errorf("insert into %s failed: %v", to.Name(), err)
+		}
+		if _, err := from.DeleteOne(ctx, bson.M{"_id": doc["_id"]}); err != nil {
+			return fmt.Errorf("delete from %s failed: %v", from.Name(), err)
+		}
+		return nil
+	}
+
+	if session == nil {
+		return move(ctx)
+	}
+	_, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, move(sc)
+	})
+	return err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Types a ReferenceConfig.IDType may require of the referencing field
+const (
+	idTypeString   = "string"
+	idTypeObjectID = "objectId"
+	idTypeNumber   = "number"
+)
+
+// ReferenceConfig names a MongoDB field holding the id of a MySQL row, for the orphan cleanup
+type ReferenceConfig struct {
+	// Collection is the MongoDB collection holding the references
+	Collection string
+	// Field is the field of Collection holding the MySQL id
+	Field string
+	// Table is the MySQL table the field references
+	Table string
+	// IDColumn is the id column of Table
+	IDColumn string
+	// Filter, e.g. "IsActive = 1", is a SQL condition on Table selecting the rows that may be
+	// referenced. References to the other rows count as orphaned. Every row may be when empty.
+	Filter string
+	// IDType is the type the values of Field must have, string, objectId or number, and any
+	// other is reported as an error. When empty any of them is accepted. Values are compared
+	// with the ids of Table as text: the ObjectID hex or the decimal number. The
+	// userIDsServerSide strategy only checks the types of the documents it finds orphaned.
+	IDType string
+}
+
+// userAssignments is the reference checked by cleanInvalidUserAssignments and moveInvalidUserAssignments
+var userAssignments = ReferenceConfig{
+	Collection: "ItemAssignmentData",
+	Field:      "UserId",
+	Table:      "AspNetUsers",
+	IDColumn:   "Id",
+}
+
+func (c ReferenceConfig) validate() error {
+	if c.Collection == "" || c.Field == "" || c.Table == "" || c.IDColumn == "" {
+		return fmt.Errorf("reference config needs a collection, field, table and id column: %+v", c)
+	}
+	switch c.IDType {
+	case "", idTypeString, idTypeObjectID, idTypeNumber:
+		return nil
+	}
+	return fmt.Errorf("unknown id type %q, must be %q, %q or %q", c.IDType, idTypeString, idTypeObjectID, idTypeNumber)
+}
+
+// normalizeID converts the value of Field to the text form of the ids read from Table
+func (c ReferenceConfig) normalizeID(value interface{}) (string, error) {
+	var id, idType string
+	switch v := value.(type) {
+	case string:
+		id, idType = v, idTypeString
+	case primitive.ObjectID:
+		id, idType = v.Hex(), idTypeObjectID
+	case int32:
+		id, idType = strconv.FormatInt(int64(v), 10), idTypeNumber
+	case int64:
+		id, idType = strconv.FormatInt(v, 10), idTypeNumber
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) >= 1<<53 {
+			return "", fmt.Errorf("%s is %v, not a whole number", c.Field, v)
+		}
+		id, idType = strconv.FormatInt(int64(v), 10), idTypeNumber
+	default:
+		return "", fmt.Errorf("%s is %T, not a string, ObjectID or number", c.Field, value)
+	}
+
+	if c.IDType != "" && idType != c.IDType {
+		return "", fmt.Errorf("%s is %T, expected a %s", c.Field, value, c.IDType)
+	}
+	return id, nil
+}
+
+// idsQuery selects the ids of the rows of Table that may be referenced
+func (c ReferenceConfig) idsQuery() string {
+	query := fmt.Sprintf("SELECT %s FROM %s", quoteMySQL(c.IDColumn), quoteMySQL(c.Table))
+	if c.Filter != "" {
+		query += " WHERE " + c.Filter
+	}
+	return query
+}
+
+// errorsCollection receives the documents of Collection that could not be checked
+func (c ReferenceConfig) errorsCollection() string { return c.Collection + "_errors" }
+
+// invalidCollection receives the documents moved by moveOrphanedReferences
+func (c ReferenceConfig) invalidCollection() string { return "Invalid" + c.Collection }
+
+// validIDsCollection holds the ids copied from MySQL by the userIDsServerSide strategy
+func (c ReferenceConfig) validIDsCollection() string { return c.Collection + "_validIds" }
+
+// quoteMySQL quotes a MySQL table or column name
+func quoteMySQL(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
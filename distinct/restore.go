@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	appdb "github.com/duymanh3602/migrate-tool/internal/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RestoreSummary counts what restoreOrphanedReferences did with the moved documents
+type RestoreSummary struct {
+	Scanned int
+	// Restored documents reference a row that exists again and were moved back
+	Restored int
+	// StillInvalid documents stay in Invalid<Collection>
+	StillInvalid int
+	Errored      int
+}
+
+// restoreValidAssignments moves the InvalidItemAssignmentData documents whose UserId is in MySQL
+// again back to ItemAssignmentData, undoing moveInvalidUserAssignments for re-created users
+func restoreValidAssignments(mongoURI, dbName, mysqlDSN string) (RestoreSummary, error) {
+	return restoreOrphanedReferences(mongoURI, dbName, mysqlDSN, userAssignments)
+}
+
+// restoreOrphanedReferences moves the documents of Invalid<Collection> whose ref.Field has a row in
+// ref.Table again back to ref.Collection, each in a transaction when the deployment supports it
+func restoreOrphanedReferences(mongoURI, dbName, mysqlDSN string, ref ReferenceConfig) (RestoreSummary, error) {
+	var summary RestoreSummary
+	if err := ref.validate(); err != nil {
+		return summary, err
+	}
+	ctx := context.Background()
+
+	mongoClient, err := appdb.ConnectMongo(mongoURI)
+	if err != nil {
+		return summary, err
+	}
+	defer mongoClient.Disconnect(ctx)
+
+	db := mongoClient.Database(dbName)
+	col := db.Collection(ref.Collection)
+	invalidCol := db.Collection(ref.invalidCollection())
+	errorsCol := db.Collection(ref.errorsCollection())
+
+	mysqlDB, err := appdb.ConnectMySQL(mysqlDSN)
+	if err != nil {
+		return summary, err
+	}
+	defer mysqlDB.Close()
+
+	// The server-side strategy only finds orphans, the moved documents are few enough to look up
+	strategy := userIDStrategy
+	if strategy == userIDsServerSide {
+		strategy = userIDsOnDemand
+	}
+	validUserIDs, err := newUserIDChecker(ctx, mysqlDB, db, ref, strategy)
+	if err != nil {
+		return summary, err
+	}
+	defer validUserIDs.Close()
+
+	var session mongo.Session
+	if supportsTransactions(ctx, mongoClient) {
+		session, err = mongoClient.StartSession()
+		if err != nil {
+			return summary, err
+		}
+		defer session.EndSession(ctx)
+	} else {
+		log.Printf("MongoDB is not a replica set, restoring documents without a transaction")
+	}
+
+	findCtx, cancel := timeouts.Step(ctx)
+	cursor, err := invalidCol.Find(findCtx, bson.M{})
+	cancel()
+	if err != nil {
+		return summary, fmt.Errorf("failed to read %s: %v", invalidCol.Name(), err)
+	}
+	defer cursor.Close(ctx)
+
+	err = eachDocument(ctx, cursor, func(ctx context.Context) {
+		summary.Scanned++
+
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			log.Printf("Decode error: %v", err)
+			recordAssignmentError(ctx, errorsCol, cursor.Current, "restore", fmt.Sprintf("decode error: %v", err))
+			summary.Errored++
+			return
+		}
+
+		refId, err := ref.normalizeID(doc[ref.Field])
+		if err != nil {
+			log.Printf("%s format not valid: %v", ref.Field, err)
+			recordAssignmentError(ctx, errorsCol, cursor.Current, "restore", err.Error())
+			summary.Errored++
+			return
+		}
+
+		exists, err := validUserIDs.Exists(ctx, refId)
+		if err != nil {
+			log.Printf("%v", err)
+			summary.Errored++
+			return
+		}
+		if !exists {
+			summary.StillInvalid++
+			return
+		}
+
+		if err := moveAssignment(ctx, session, invalidCol, col, doc); err != nil {
+			log.Printf("Restoring document %v to %s failed: %v", doc["_id"], col.Name(), err)
+			summary.Errored++
+			return
+		}
+		summary.Restored++
+		log.Printf("Restored %s %s to %s", ref.Field, refId, col.Name())
+	})
+	if err != nil {
+		return summary, err
+	}
+
+	log.Printf("Restore: %d scanned, %d restored, %d still invalid, %d errors",
+		summary.Scanned, summary.Restored, summary.StillInvalid, summary.Errored)
+	return summary, nil
+}
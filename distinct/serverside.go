@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// validUsersBatchSize is the number of user ids inserted per InsertMany
+const validUsersBatchSize = 1000
+
+// serverSideUsers keeps the valid ids in MongoDB as {_id: <id>}, so the _id index backs the
+// $lookup in findAssignments
+type serverSideUsers struct {
+	col *mongo.Collection
+}
+
+// newServerSideUsers copies the valid ids within timeouts.Scan
+func newServerSideUsers(ctx context.Context, mysqlDB *sql.DB, db *mongo.Database, ref ReferenceConfig) (*serverSideUsers, error) {
+	ctx, cancel := timeouts.ScanContext(ctx)
+	defer cancel()
+
+	col := db.Collection(ref.validIDsCollection())
+	// a previous run may have died before dropping it
+	if err := col.Drop(ctx); err != nil {
+		return nil, fmt.Errorf("failed to drop %s: %v", col.Name(), err)
+	}
+
+	rows, err := mysqlDB.QueryContext(ctx, ref.idsQuery())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	count := 0
+	batch := make([]interface{}, 0, validUsersBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := col.InsertMany(ctx, batch, options.InsertMany().SetOrdered(false)); err != nil {
+			return fmt.Errorf("failed to insert ids into %s: %v", col.Name(), err)
+		}
+		count += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			log.Printf("Scan error: %v", err)
+			continue
+		}
+		batch = append(batch, bson.M{"_id": id})
+		if len(batch) == validUsersBatchSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Copied %d valid %s IDs to %s", count, ref.Table, col.Name())
+	return &serverSideUsers{col: col}, nil
+}
+
+// Exists always reports false: the cursor from findAssignments only yields the documents whose
+// reference has no match in the valid ids collection
+func (*serverSideUsers) Exists(context.Context, string) (bool, error) { return false, nil }
+
+func (u *serverSideUsers) Close() error {
+	return u.col.Drop(context.Background())
+}
+
+// findAssignments returns the documents of col to check. With the userIDsServerSide strategy
+// MongoDB joins them against the valid ids collection and only the orphaned ones are transferred;
+// otherwise every document is. The join runs until its first batch within timeouts.Scan, and
+// the cursor reads the next batches with the context given to its Next.
+func findAssignments(ctx context.Context, col *mongo.Collection, field string, validUserIDs userIDChecker) (*mongo.Cursor, error) {
+	users, ok := validUserIDs.(*serverSideUsers)
+	if !ok {
+		findCtx, cancel := timeouts.Step(ctx)
+		defer cancel()
+		return col.Find(findCtx, bson.M{})
+	}
+	ctx, cancel := timeouts.ScanContext(ctx)
+	defer cancel()
+
+	// The ids are joined as text, like ReferenceConfig.normalizeID converts ObjectIDs and numbers.
+	// Values with no text form are left unmatched, the cleanup then reports them.
+	refId := bson.D{
+		{Key: "input", Value: "$" + field},
+		{Key: "to", Value: "string"},
+		{Key: "onError", Value: nil},
+		{Key: "onNull", Value: nil},
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$addFields", Value: bson.D{{Key: "_refId", Value: bson.D{{Key: "$convert", Value: refId}}}}}},
+		{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: users.col.Name()},
+			{Key: "localField", Value: "_refId"},
+			{Key: "foreignField", Value: "_id"},
+			{Key: "as", Value: "_user"},
+		}}},
+		{{Key: "$match", Value: bson.D{{Key: "_user", Value: bson.D{{Key: "$size", Value: 0}}}}}},
+		{{Key: "$project", Value: bson.D{{Key: "_user", Value: 0}, {Key: "_refId", Value: 0}}}},
+	}
+	return col.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+
+	appdb "github.com/duymanh3602/migrate-tool/internal/db"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// timeouts bound the cleanup, move and restore: Scan the reading of every valid id, Operation
+// each document of their cursor loops and each DeleteMany
+var timeouts = appdb.DefaultTimeouts()
+
+// eachDocument calls fn with every document of cursor. Each cursor.Next and fn call gets a new
+// timeouts.Step deadline, so a long collection is not cut off by a deadline for the whole loop.
+func eachDocument(ctx context.Context, cursor *mongo.Cursor, fn func(ctx context.Context)) error {
+	for {
+		stepCtx, cancel := timeouts.Step(ctx)
+		if !cursor.Next(stepCtx) {
+			cancel()
+			break
+		}
+		fn(stepCtx)
+		cancel()
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// How the cleanup checks that a referenced id exists in MySQL
+const (
+	// userIDsInMemory loads every user id into a map first, the fastest for small user tables
+	userIDsInMemory = "memory"
+	// userIDsOnDemand looks each id up in MySQL, remembering the last userIDCacheSize results
+	userIDsOnDemand = "lookup"
+	// userIDsServerSide copies every user id into a temporary MongoDB collection and lets the
+	// server find the assignments without a user, so only those are transferred
+	userIDsServerSide = "server"
+)
+
+// userIDStrategy selects the userIDChecker used by cleanOrphanedReferences and moveOrphanedReferences
+var userIDStrategy = userIDsInMemory
+
+// userIDCacheSize is the number of lookups userIDsOnDemand keeps
+const userIDCacheSize = 10000
+
+// userIDChecker reports whether a user exists in MySQL
+type userIDChecker interface {
+	Exists(ctx context.Context, userId string) (bool, error)
+	Close() error
+}
+
+func newUserIDChecker(ctx context.Context, mysqlDB *sql.DB, db *mongo.Database, ref ReferenceConfig, strategy string) (userIDChecker, error) {
+	switch strategy {
+	case userIDsInMemory:
+		ids, count, err := getAllUserIDsFromMySQL(ctx, mysqlDB, ref.idsQuery())
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded %d valid %s IDs from MySQL (heap: %s)", count, ref.Table, heapInUse())
+		return userIDSet(ids), nil
+	case userIDsOnDemand:
+		query := fmt.Sprintf("SELECT 1 FROM %s WHERE %s = ?", quoteMySQL(ref.Table), quoteMySQL(ref.IDColumn))
+		if ref.Filter != "" {
+			query += " AND (" + ref.Filter + ")"
+		}
+		stmt, err := mysqlDB.PrepareContext(ctx, query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare user lookup: %v", err)
+		}
+		log.Printf("Looking up %s IDs in MySQL on demand, caching %d", ref.Table, userIDCacheSize)
+		return newUserIDLookup(stmt, userIDCacheSize), nil
+	case userIDsServerSide:
+		return newServerSideUsers(ctx, mysqlDB, db, ref)
+	}
+	return nil, fmt.Errorf("unknown user ID strategy %q, must be %q, %q or %q", strategy, userIDsInMemory, userIDsOnDemand, userIDsServerSide)
+}
+
+// heapInUse formats the heap memory in use, to compare the strategies
+func heapInUse() string {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return fmt.Sprintf("%.1f MB", float64(stats.HeapInuse)/(1024*1024))
+}
+
+// userIDSet holds every user id in memory
+type userIDSet map[string]struct{}
+
+func (s userIDSet) Exists(_ context.Context, userId string) (bool, error) {
+	_, ok := s[userId]
+	return ok, nil
+}
+
+func (userIDSet) Close() error { return nil }
+
+// userIDLookup queries MySQL for ids it hasn't seen recently, keeping the results in an LRU cache
+type userIDLookup struct {
+	stmt    *sql.Stmt
+	size    int
+	order   *list.List // most recently used first, of userIDEntry
+	entries map[string]*list.Element
+	queries int
+}
+
+type userIDEntry struct {
+	userId string
+	exists bool
+}
+
+func newUserIDLookup(stmt *sql.Stmt, size int) *userIDLookup {
+	return &userIDLookup{stmt: stmt, size: size, order: list.New(), entries: make(map[string]*list.Element, size)}
+}
+
+func (l *userIDLookup) Exists(ctx context.Context, userId string) (bool, error) {
+	if e, ok := l.entries[userId]; ok {
+		l.order.MoveToFront(e)
+		return e.Value.(userIDEntry).exists, nil
+	}
+
+	var one int
+	err := l.stmt.QueryRowContext(ctx, userId).Scan(&one)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("failed to look up user %s: %v", userId, err)
+	}
+	exists := err == nil
+	l.queries++
+
+	l.entries[userId] = l.order.PushFront(userIDEntry{userId: userId, exists: exists})
+	if l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.entries, oldest.Value.(userIDEntry).userId)
+	}
+	return exists, nil
+}
+
+func (l *userIDLookup) Close() error {
+	log.Printf("Looked up %d user IDs in MySQL (heap: %s)", l.queries, heapInUse())
+	return l.stmt.Close()
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	appdb "github.com/duymanh3602/migrate-tool/internal/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	dumpFileExtension = ".ndjson"
+
+	// documents inserted per InsertMany by restoreMongoDB
+	restoreBatchSize = 1000
+
+	// longest line restoreMongoDB reads, a 16MB document takes more once encoded as extended JSON
+	maxDumpLineSize = 64 * 1024 * 1024
+)
+
+// dumpMongoDB writes every collection of sourceDB to <outDir>/<collection>.ndjson, one canonical
+// extended JSON document per line so ObjectIDs, dates and other BSON types survive restoreMongoDB.
+// Documents are streamed from the cursor, so collections of any size can be dumped.
+func dumpMongoDB(sourceURI, sourceDB, outDir string) error {
+	ctx := context.Background()
+
+	client, err := appdb.ConnectMongo(sourceURI)
+	if err != nil {
+		return fmt.Errorf("source: %v", err)
+	}
+	defer client.Disconnect(ctx)
+
+	database := client.Database(sourceDB)
+	collections, err := database.ListCollectionNames(ctx, bson.D{})
+	if err != nil {
+		return fmt.Errorf("failed to list collections: %v", err)
+	}
+	sort.Strings(collections)
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return fmt.Errorf("failed to create dump directory: %v", err)
+	}
+
+	for _, collName := range collections {
+		count, err := dumpCollection(ctx, database.Collection(collName), filepath.Join(outDir, collName+dumpFileExtension))
+		if err != nil {
+			return err
+		}
+		logger.Info(fmt.Sprintf("Dumped collection %s (%d documents)", collName, count))
+	}
+
+	logger.Summary(fmt.Sprintf("Dumped %d collections to %s", len(collections), outDir))
+	return nil
+}
+
+func dumpCollection(ctx context.Context, collection *mongo.Collection, path string) (int, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	cursor, err := collection.Find(ctx, bson.D{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to find documents in %s: %v", collection.Name(), err)
+	}
+	defer cursor.Close(ctx)
+
+	count := 0
+	for cursor.Next(ctx) {
+		line, err := bson.MarshalExtJSON(cursor.Current, true, false)
+		if err != nil {
+			return count, fmt.Errorf("failed to encode document in %s: %v", collection.Name(), err)
+		}
+		w.Write(line)
+		if err := w.WriteByte('\n'); err != nil {
+			return count, fmt.Errorf("failed to write %s: %v", path, err)
+		}
+		count++
+	}
+	if err := cursor.Err(); err != nil {
+		return count, fmt.Errorf("failed to read documents in %s: %v", collection.Name(), err)
+	}
+
+	if err := w.Flush(); err != nil {
+		return count, fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	if err := file.Close(); err != nil {
+		return count, fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return count, nil
+}
+
+// restoreMongoDB inserts the collections dumped by dumpMongoDB in inDir into targetDB,
+// following cloneCollectionOrder like cloneMongoDB
+func restoreMongoDB(targetURI, targetDB, inDir string) error {
+	ctx := context.Background()
+
+	paths, err := filepath.Glob(filepath.Join(inDir, "*"+dumpFileExtension))
+	if err != nil {
+		return fmt.Errorf("failed to list dump files: %v", err)
+	}
+	files := make(map[string]string, len(paths))
+	collections := make([]string, 0, len(paths))
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), dumpFileExtension)
+		files[name] = path
+		collections = append(collections, name)
+	}
+	sort.Strings(collections)
+	collections = orderCollections(collections, cloneCollectionOrder)
+
+	client, err := appdb.ConnectMongo(targetURI)
+	if err != nil {
+		return fmt.Errorf("target: %v", err)
+	}
+	defer client.Disconnect(ctx)
+	database := client.Database(targetDB)
+
+	for _, collName := range collections {
+		count, err := restoreCollection(ctx, database.Collection(collName), files[collName])
+		if err != nil {
+			return err
+		}
+		logger.Info(fmt.Sprintf("Restored collection %s (%d documents)", collName, count))
+	}
+
+	logger.Summary(fmt.Sprintf("Restored %d collections into %s", len(collections), targetDB))
+	return nil
+}
+
+func restoreCollection(ctx context.Context, collection *mongo.Collection, path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 1024*1024), maxDumpLineSize)
+
+	count := 0
+	batch := make([]interface{}, 0, restoreBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := collection.InsertMany(ctx, batch); err != nil {
+			return fmt.Errorf("failed to insert documents into %s: %v", collection.Name(), err)
+		}
+		count += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var doc bson.D
+		if err := bson.UnmarshalExtJSON(scanner.Bytes(), true, &doc); err != nil {
+			return count, fmt.Errorf("%s:%d: failed to decode document: %v", path, line, err)
+		}
+		batch = append(batch, doc)
+		if len(batch) == restoreBatchSize {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return count, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return count, flush()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// enumLabeler adds the labels of the int values of CourseLessonItem fields to their documents
+type enumLabeler struct {
+	labels  map[string]map[int]string
+	replace bool
+	// unmapped counts the values seen without a label, per field
+	unmapped map[string]map[int]int
+}
+
+func newEnumLabeler(labels map[string]map[int]string, replace bool) *enumLabeler {
+	return &enumLabeler{labels: labels, replace: replace, unmapped: map[string]map[int]int{}}
+}
+
+// apply adds (or substitutes) the labels of the mapped fields of a document
+func (e *enumLabeler) apply(doc bson.D) bson.D {
+	for i := 0; i < len(doc); i++ {
+		labels, ok := e.labels[doc[i].Key]
+		if !ok {
+			continue
+		}
+
+		field := doc[i].Key
+		value, ok := toEnumValue(doc[i].Value)
+		if !ok {
+			continue
+		}
+
+		label, ok := labels[value]
+		if !ok {
+			if e.unmapped[field] == nil {
+				e.unmapped[field] = make(map[int]int)
+			}
+			if e.unmapped[field][value] == 0 {
+				log.Printf("⚠️  No label for %s value %d", field, value)
+			}
+			e.unmapped[field][value]++
+			continue
+		}
+
+		if e.replace {
+			doc[i].Value = label
+		} else {
+			doc = append(doc[:i+1], append(bson.D{{Key: field + "Label", Value: label}}, doc[i+1:]...)...)
+			i++
+		}
+	}
+	return doc
+}
+
+func toEnumValue(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case int:
+		return v, true
+	}
+	return 0, false
+}
+
+// report logs every value that had no label during the migration
+func (e *enumLabeler) report() {
+	for field, values := range e.unmapped {
+		for value, count := range values {
+			log.Printf("⚠️  %s value %d has no label (%d documents)", field, value, count)
+		}
+	}
+}
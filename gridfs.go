@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// documents larger than this have their offloadFields moved to GridFS,
+	// kept below MongoDB's 16MB limit to leave room for the reference fields
+	maxInlineDocumentSize = 15 * 1024 * 1024
+
+	gridFSBucketName = "CourseLessonItemFiles"
+)
+
+// offloadFields lists the fields that may be moved to GridFS, tried in this order.
+// An offloaded field is replaced by "<Field>FileId" holding the GridFS file id.
+var offloadFields = []string{"Content", "QuestionIdsRaw"}
+
+// gridFSOffloader moves large fields of oversized documents to a GridFS bucket
+type gridFSOffloader struct {
+	bucket    *gridfs.Bucket
+	offloaded atomic.Int64
+}
+
+func newGridFSOffloader(db *mongo.Database) (*gridFSOffloader, error) {
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(gridFSBucketName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GridFS bucket: %v", err)
+	}
+	return &gridFSOffloader{bucket: bucket}, nil
+}
+
+// Offload returns the documents with the large fields of every oversized document uploaded to GridFS.
+// The input slice is left untouched, since it may be the batch of items itself.
+func (o *gridFSOffloader) Offload(docs []interface{}) ([]interface{}, error) {
+	out := docs
+	for i, doc := range docs {
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal document: %v", err)
+		}
+		if len(data) <= maxInlineDocumentSize {
+			continue
+		}
+
+		var d bson.D
+		if err := bson.Unmarshal(data, &d); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal document: %v", err)
+		}
+
+		d, err = o.offloadDocument(d, len(data))
+		if err != nil {
+			return nil, err
+		}
+		if &out[0] == &docs[0] {
+			out = append([]interface{}(nil), docs...)
+		}
+		out[i] = d
+		o.offloaded.Add(1)
+	}
+	return out, nil
+}
+
+func (o *gridFSOffloader) offloadDocument(doc bson.D, size int) (bson.D, error) {
+	id := documentID(doc)
+
+	for _, field := range offloadFields {
+		if size <= maxInlineDocumentSize {
+			break
+		}
+
+		for i, elem := range doc {
+			if elem.Key != field {
+				continue
+			}
+
+			var content []byte
+			switch v := elem.Value.(type) {
+			case string:
+				content = []byte(v)
+			case []byte:
+				content = v
+			default:
+				continue
+			}
+
+			filename := fmt.Sprintf("%v/%s", id, field)
+			fileID, err := o.bucket.UploadFromStream(filename, bytes.NewReader(content))
+			if err != nil {
+				return nil, fmt.Errorf("failed to upload %s to GridFS: %v", filename, err)
+			}
+			log.Printf("offloaded %s (%d bytes) to GridFS file %s", filename, len(content), fileID.Hex())
+
+			doc[i] = bson.E{Key: field + "FileId", Value: fileID}
+			size -= len(content)
+			break
+		}
+	}
+
+	if size > maxInlineDocumentSize {
+		return nil, fmt.Errorf("document %v is still %d bytes after offloading %v", id, size, offloadFields)
+	}
+	return doc, nil
+}
+
+func documentID(doc bson.D) interface{} {
+	for _, elem := range doc {
+		if elem.Key == "CourseLessonItemId" || elem.Key == "_id" {
+			return elem.Value
+		}
+	}
+	return nil
+}
+
+// Offloaded returns how many documents had fields moved to GridFS
+func (o *gridFSOffloader) Offloaded() int64 {
+	return o.offloaded.Load()
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IDMapping maps each converted string _id to the ObjectID that replaced it
+type IDMapping map[string]primitive.ObjectID
+
+// IDMappingOutput selects where convertStringIDsToObjectIDs writes its mapping, empty fields are skipped
+type IDMappingOutput struct {
+	// Collection in the converted collection's database
+	Collection string
+	// File receives the mapping as a JSON object of old id to ObjectID hex
+	File string
+}
+
+// IDMappingEntry is one mapping document of IDMappingOutput.Collection
+type IDMappingEntry struct {
+	Collection  string             `bson:"Collection"`
+	OldId       string             `bson:"OldId"`
+	NewId       primitive.ObjectID `bson:"NewId"`
+	ConvertedAt time.Time          `bson:"ConvertedAt"`
+}
+
+const idMappingBatchSize = 1000
+
+func writeIDMapping(ctx context.Context, db *mongo.Database, collectionName string, mapping IDMapping, output IDMappingOutput) error {
+	if output.Collection != "" && len(mapping) > 0 {
+		mappingColl := db.Collection(output.Collection)
+		now := time.Now()
+
+		var docs []interface{}
+		for oldID, newID := range mapping {
+			docs = append(docs, IDMappingEntry{Collection: collectionName, OldId: oldID, NewId: newID, ConvertedAt: now})
+			if len(docs) >= idMappingBatchSize {
+				if _, err := mappingColl.InsertMany(ctx, docs); err != nil {
+					return fmt.Errorf("failed to write id mapping to %s: %v", output.Collection, err)
+				}
+				docs = docs[:0]
+			}
+		}
+		if len(docs) > 0 {
+			if _, err := mappingColl.InsertMany(ctx, docs); err != nil {
+				return fmt.Errorf("failed to write id mapping to %s: %v", output.Collection, err)
+			}
+		}
+		log.Printf("wrote %d id mappings to collection %s", len(mapping), output.Collection)
+	}
+
+	if output.File != "" {
+		hexMapping := make(map[string]string, len(mapping))
+		for oldID, newID := range mapping {
+			hexMapping[oldID] = newID.Hex()
+		}
+		data, err := json.MarshalIndent(hexMapping, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode id mapping: %v", err)
+		}
+		if err := os.WriteFile(output.File, data, 0644); err != nil {
+			return fmt.Errorf("failed to write id mapping file: %v", err)
+		}
+		log.Printf("wrote %d id mappings to %s", len(mapping), output.File)
+	}
+
+	return nil
+}
+
+// ApplyIDMapping rewrites references to converted ids in another collection, replacing every
+// field value equal to an old string id with its new ObjectID. It returns the number of
+// documents modified.
+func ApplyIDMapping(ctx context.Context, collection *mongo.Collection, field string, mapping IDMapping) (int64, error) {
+	var modified int64
+	var ops []mongo.WriteModel
+
+	flush := func() error {
+		if len(ops) == 0 {
+			return nil
+		}
+		result, err := collection.BulkWrite(ctx, ops)
+		if err != nil {
+			return fmt.Errorf("failed to apply id mapping to %s.%s: %v", collection.Name(), field, err)
+		}
+		modified += result.ModifiedCount
+		ops = ops[:0]
+		return nil
+	}
+
+	for oldID, newID := range mapping {
+		ops = append(ops, mongo.NewUpdateManyModel().
+			SetFilter(bson.M{field: oldID}).
+			SetUpdate(bson.M{"$set": bson.M{field: newID}}))
+		if len(ops) >= idMappingBatchSize {
+			if err := flush(); err != nil {
+				return modified, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return modified, err
+	}
+
+	log.Printf("updated %d references in %s.%s", modified, collection.Name(), field)
+	return modified, nil
+}
+
+// IDReference is a field of another collection that stores ids converted by convertStringIDsToObjectIDs
+type IDReference struct {
+	Collection string
+	Field      string
+	// AsHex stores the new id as its hex string instead of an ObjectID
+	AsHex bool
+}
+
+// updateIDReferences points every reference to oldID at newID
+func updateIDReferences(ctx context.Context, db *mongo.Database, references []IDReference, oldID string, newID primitive.ObjectID) error {
+	for _, ref := range references {
+		var value interface{} = newID
+		if ref.AsHex {
+			value = newID.Hex()
+		}
+		_, err := db.Collection(ref.Collection).UpdateMany(ctx,
+			bson.M{ref.Field: oldID},
+			bson.M{"$set": bson.M{ref.Field: value}})
+		if err != nil {
+			return fmt.Errorf("failed to update references in %s.%s: %v", ref.Collection, ref.Field, err)
+		}
+	}
+	return nil
+}
+
+// CourseLessonItemIDs are the ids a migrated CourseLessonItems row was given
+type CourseLessonItemIDs struct {
+	CourseLessonItemId string             `json:"CourseLessonItemId"`
+	Id                 primitive.ObjectID `json:"Id"`
+}
+
+// CourseLessonItemMapping maps the MySQL id of each migrated CourseLessonItems row to its new ids,
+// for migrating the tables that reference it
+type CourseLessonItemMapping map[int]CourseLessonItemIDs
+
+// CourseLessonItemMappingEntry is one mapping document written by MigrateCourseLessonItems
+type CourseLessonItemMappingEntry struct {
+	Collection         string             `bson:"Collection"`
+	OldId              int                `bson:"OldId"`
+	CourseLessonItemId string             `bson:"CourseLessonItemId"`
+	NewId              primitive.ObjectID `bson:"NewId"`
+	ConvertedAt        time.Time          `bson:"ConvertedAt"`
+}
+
+func (m CourseLessonItemMapping) add(items []CourseLessonItem) {
+	for _, item := range items {
+		m[item.OldId] = CourseLessonItemIDs{CourseLessonItemId: item.CourseLessonItemId, Id: item.Id}
+	}
+}
+
+// writeCourseLessonItemMapping replaces the entries of a previous run by Collection and OldId,
+// so a forced re-migration leaves only the new ids
+func writeCourseLessonItemMapping(ctx context.Context, db *mongo.Database, collectionName string, mapping CourseLessonItemMapping, output IDMappingOutput) error {
+	if output.Collection != "" && len(mapping) > 0 {
+		mappingColl := db.Collection(output.Collection)
+		now := time.Now()
+
+		var ops []mongo.WriteModel
+		flush := func() error {
+			if len(ops) == 0 {
+				return nil
+			}
+			if _, err := mappingColl.BulkWrite(ctx, ops, options.BulkWrite().SetOrdered(false)); err != nil {
+				return fmt.Errorf("failed to write id mapping to %s: %v", output.Collection, err)
+			}
+			ops = ops[:0]
+			return nil
+		}
+		for oldID, ids := range mapping {
+			ops = append(ops, mongo.NewReplaceOneModel().
+				SetFilter(bson.M{"Collection": collectionName, "OldId": oldID}).
+				SetReplacement(CourseLessonItemMappingEntry{
+					Collection:         collectionName,
+					OldId:              oldID,
+					CourseLessonItemId: ids.CourseLessonItemId,
+					NewId:              ids.Id,
+					ConvertedAt:        now,
+				}).
+				SetUpsert(true))
+			if len(ops) >= idMappingBatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		log.Printf("wrote %d id mappings to collection %s", len(mapping), output.Collection)
+	}
+
+	if output.File != "" {
+		data, err := json.MarshalIndent(mapping, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode id mapping: %v", err)
+		}
+		if err := os.WriteFile(output.File, data, 0644); err != nil {
+			return fmt.Errorf("failed to write id mapping file: %v", err)
+		}
+		log.Printf("wrote %d id mappings to %s", len(mapping), output.File)
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexSpec is an index as listed by listIndexes, with the options createIndexes accepts
+type indexSpec struct {
+	Name                    string          `bson:"name"`
+	Key                     bson.D          `bson:"key"`
+	Unique                  *bool           `bson:"unique"`
+	Sparse                  *bool           `bson:"sparse"`
+	Hidden                  *bool           `bson:"hidden"`
+	ExpireAfterSeconds      *int32          `bson:"expireAfterSeconds"`
+	PartialFilterExpression bson.D          `bson:"partialFilterExpression"`
+	WildcardProjection      bson.D          `bson:"wildcardProjection"`
+	Collation               *indexCollation `bson:"collation"`
+	Weights                 bson.D          `bson:"weights"`
+	DefaultLanguage         *string         `bson:"default_language"`
+	LanguageOverride        *string         `bson:"language_override"`
+	TextIndexVersion        *int32          `bson:"textIndexVersion"`
+	SphereIndexVersion      *int32          `bson:"2dsphereIndexVersion"`
+	Bits                    *int32          `bson:"bits"`
+	Min                     *float64        `bson:"min"`
+	Max                     *float64        `bson:"max"`
+}
+
+// indexCollation decodes the camelCase collation document, which options.Collation doesn't map
+type indexCollation struct {
+	Locale          string `bson:"locale"`
+	CaseLevel       bool   `bson:"caseLevel"`
+	CaseFirst       string `bson:"caseFirst"`
+	Strength        int    `bson:"strength"`
+	NumericOrdering bool   `bson:"numericOrdering"`
+	Alternate       string `bson:"alternate"`
+	MaxVariable     string `bson:"maxVariable"`
+	Normalization   bool   `bson:"normalization"`
+	Backwards       bool   `bson:"backwards"`
+}
+
+// model converts the listed index back into what CreateMany needs to build it again
+func (s indexSpec) model() mongo.IndexModel {
+	opts := options.Index().SetName(s.Name)
+	if s.Unique != nil {
+		opts.SetUnique(*s.Unique)
+	}
+	if s.Sparse != nil {
+		opts.SetSparse(*s.Sparse)
+	}
+	if s.Hidden != nil {
+		opts.SetHidden(*s.Hidden)
+	}
+	if s.ExpireAfterSeconds != nil {
+		opts.SetExpireAfterSeconds(*s.ExpireAfterSeconds)
+	}
+	if s.PartialFilterExpression != nil {
+		opts.SetPartialFilterExpression(s.PartialFilterExpression)
+	}
+	if s.WildcardProjection != nil {
+		opts.SetWildcardProjection(s.WildcardProjection)
+	}
+	if c := s.Collation; c != nil {
+		opts.SetCollation(&options.Collation{
+			Locale:          c.Locale,
+			CaseLevel:       c.CaseLevel,
+			CaseFirst:       c.CaseFirst,
+			Strength:        c.Strength,
+			NumericOrdering: c.NumericOrdering,
+			Alternate:       c.Alternate,
+			MaxVariable:     c.MaxVariable,
+			Normalization:   c.Normalization,
+			Backwards:       c.Backwards,
+		})
+	}
+	if s.Weights != nil {
+		opts.SetWeights(s.Weights)
+	}
+	if s.DefaultLanguage != nil {
+		opts.SetDefaultLanguage(*s.DefaultLanguage)
+	}
+	if s.LanguageOverride != nil {
+		opts.SetLanguageOverride(*s.LanguageOverride)
+	}
+	if s.TextIndexVersion != nil {
+		opts.SetTextVersion(*s.TextIndexVersion)
+	}
+	if s.SphereIndexVersion != nil {
+		opts.SetSphereVersion(*s.SphereIndexVersion)
+	}
+	if s.Bits != nil {
+		opts.SetBits(*s.Bits)
+	}
+	if s.Min != nil {
+		opts.SetMin(*s.Min)
+	}
+	if s.Max != nil {
+		opts.SetMax(*s.Max)
+	}
+	return mongo.IndexModel{Keys: s.Key, Options: opts}
+}
+
+// copyIndexes recreates the indexes of source on target, except the default _id_ index,
+// and returns how many were copied
+func copyIndexes(ctx context.Context, source, target *mongo.Collection) (int, error) {
+	cursor, err := source.Indexes().List(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list indexes of %s: %v", source.Name(), err)
+	}
+	var specs []indexSpec
+	if err := cursor.All(ctx, &specs); err != nil {
+		return 0, fmt.Errorf("failed to read indexes of %s: %v", source.Name(), err)
+	}
+
+	var models []mongo.IndexModel
+	for _, spec := range specs {
+		if spec.Name == "_id_" {
+			continue
+		}
+		models = append(models, spec.model())
+	}
+	if len(models) == 0 {
+		return 0, nil
+	}
+
+	if _, err := target.Indexes().CreateMany(ctx, models); err != nil {
+		return 0, fmt.Errorf("failed to create indexes on %s: %v", target.Name(), err)
+	}
+	return len(models), nil
+}
+
+// createCollectionLike creates collName on target with the options of the source collection,
+// e.g. capped size or validator, before cloneMongoDB inserts into it. An existing target
+// collection is left as it is.
+func createCollectionLike(ctx context.Context, source, target *mongo.Database, collName string) error {
+	specs, err := source.ListCollectionSpecifications(ctx, bson.D{{Key: "name", Value: collName}})
+	if err != nil {
+		return fmt.Errorf("failed to read options of %s: %v", collName, err)
+	}
+	// Views are cloned as plain collections holding their documents
+	if len(specs) == 0 || specs[0].Type != "collection" || len(specs[0].Options) == 0 {
+		return nil
+	}
+
+	command := bson.D{{Key: "create", Value: collName}}
+	elements, err := specs[0].Options.Elements()
+	if err != nil {
+		return fmt.Errorf("failed to read options of %s: %v", collName, err)
+	}
+	for _, element := range elements {
+		command = append(command, bson.E{Key: element.Key(), Value: element.Value()})
+	}
+	if len(command) == 1 {
+		return nil
+	}
+
+	err = target.RunCommand(ctx, command).Err()
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Name == "NamespaceExists" {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create collection %s: %v", collName, err)
+	}
+	return nil
+}
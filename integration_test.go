@@ -0,0 +1,278 @@
+//go:build integration
+
+package main
+
+// The integration tests run the migrations against MySQL and MongoDB containers, so they
+// need Docker:
+//
+//	go test -tags integration .
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/testcontainers/testcontainers-go/modules/mongodb"
+	tcmysql "github.com/testcontainers/testcontainers-go/modules/mysql"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	integrationMySQLImage = "mysql:8.0.36"
+	integrationMongoImage = "mongo:6"
+)
+
+// courseLessonItemsSchema is the source table MigrateCourseLessonItems reads
+const courseLessonItemsSchema = `CREATE TABLE CourseLessonItems (
+	Id INT PRIMARY KEY,
+	LessonId INT NOT NULL,
+	Title VARCHAR(255) NOT NULL,
+	Description TEXT NOT NULL,
+	Content TEXT NULL,
+	Time INT NOT NULL,
+	VideoUrl VARCHAR(255) NULL,
+	Type INT NOT NULL,
+	RefId VARCHAR(64) NOT NULL,
+	` + "`Order`" + ` INT NOT NULL,
+	IsPublished TINYINT(1) NOT NULL,
+	QuestionIds TEXT NULL,
+	MaxSubmitCount INT NULL,
+	TenantId INT NOT NULL,
+	IsDeleted TINYINT(1) NOT NULL,
+	Created DATETIME NULL,
+	LastModified DATETIME NULL,
+	CreatedBy VARCHAR(64) NULL,
+	LastModifiedBy VARCHAR(64) NULL
+)`
+
+// startMySQL runs a MySQL container for the test and returns the DSN of its root user
+func startMySQL(t *testing.T, database string) string {
+	t.Helper()
+	ctx := context.Background()
+
+	container, err := tcmysql.Run(ctx, integrationMySQLImage,
+		tcmysql.WithUsername("root"),
+		tcmysql.WithPassword("secret"),
+		tcmysql.WithDatabase(database))
+	if err != nil {
+		t.Fatalf("failed to start MySQL: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Terminate(context.Background()); err != nil {
+			t.Logf("failed to stop MySQL: %v", err)
+		}
+	})
+
+	dsn, err := container.ConnectionString(ctx)
+	if err != nil {
+		t.Fatalf("failed to get the MySQL DSN: %v", err)
+	}
+	return dsn
+}
+
+// startMongo runs a standalone MongoDB container for the test and returns a connected client
+// and its URI
+func startMongo(t *testing.T) (*mongo.Client, string) {
+	t.Helper()
+	ctx := context.Background()
+
+	container, err := mongodb.Run(ctx, integrationMongoImage)
+	if err != nil {
+		t.Fatalf("failed to start MongoDB: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Terminate(context.Background()); err != nil {
+			t.Logf("failed to stop MongoDB: %v", err)
+		}
+	})
+
+	uri, err := container.ConnectionString(ctx)
+	if err != nil {
+		t.Fatalf("failed to get the MongoDB URI: %v", err)
+	}
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("failed to connect to MongoDB: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	return client, uri
+}
+
+func countDocuments(t *testing.T, collection *mongo.Collection) int64 {
+	t.Helper()
+	count, err := collection.CountDocuments(context.Background(), bson.M{})
+	if err != nil {
+		t.Fatalf("failed to count %s: %v", collection.Name(), err)
+	}
+	return count
+}
+
+func TestMigrateCourseLessonItemsIntegration(t *testing.T) {
+	ctx := context.Background()
+	dsn := startMySQL(t, "lms")
+	client, uri := startMongo(t)
+
+	mysqlDB, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatalf("failed to open MySQL: %v", err)
+	}
+	defer mysqlDB.Close()
+	if _, err := mysqlDB.Exec(courseLessonItemsSchema); err != nil {
+		t.Fatalf("failed to create CourseLessonItems: %v", err)
+	}
+	// item 5 has no Title and is quarantined by the default rules
+	rows := []struct {
+		id, tenantId int
+		title        string
+		questionIds  interface{}
+	}{
+		{1, 1, "Intro", "1,2"},
+		{2, 1, "Quiz", `["3","4"]`},
+		{3, 1, "Reading", nil},
+		{4, 2, "Video", nil},
+		{5, 2, "", nil},
+	}
+	for _, row := range rows {
+		if _, err := mysqlDB.Exec(`INSERT INTO CourseLessonItems
+			(Id, LessonId, Title, Description, Time, Type, RefId, `+"`Order`"+`, IsPublished, QuestionIds,
+			TenantId, IsDeleted, Created, LastModified)
+			VALUES (?, 10, ?, '', 60, 1, '', ?, 1, ?, ?, 0, '2024-01-02 03:04:05', '2024-01-03 03:04:05')`,
+			row.id, row.title, row.id, row.questionIds, row.tenantId); err != nil {
+			t.Fatalf("failed to seed CourseLessonItems: %v", err)
+		}
+	}
+
+	db := client.Database("lms")
+	assignments := db.Collection("ItemAssignmentData")
+	for _, row := range rows[:4] {
+		if _, err := assignments.InsertOne(ctx, bson.M{"ItemId": row.id, "TenantId": row.tenantId}); err != nil {
+			t.Fatalf("failed to seed ItemAssignmentData: %v", err)
+		}
+	}
+
+	config := DefaultCourseLessonItemConfig()
+	config.MySQLDSN = dsn
+	config.MongoURI = uri
+
+	mapping, err := MigrateCourseLessonItems(config)
+	if err != nil {
+		t.Fatalf("MigrateCourseLessonItems() error = %v", err)
+	}
+	if len(mapping) != 4 {
+		t.Errorf("mapping has %d items, want 4", len(mapping))
+	}
+	if got := countDocuments(t, db.Collection(config.TargetCollection)); got != 4 {
+		t.Errorf("%s has %d documents, want 4", config.TargetCollection, got)
+	}
+	if got := countDocuments(t, db.Collection(config.QuarantineCollection)); got != 1 {
+		t.Errorf("%s has %d documents, want 1", config.QuarantineCollection, got)
+	}
+
+	cursor, err := assignments.Find(ctx, bson.M{})
+	if err != nil {
+		t.Fatalf("failed to read ItemAssignmentData: %v", err)
+	}
+	var linked []struct {
+		ItemId    int    `bson:"ItemId"`
+		NewItemId string `bson:"NewItemId"`
+	}
+	if err := cursor.All(ctx, &linked); err != nil {
+		t.Fatalf("failed to read ItemAssignmentData: %v", err)
+	}
+	for _, doc := range linked {
+		if want := mapping[doc.ItemId].CourseLessonItemId; doc.NewItemId == "" || doc.NewItemId != want {
+			t.Errorf("ItemAssignmentData of item %d has NewItemId %q, want %q", doc.ItemId, doc.NewItemId, want)
+		}
+	}
+
+	// A second run keeps the migrated items and maps them to the same ids
+	again, err := MigrateCourseLessonItems(config)
+	if err != nil {
+		t.Fatalf("second MigrateCourseLessonItems() error = %v", err)
+	}
+	if !reflect.DeepEqual(again, mapping) {
+		t.Errorf("second run mapping = %v, want %v", again, mapping)
+	}
+	if got := countDocuments(t, db.Collection(config.TargetCollection)); got != 4 {
+		t.Errorf("%s has %d documents after a second run, want 4", config.TargetCollection, got)
+	}
+
+	// and the new ids can be copied back into MySQL
+	if _, err := mysqlDB.Exec("CREATE TABLE ItemMap (OldId INT PRIMARY KEY, NewItemId VARCHAR(36) NOT NULL)"); err != nil {
+		t.Fatalf("failed to create ItemMap: %v", err)
+	}
+	written, err := MigrateMongoToMySQL(MongoToMySQLConfig{
+		MongoURI:     uri,
+		DatabaseName: "lms",
+		Collection:   config.TargetCollection,
+		MySQLDSN:     dsn,
+		Table:        "ItemMap",
+		Fields:       map[string]string{"OldId": "OldId", "CourseLessonItemId": "NewItemId"},
+	})
+	if err != nil {
+		t.Fatalf("MigrateMongoToMySQL() error = %v", err)
+	}
+	if written != 4 {
+		t.Errorf("MigrateMongoToMySQL() wrote %d rows, want 4", written)
+	}
+	var newItemId string
+	if err := mysqlDB.QueryRow("SELECT NewItemId FROM ItemMap WHERE OldId = 2").Scan(&newItemId); err != nil {
+		t.Fatalf("failed to read ItemMap: %v", err)
+	}
+	if want := mapping[2].CourseLessonItemId; newItemId != want {
+		t.Errorf("ItemMap NewItemId of item 2 = %q, want %q", newItemId, want)
+	}
+}
+
+func TestCloneMongoDBIntegration(t *testing.T) {
+	ctx := context.Background()
+	client, uri := startMongo(t)
+
+	source := client.Database("app")
+	want := map[string]int{"users": 5, "orders": 3}
+	for name, count := range want {
+		docs := make([]interface{}, count)
+		for i := range docs {
+			docs[i] = bson.M{"n": i, "tags": bson.A{"a", "b"}}
+		}
+		if _, err := source.Collection(name).InsertMany(ctx, docs); err != nil {
+			t.Fatalf("failed to seed %s: %v", name, err)
+		}
+	}
+	if _, err := source.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "n", Value: 1}},
+		Options: options.Index().SetName("n_1").SetUnique(true),
+	}); err != nil {
+		t.Fatalf("failed to create the users index: %v", err)
+	}
+
+	if err := cloneMongoDB(uri, "app", uri, "app_clone", CloneOptions{BatchSize: 2}); err != nil {
+		t.Fatalf("cloneMongoDB() error = %v", err)
+	}
+	// a second upserting clone brings the target up to date without duplicates
+	if err := cloneMongoDB(uri, "app", uri, "app_clone", CloneOptions{BatchSize: 2, Upsert: true}); err != nil {
+		t.Fatalf("upserting cloneMongoDB() error = %v", err)
+	}
+
+	target := client.Database("app_clone")
+	for name, count := range want {
+		if got := countDocuments(t, target.Collection(name)); got != int64(count) {
+			t.Errorf("cloned %s has %d documents, want %d", name, got, count)
+		}
+	}
+
+	specs, err := target.Collection("users").Indexes().ListSpecifications(ctx)
+	if err != nil {
+		t.Fatalf("failed to list the cloned indexes: %v", err)
+	}
+	var copied bool
+	for _, spec := range specs {
+		copied = copied || spec.Name == "n_1"
+	}
+	if !copied {
+		t.Errorf("cloned users indexes = %v, want n_1 among them", specs)
+	}
+}
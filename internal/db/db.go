@@ -0,0 +1,55 @@
+// Package db opens the MongoDB and MySQL connections shared by the migration tools, checking
+// each one is reachable before it is returned.
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ConnectTimeout bounds connecting to a database and the ping checking it answers
+const ConnectTimeout = 10 * time.Second
+
+// ConnectMongo connects to the MongoDB deployment at uri and pings it
+func ConnectMongo(uri string) (*mongo.Client, error) {
+	return ConnectMongoWithOptions(options.Client().ApplyURI(uri))
+}
+
+// ConnectMongoWithOptions connects with client options built by the caller, e.g. for TLS,
+// and pings the deployment
+func ConnectMongoWithOptions(opts *options.ClientOptions) (*mongo.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ConnectTimeout)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
+		return nil, fmt.Errorf("failed to ping MongoDB: %w", err)
+	}
+	return client, nil
+}
+
+// ConnectMySQL opens the MySQL database of dsn and pings it
+func ConnectMySQL(dsn string) (*sql.DB, error) {
+	mysqlDB, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open MySQL: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ConnectTimeout)
+	defer cancel()
+	if err := mysqlDB.PingContext(ctx); err != nil {
+		mysqlDB.Close()
+		return nil, fmt.Errorf("failed to ping MySQL: %w", err)
+	}
+	return mysqlDB, nil
+}
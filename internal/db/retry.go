@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// DefaultConnectAttempts and DefaultConnectRetryDelay are used when Retry leaves them zero
+	DefaultConnectAttempts   = 5
+	DefaultConnectRetryDelay = time.Second
+
+	// MaxConnectRetryDelay caps the doubled delay between attempts
+	MaxConnectRetryDelay = 30 * time.Second
+)
+
+// mongoNotPrimaryCodes are the server error codes of a node that is stepping down, restarting or
+// not yet primary, which go away once the replica set has a primary again
+var mongoNotPrimaryCodes = []int{91, 189, 10107, 11600, 11602, 13435, 13436}
+
+// Retry configures ConnectWithRetry
+type Retry struct {
+	// MaxAttempts is the number of pings before giving up, DefaultConnectAttempts when zero
+	MaxAttempts int
+	// Delay is the wait before the first retry, doubled after each attempt up to
+	// MaxConnectRetryDelay, DefaultConnectRetryDelay when zero
+	Delay time.Duration
+	// Warn, when set, is told about each failed attempt before it is retried
+	Warn func(message string)
+}
+
+// ConnectWithRetry calls ping until it succeeds, each attempt bounded by ConnectTimeout. An error
+// transient rejects is returned at once, the others are retried with an exponential backoff until
+// MaxAttempts. It gives up when ctx is done.
+func ConnectWithRetry(ctx context.Context, name string, retry Retry, ping func(ctx context.Context) error, transient func(error) bool) error {
+	maxAttempts := retry.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultConnectAttempts
+	}
+	delay := retry.Delay
+	if delay <= 0 {
+		delay = DefaultConnectRetryDelay
+	}
+
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, ConnectTimeout)
+		err := ping(attemptCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		if !transient(err) {
+			return fmt.Errorf("failed to connect to %s: %w", name, err)
+		}
+		if attempt >= maxAttempts {
+			return fmt.Errorf("failed to connect to %s after %d attempts: %w", name, attempt, err)
+		}
+
+		if retry.Warn != nil {
+			retry.Warn(fmt.Sprintf("Connecting to %s failed (attempt %d/%d): %v, retrying in %v", name, attempt, maxAttempts, err, delay))
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return fmt.Errorf("connecting to %s cancelled: %w", name, ctx.Err())
+		}
+		delay = min(delay*2, MaxConnectRetryDelay)
+	}
+}
+
+// IsTransientMongoError reports whether a MongoDB error may go away by retrying: a network error,
+// a timeout, or a command error of a node that is not primary or labeled retryable by the server.
+// Anything else, e.g. failed authentication, is returned at once.
+func IsTransientMongoError(err error) bool {
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) {
+		return false
+	}
+	if cmdErr.HasErrorLabel("RetryableWriteError") || cmdErr.HasErrorLabel("TransientTransactionError") {
+		return true
+	}
+	for _, code := range mongoNotPrimaryCodes {
+		if cmdErr.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTransientSQLError reports whether a MySQL or PostgreSQL error may go away by retrying, which
+// is every error but bad credentials and a missing database
+func IsTransientSQLError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case 1044, 1045, 1049: // access denied to database, access denied for user, unknown database
+			return false
+		}
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "28000", "28P01", "3D000": // invalid authorization, invalid password, unknown database
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestIsTransientMongoError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "deadline", err: fmt.Errorf("server selection error: %w", context.DeadlineExceeded), want: true},
+		{name: "network label", err: mongo.CommandError{Code: 6, Labels: []string{"NetworkError"}}, want: true},
+		{name: "primary stepped down", err: mongo.CommandError{Code: 189, Name: "PrimarySteppedDown"}, want: true},
+		{name: "retryable label", err: mongo.CommandError{Code: 1, Labels: []string{"RetryableWriteError"}}, want: true},
+		{name: "authentication failed", err: mongo.CommandError{Code: 18, Name: "AuthenticationFailed"}, want: false},
+		{name: "wrapped unauthorized", err: fmt.Errorf("ping: %w", mongo.CommandError{Code: 13, Name: "Unauthorized"}), want: false},
+		{name: "other error", err: errors.New("invalid URI"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransientMongoError(tt.err); got != tt.want {
+				t.Errorf("IsTransientMongoError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsTransientSQLError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "connection refused", err: errors.New("dial tcp 127.0.0.1:3306: connect: connection refused"), want: true},
+		{name: "too many connections", err: &mysql.MySQLError{Number: 1040}, want: true},
+		{name: "access denied", err: &mysql.MySQLError{Number: 1045}, want: false},
+		{name: "unknown database", err: fmt.Errorf("ping: %w", &mysql.MySQLError{Number: 1049}), want: false},
+		{name: "invalid password", err: &pgconn.PgError{Code: "28P01"}, want: false},
+		{name: "postgres starting up", err: &pgconn.PgError{Code: "57P03"}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransientSQLError(tt.err); got != tt.want {
+				t.Errorf("IsTransientSQLError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnectWithRetry(t *testing.T) {
+	transientErr := errors.New("connection refused")
+	permanentErr := errors.New("access denied")
+	transient := func(err error) bool { return err == transientErr }
+
+	tests := []struct {
+		name         string
+		failures     []error
+		wantAttempts int
+		wantErr      error
+	}{
+		{name: "first attempt", wantAttempts: 1},
+		{name: "transient failures", failures: []error{transientErr, transientErr}, wantAttempts: 3},
+		{name: "permanent failure", failures: []error{transientErr, permanentErr}, wantAttempts: 2, wantErr: permanentErr},
+		{name: "out of attempts", failures: []error{transientErr, transientErr, transientErr, transientErr}, wantAttempts: 3, wantErr: transientErr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			ping := func(context.Context) error {
+				attempts++
+				if attempts <= len(tt.failures) {
+					return tt.failures[attempts-1]
+				}
+				return nil
+			}
+
+			var warnings int
+			retry := Retry{MaxAttempts: 3, Delay: time.Millisecond, Warn: func(string) { warnings++ }}
+			err := ConnectWithRetry(context.Background(), "test", retry, ping, transient)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil) != (err == nil) {
+				t.Fatalf("ConnectWithRetry() error = %v, want %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+			if wantWarnings := min(len(tt.failures), tt.wantAttempts-1); warnings != wantWarnings {
+				t.Errorf("warnings = %d, want %d", warnings, wantWarnings)
+			}
+		})
+	}
+}
+
+func TestConnectWithRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := ConnectWithRetry(ctx, "test", Retry{Delay: time.Hour}, func(context.Context) error {
+		return errors.New("connection refused")
+	}, func(error) bool { return true })
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ConnectWithRetry() error = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/duymanh3602/migrate-tool/internal/env"
+)
+
+// Timeouts bound the database work of the tools. A zero duration means no deadline.
+type Timeouts struct {
+	// Operation bounds one step of the work: a query, a write, or one document or batch of a
+	// cursor loop, which gets a new deadline per step rather than one for the whole loop
+	Operation time.Duration
+	// Scan bounds reading a whole table or collection in one query, e.g. every user id of MySQL
+	Scan time.Duration
+}
+
+// DefaultTimeouts are the Timeouts used unless the tools are configured otherwise
+func DefaultTimeouts() Timeouts {
+	return Timeouts{Operation: 60 * time.Second, Scan: 10 * time.Minute}
+}
+
+// TimeoutsFromEnv returns DefaultTimeouts overridden by the env.OperationTimeout and
+// env.ScanTimeout variables, durations such as 90s or 15m
+func TimeoutsFromEnv() (Timeouts, error) {
+	timeouts := DefaultTimeouts()
+	for _, setting := range []struct {
+		name     string
+		duration *time.Duration
+	}{
+		{env.OperationTimeout, &timeouts.Operation},
+		{env.ScanTimeout, &timeouts.Scan},
+	} {
+		value := env.Get(setting.name)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return timeouts, fmt.Errorf("invalid %s %q, expected a duration such as 90s or 15m", setting.name, value)
+		}
+		*setting.duration = d
+	}
+	return timeouts, nil
+}
+
+// Step returns the context of one step of an operation, bounded by Operation
+func (t Timeouts) Step(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, t.Operation)
+}
+
+// ScanContext returns the context of a whole table or collection scan, bounded by Scan
+func (t Timeouts) ScanContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, t.Scan)
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
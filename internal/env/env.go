@@ -0,0 +1,83 @@
+// Package env loads the settings shared by the migration tools from a .env file and the
+// environment, so the whole toolkit can be configured in one place.
+package env
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// File is the .env file read by Load, from the working directory
+const File = ".env"
+
+// Variables read by the tools
+const (
+	// MongoURI, DBName and MySQLDSN are the MongoDB deployment, its database and the MySQL
+	// database the lms tools work on
+	MongoURI = "MONGO_URI"
+	DBName   = "DB_NAME"
+	MySQLDSN = "MYSQL_DSN"
+
+	// TargetMongoURI and TargetDBName are where cloneMongoDB copies the database to
+	TargetMongoURI = "TARGET_MONGO_URI"
+	TargetDBName   = "TARGET_DB_NAME"
+
+	// The source and destination databases of migrate, overridden by its flags
+	SourceDriver   = "SOURCE_DRIVER"
+	SourceHost     = "SOURCE_HOST"
+	SourcePort     = "SOURCE_PORT"
+	SourceUser     = "SOURCE_USER"
+	SourcePassword = "SOURCE_PASSWORD"
+	SourceDB       = "SOURCE_DB"
+	DestDriver     = "DEST_DRIVER"
+	DestHost       = "DEST_HOST"
+	DestPort       = "DEST_PORT"
+	DestUser       = "DEST_USER"
+	DestPassword   = "DEST_PASSWORD"
+	DestDB         = "DEST_DB"
+
+	// OperationTimeout and ScanTimeout override the db.DefaultTimeouts of the lms tools
+	OperationTimeout = "OPERATION_TIMEOUT"
+	ScanTimeout      = "SCAN_TIMEOUT"
+)
+
+// Load adds the variables of File to the environment. Variables already set in the environment
+// keep their value, and a missing File is not an error.
+func Load() error {
+	err := godotenv.Load(File)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", File, err)
+	}
+	return nil
+}
+
+// Get returns the value of the variable name, empty when it is not set
+func Get(name string) string {
+	return strings.TrimSpace(os.Getenv(name))
+}
+
+// Require returns the values of the variables names, in the same order, or an error listing
+// all of those that are not set
+func Require(names ...string) ([]string, error) {
+	values := make([]string, len(names))
+	var missing []string
+	for i, name := range names {
+		values[i] = Get(name)
+		if values[i] == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing environment variables %s, set them in %s or the environment",
+			strings.Join(missing, ", "), File)
+	}
+	return values, nil
+}
@@ -0,0 +1,195 @@
+// Package log is the leveled logger shared by the migration tools. Messages go to the console
+// and, when a file is given, are appended to it as well.
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level orders log messages by severity
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+// String returns the name of the level, as accepted by ParseLevel
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// Supported log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// ParseLevel converts a log level name, empty meaning info
+func ParseLevel(name string) (Level, error) {
+	if name == "" {
+		return LevelInfo, nil
+	}
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q, use debug, info, warn or error", name)
+}
+
+// Verbosity returns the lowest level logged under the -quiet and -verbose flags of the tools:
+// quiet leaves out the per-batch progress, verbose adds debug messages
+func Verbosity(quiet, verbose bool) Level {
+	switch {
+	case quiet:
+		return LevelWarn
+	case verbose:
+		return LevelDebug
+	}
+	return LevelInfo
+}
+
+// clearLine moves the cursor to the start of the terminal line and erases it
+const clearLine = "\r\033[K"
+
+// output is shared by a Logger and the table loggers derived from it
+type output struct {
+	mu       sync.Mutex
+	file     *os.File
+	minLevel Level
+	json     bool
+	// status is redrawn on the last terminal line below the log messages, see SetStatus
+	status string
+}
+
+// Logger handles logging to file and console
+type Logger struct {
+	out *output
+	// table is added to the messages of a logger returned by ForTable
+	table string
+}
+
+// entry is one line of the json log format
+type entry struct {
+	Time    string `json:"ts"`
+	Level   string `json:"level"`
+	Message string `json:"msg"`
+	Table   string `json:"table,omitempty"`
+}
+
+// New returns a logger writing messages of level and above in format, also appended to filename
+// unless it is empty
+func New(filename, level, format string) (*Logger, error) {
+	minLevel, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	if format != "" && format != FormatText && format != FormatJSON {
+		return nil, fmt.Errorf("unknown log format %q, use text or json", format)
+	}
+
+	out := &output{minLevel: minLevel, json: format == FormatJSON}
+	if filename != "" {
+		out.file, err = os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Logger{out: out}, nil
+}
+
+// NewConsole returns a text logger writing messages of minLevel and above to the console only
+func NewConsole(minLevel Level) *Logger {
+	return &Logger{out: &output{minLevel: minLevel}}
+}
+
+// ForTable returns a logger writing to the same output that tags its messages with a table
+func (l *Logger) ForTable(table string) *Logger {
+	return &Logger{out: l.out, table: table}
+}
+
+func (l *Logger) Debug(message string) { l.write(LevelDebug, message) }
+func (l *Logger) Info(message string)  { l.write(LevelInfo, message) }
+func (l *Logger) Warn(message string)  { l.write(LevelWarn, message) }
+func (l *Logger) Error(message string) { l.write(LevelError, message) }
+
+// Log writes an info message
+func (l *Logger) Log(message string) {
+	l.Info(message)
+}
+
+// Summary writes an info message whatever the level, for the totals reported at the end of a run
+func (l *Logger) Summary(message string) {
+	l.print(LevelInfo, message)
+}
+
+func (l *Logger) write(level Level, message string) {
+	if level < l.out.minLevel {
+		return
+	}
+	l.print(level, message)
+}
+
+func (l *Logger) print(level Level, message string) {
+	out := l.out
+	now := time.Now()
+	var logMsg string
+	if out.json {
+		line, _ := json.Marshal(entry{
+			Time:    now.Format(time.RFC3339Nano),
+			Level:   levelNames[level],
+			Message: message,
+			Table:   l.table,
+		})
+		logMsg = string(line) + "\n"
+	} else {
+		logMsg = fmt.Sprintf("[%s] %-5s %s\n", now.Format("2006-01-02 15:04:05"),
+			strings.ToUpper(levelNames[level]), message)
+	}
+
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	if out.status != "" {
+		fmt.Print(clearLine)
+	}
+	fmt.Print(logMsg)
+	if out.file != nil {
+		out.file.WriteString(logMsg)
+	}
+	if out.status != "" {
+		fmt.Print(out.status)
+	}
+}
+
+// SetStatus replaces the status line shown on the terminal, it is not written to the log file.
+// An empty status removes it.
+func (l *Logger) SetStatus(status string) {
+	out := l.out
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	if out.status != "" || status != "" {
+		fmt.Print(clearLine + status)
+	}
+	out.status = status
+}
+
+func (l *Logger) Close() {
+	if l.out.file != nil {
+		l.out.file.Close()
+	}
+}
@@ -3,10 +3,16 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	appdb "github.com/duymanh3602/migrate-tool/internal/db"
+	"github.com/duymanh3602/migrate-tool/internal/env"
+	applog "github.com/duymanh3602/migrate-tool/internal/log"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -14,49 +20,117 @@ import (
 
 // Configuration struct
 type MigrationConfig struct {
-	ConnectionURI  string
-	DatabaseName   string
+	ConnectionURI  string // MONGO_URI of the environment when empty
+	DatabaseName   string // DB_NAME of the environment when empty
 	CollectionName string
-	FieldName      string
+	FieldName      string // Dot-separated for nested fields, e.g. "Metadata.Payload"
 	NewFieldName   string // Optional: if you want to create a new field instead of updating existing
 	BatchSize      int64
+	Workers        int // Batches converted and written at the same time (default 4)
 	DryRun         bool
+	Quiet          bool // Only print warnings and the final summary, not every batch
+	Verbose        bool // Also print debug messages
+	Backup         bool // Copy the collection before converting it, ignored in DryRun
+	// SkipInvalidJSON leaves strings that aren't valid JSON unchanged and counts them as skipped
+	// instead of failed, so a collection mixing JSON and plain text can be converted
+	SkipInvalidJSON bool
+
+	// Rollback undoes a migration instead of running it, restoring the documents from
+	// BackupCollection, or from the latest backup recorded when it is empty
+	Rollback         bool
+	BackupCollection string
+
+	// DepthPolicy handles converted documents nested deeper than MaxDepth (default 100, MongoDB's limit):
+	// "skip" writes them to DeadLetterCollection and leaves them unchanged, "flatten" stores any
+	// object nested below FlattenDepth as a JSON string
+	MaxDepth             int
+	DepthPolicy          string
+	FlattenDepth         int
+	DeadLetterCollection string
+}
+
+const (
+	depthPolicySkip    = "skip"
+	depthPolicyFlatten = "flatten"
+
+	defaultMaxDepth = 100
+
+	// batches converted at the same time when Workers is unset
+	defaultWorkers = 4
+)
+
+// logger prints the progress of the migration, at the verbosity set by runMigration
+var logger = applog.NewConsole(applog.LevelInfo)
+
+// timeouts bound the conversion, backup and rollback: Scan each collection read in one cursor,
+// Operation each batch and each single read or write
+var timeouts = appdb.DefaultTimeouts()
+
+// depthLimitError reports a converted document nested deeper than the configured limit
+type depthLimitError struct {
+	Depth int
+	Limit int
+}
+
+func (e *depthLimitError) Error() string {
+	return fmt.Sprintf("document nests %d levels, limit is %d", e.Depth, e.Limit)
 }
 
 // Document represents a generic MongoDB document
 type Document map[string]interface{}
 
-// func main() {
-// 	// Configuration - modify these values according to your setup
-// 	config := MigrationConfig{
-// 		ConnectionURI:  "mongodb://localhost:27017", // Change to your MongoDB URI
-// 		DatabaseName:   "lms_dev",                   // Change to your database name
-// 		CollectionName: "NewCourseLessonItem",       // Change to your collection name
-// 		FieldName:      "Content",                   // Field containing JSON string
-// 		NewFieldName:   "",                          // Leave empty to update same field, or specify new field name
-// 		BatchSize:      100,                         // Process documents in batches
-// 		DryRun:         true,                        // Set to false to actually perform migration
-// 	}
-
-// 	if err := runMigration(config); err != nil {
-// 		log.Fatal("Migration failed:", err)
-// 	}
-// }
+func main() {
+	// MONGO_URI and DB_NAME are read from .env or the environment
+	if err := env.Load(); err != nil {
+		log.Fatal("Configuration failed:", err)
+	}
+
+	// Configuration - modify these values according to your setup
+	config := MigrationConfig{
+		CollectionName:  "NewCourseLessonItem", // Change to your collection name
+		FieldName:       "Content",             // Field containing JSON string
+		NewFieldName:    "",                    // Leave empty to update same field, or specify new field name
+		BatchSize:       100,                   // Process documents in batches
+		DryRun:          true,                  // Set to false to actually perform migration
+		Backup:          true,                  // Copy the collection before it is converted
+		SkipInvalidJSON: true,                  // Leave plain text strings unchanged
+	}
+
+	if err := runMigration(config); err != nil {
+		log.Fatal("Migration failed:", err)
+	}
+}
 
 func runMigration(config MigrationConfig) error {
-	// Connect to MongoDB
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	logger = applog.NewConsole(applog.Verbosity(config.Quiet, config.Verbose))
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(config.ConnectionURI))
+	// The deployment and database default to MONGO_URI and DB_NAME
+	if config.ConnectionURI == "" {
+		config.ConnectionURI = env.Get(env.MongoURI)
+	}
+	if config.DatabaseName == "" {
+		config.DatabaseName = env.Get(env.DBName)
+	}
+	if config.ConnectionURI == "" || config.DatabaseName == "" {
+		return fmt.Errorf("ConnectionURI and DatabaseName are required, set %s and %s in %s or the environment",
+			env.MongoURI, env.DBName, env.File)
+	}
+
+	var err error
+	if timeouts, err = appdb.TimeoutsFromEnv(); err != nil {
+		return err
+	}
+
+	// Connect to MongoDB
+	ctx := context.Background()
+	client, err := appdb.ConnectMongo(config.ConnectionURI)
 	if err != nil {
-		return fmt.Errorf("failed to connect to MongoDB: %w", err)
+		return err
 	}
 	defer client.Disconnect(ctx)
 
-	// Test connection
-	if err := client.Ping(ctx, nil); err != nil {
-		return fmt.Errorf("failed to ping MongoDB: %w", err)
+	if config.Rollback {
+		return rollbackMigration(ctx, client, config, config.BackupCollection)
 	}
 
 	collection := client.Database(config.DatabaseName).Collection(config.CollectionName)
@@ -66,27 +140,45 @@ func runMigration(config MigrationConfig) error {
 		config.FieldName: bson.M{"$type": "string", "$ne": ""},
 	}
 
-	totalCount, err := collection.CountDocuments(ctx, filter)
+	countCtx, cancel := timeouts.ScanContext(ctx)
+	totalCount, err := collection.CountDocuments(countCtx, filter)
+	cancel()
 	if err != nil {
 		return fmt.Errorf("failed to count documents: %w", err)
 	}
 
-	fmt.Printf("Found %d documents to migrate\n", totalCount)
+	logger.Info(fmt.Sprintf("Found %d documents to migrate", totalCount))
 	if totalCount == 0 {
-		fmt.Println("No documents to migrate")
+		logger.Summary("No documents to migrate")
 		return nil
 	}
 
 	if config.DryRun {
-		fmt.Println("DRY RUN MODE - No actual changes will be made")
+		logger.Summary("DRY RUN MODE - No actual changes will be made")
 		return previewMigration(ctx, collection, config, filter)
 	}
 
+	// Copy the collection first, the conversion overwrites the original strings
+	if config.Backup {
+		backupName, err := createBackup(ctx, client, config)
+		if err != nil {
+			return fmt.Errorf("backup failed, migration aborted: %w", err)
+		}
+		if err := recordBackup(ctx, client, config, backupName); err != nil {
+			return fmt.Errorf("backup failed, migration aborted: %w", err)
+		}
+		logger.Summary(fmt.Sprintf("Run with Rollback to restore collection %s from %s if the migration has to be undone",
+			config.CollectionName, backupName))
+	}
+
 	// Perform actual migration
 	return performMigration(ctx, collection, config, filter)
 }
 
 func previewMigration(ctx context.Context, collection *mongo.Collection, config MigrationConfig, filter bson.M) error {
+	ctx, cancel := timeouts.Step(ctx)
+	defer cancel()
+
 	// Show sample of documents that will be migrated
 	cursor, err := collection.Find(ctx, filter, options.Find().SetLimit(5))
 	if err != nil {
@@ -94,122 +186,190 @@ func previewMigration(ctx context.Context, collection *mongo.Collection, config
 	}
 	defer cursor.Close(ctx)
 
-	fmt.Println("\nSample documents that will be migrated:")
+	logger.Summary("Sample documents that will be migrated:")
 	for cursor.Next(ctx) {
 		var doc Document
 		if err := cursor.Decode(&doc); err != nil {
-			log.Printf("Failed to decode document: %v", err)
+			logger.Warn(fmt.Sprintf("Failed to decode document: %v", err))
 			continue
 		}
 
-		fmt.Printf("Document ID: %v\n", doc["_id"])
-		fmt.Printf("Current %s: %v\n", config.FieldName, doc[config.FieldName])
+		logger.Summary(fmt.Sprintf("Document ID: %v", doc["_id"]))
+		logger.Summary(fmt.Sprintf("Current %s: %v", config.FieldName, fieldValue(doc, config.FieldName)))
 
 		// Try to parse the JSON string
-		if jsonStr, ok := doc[config.FieldName].(string); ok {
+		if jsonStr, ok := fieldValue(doc, config.FieldName).(string); ok {
 			var jsonObj interface{}
-			if err := json.Unmarshal([]byte(jsonStr), &jsonObj); err != nil {
-				fmt.Printf("⚠️  Invalid JSON in document %v: %v\n", doc["_id"], err)
+			if err := json.Unmarshal([]byte(jsonStr), &jsonObj); err != nil && config.SkipInvalidJSON {
+				logger.Summary(fmt.Sprintf("⏭️  Not JSON in document %v, will be skipped: %v", doc["_id"], err))
+			} else if err != nil {
+				logger.Summary(fmt.Sprintf("⚠️  Invalid JSON in document %v: %v", doc["_id"], err))
 			} else {
-				fmt.Printf("✅ Valid JSON - will be converted to: %v\n", jsonObj)
+				logger.Summary(fmt.Sprintf("✅ Valid JSON - will be converted to: %v", jsonObj))
 			}
 		}
-		fmt.Println("---")
+		logger.Summary("---")
 	}
 
 	return nil
 }
 
+// migrationCounts are the totals of performMigration, updated by its workers
+type migrationCounts struct {
+	processed  atomic.Int64
+	successful atomic.Int64
+	failed     atomic.Int64
+	skipped    atomic.Int64
+	tooDeep    atomic.Int64
+}
+
+// performMigration reads the matching documents with one cursor in _id order and hands them
+// out in batches of BatchSize to Workers goroutines, each converting its batch and writing it
+// with one BulkWrite
 func performMigration(ctx context.Context, collection *mongo.Collection, config MigrationConfig, filter bson.M) error {
-	fmt.Println("Starting migration...")
+	logger.Info("Starting migration...")
 
-	var processed int64
-	var successful int64
-	var failed int64
+	workers := config.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
 
-	// Use aggregation with batch processing
-	pipeline := []bson.M{
-		{"$match": filter},
-		{"$limit": config.BatchSize},
+	var counts migrationCounts
+	batches := make(chan []bson.Raw, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				migrateBatch(ctx, collection, config, batch, &counts)
+			}
+		}()
 	}
 
-	for {
-		cursor, err := collection.Aggregate(ctx, pipeline)
-		if err != nil {
-			return fmt.Errorf("failed to create aggregation cursor: %w", err)
-		}
+	// Converted documents no longer match filter, and _id order visits each document once
+	err := readBatches(ctx, collection, config, filter, batches)
+	close(batches)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
 
-		var batchProcessed int64
-		var bulkOps []mongo.WriteModel
+	logger.Summary("Migration completed!")
+	logger.Summary(fmt.Sprintf("Total processed: %d", counts.processed.Load()))
+	logger.Summary(fmt.Sprintf("Successful: %d", counts.successful.Load()))
+	logger.Summary(fmt.Sprintf("Failed: %d", counts.failed.Load()))
+	logger.Summary(fmt.Sprintf("Skipped (not JSON): %d", counts.skipped.Load()))
+	logger.Summary(fmt.Sprintf("Over nesting limit: %d", counts.tooDeep.Load()))
 
-		for cursor.Next(ctx) {
-			var doc Document
-			if err := cursor.Decode(&doc); err != nil {
-				log.Printf("Failed to decode document: %v", err)
-				failed++
-				continue
-			}
+	return nil
+}
 
-			// Process the document
-			updateDoc, err := processDocument(doc, config)
-			if err != nil {
-				log.Printf("Failed to process document %v: %v", doc["_id"], err)
-				failed++
-				continue
-			}
+// readBatches sends the documents matching filter to batches, BatchSize at a time. The cursor
+// reads the whole collection and is bounded by timeouts.Scan.
+func readBatches(ctx context.Context, collection *mongo.Collection, config MigrationConfig, filter bson.M, batches chan<- []bson.Raw) error {
+	ctx, cancel := timeouts.ScanContext(ctx)
+	defer cancel()
 
-			if updateDoc != nil {
-				// Create update operation
-				updateOp := mongo.NewUpdateOneModel().
-					SetFilter(bson.M{"_id": doc["_id"]}).
-					SetUpdate(bson.M{"$set": updateDoc})
-				bulkOps = append(bulkOps, updateOp)
-				batchProcessed++
-			}
-		}
-		cursor.Close(ctx)
-
-		// Execute bulk operations
-		if len(bulkOps) > 0 {
-			result, err := collection.BulkWrite(ctx, bulkOps)
-			if err != nil {
-				log.Printf("Bulk write failed: %v", err)
-				failed += batchProcessed
-			} else {
-				successful += result.ModifiedCount
-				fmt.Printf("Processed batch: %d successful updates\n", result.ModifiedCount)
-			}
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetBatchSize(int32(config.BatchSize))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return fmt.Errorf("failed to create cursor: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	batch := make([]bson.Raw, 0, config.BatchSize)
+	for cursor.Next(ctx) {
+		// Current is reused by the next call, the workers get a copy
+		batch = append(batch, append(bson.Raw(nil), cursor.Current...))
+		if int64(len(batch)) == config.BatchSize {
+			batches <- batch
+			batch = make([]bson.Raw, 0, config.BatchSize)
 		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("cursor error: %w", err)
+	}
+	if len(batch) > 0 {
+		batches <- batch
+	}
+	return nil
+}
 
-		processed += batchProcessed
+// migrateBatch converts a batch of documents and writes the updates with one BulkWrite. The
+// batch gets its own timeouts.Step deadline, apart from the cursor that read it.
+func migrateBatch(ctx context.Context, collection *mongo.Collection, config MigrationConfig, batch []bson.Raw, counts *migrationCounts) {
+	ctx, cancel := timeouts.Step(context.WithoutCancel(ctx))
+	defer cancel()
 
-		// Check if we've processed all documents or if batch was smaller than expected
-		if batchProcessed < config.BatchSize {
-			break
+	var bulkOps []mongo.WriteModel
+	for _, raw := range batch {
+		var doc Document
+		if err := bson.Unmarshal(raw, &doc); err != nil {
+			logger.Warn(fmt.Sprintf("Failed to decode document: %v", err))
+			counts.failed.Add(1)
+			continue
 		}
 
-		// Update skip for next batch
-		pipeline[1] = bson.M{"$skip": processed}
-	}
+		// Process the document
+		updateDoc, skip, err := processDocument(doc, config)
+		if skip {
+			counts.skipped.Add(1)
+			continue
+		}
+		var depthErr *depthLimitError
+		if errors.As(err, &depthErr) {
+			logger.Warn(fmt.Sprintf("Document %v exceeds the nesting limit: %v", doc["_id"], err))
+			if err := deadLetterDocument(ctx, collection, doc, config, err); err != nil {
+				logger.Warn(fmt.Sprintf("Failed to dead-letter document %v: %v", doc["_id"], err))
+			}
+			counts.tooDeep.Add(1)
+			continue
+		}
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Failed to process document %v: %v", doc["_id"], err))
+			counts.failed.Add(1)
+			continue
+		}
 
-	fmt.Printf("\nMigration completed!\n")
-	fmt.Printf("Total processed: %d\n", processed)
-	fmt.Printf("Successful: %d\n", successful)
-	fmt.Printf("Failed: %d\n", failed)
+		if updateDoc != nil {
+			// Create update operation
+			updateOp := mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": doc["_id"]}).
+				SetUpdate(updateDoc)
+			bulkOps = append(bulkOps, updateOp)
+		}
+	}
+	counts.processed.Add(int64(len(bulkOps)))
 
-	return nil
+	// Execute bulk operations
+	if len(bulkOps) > 0 {
+		result, err := collection.BulkWrite(ctx, bulkOps)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Bulk write failed: %v", err))
+			counts.failed.Add(int64(len(bulkOps)))
+			return
+		}
+		counts.successful.Add(result.ModifiedCount)
+		logger.Info(fmt.Sprintf("Processed batch: %d successful updates", result.ModifiedCount))
+	}
 }
 
-func processDocument(doc Document, config MigrationConfig) (Document, error) {
-	jsonStr, ok := doc[config.FieldName].(string)
+// processDocument returns the update operators converting the JSON string field of doc.
+// skip reports a string that isn't JSON under SkipInvalidJSON, leaving doc unchanged.
+func processDocument(doc Document, config MigrationConfig) (update Document, skip bool, err error) {
+	jsonStr, ok := fieldValue(doc, config.FieldName).(string)
 	if !ok {
-		return nil, fmt.Errorf("field %s is not a string", config.FieldName)
+		return nil, false, fmt.Errorf("field %s is not a string", config.FieldName)
 	}
 
 	// Parse JSON string
 	var jsonObj interface{}
 	if err := json.Unmarshal([]byte(jsonStr), &jsonObj); err != nil {
-		return nil, fmt.Errorf("invalid JSON: %w", err)
+		if config.SkipInvalidJSON {
+			return nil, true, nil
+		}
+		return nil, false, fmt.Errorf("invalid JSON: %w", err)
 	}
 
 	// Determine target field name
@@ -218,59 +378,148 @@ func processDocument(doc Document, config MigrationConfig) (Document, error) {
 		targetField = config.NewFieldName
 	}
 
+	// Check the document stays within MongoDB's nesting limit once converted
+	limit := config.MaxDepth
+	if limit <= 0 {
+		limit = defaultMaxDepth
+	}
+	if depth := 1 + valueDepth(jsonObj); depth > limit {
+		if config.DepthPolicy != depthPolicyFlatten {
+			return nil, false, &depthLimitError{Depth: depth, Limit: limit}
+		}
+		jsonObj = flattenBeyond(jsonObj, config.FlattenDepth-1)
+	}
+
 	// Create update document
 	updateDoc := Document{
-		targetField: jsonObj,
+		"$set": bson.M{targetField: jsonObj},
 	}
 
 	// If creating a new field, also remove the old field
-	if config.NewFieldName != "" && config.NewFieldName != config.FieldName {
-		updateDoc[config.FieldName] = nil // This will be handled with $unset in a separate operation
+	if targetField != config.FieldName {
+		updateDoc["$unset"] = bson.M{config.FieldName: ""}
+	}
+
+	return updateDoc, false, nil
+}
+
+// valueDepth returns how many levels of objects and arrays a parsed JSON value nests
+func valueDepth(value interface{}) int {
+	maxChild := 0
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, elem := range v {
+			maxChild = max(maxChild, valueDepth(elem))
+		}
+	case []interface{}:
+		for _, elem := range v {
+			maxChild = max(maxChild, valueDepth(elem))
+		}
+	default:
+		return 0
+	}
+	return maxChild + 1
+}
+
+// flattenBeyond replaces objects and arrays nested deeper than limit with their JSON string
+func flattenBeyond(value interface{}, limit int) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if limit <= 0 {
+			data, _ := json.Marshal(v)
+			return string(data)
+		}
+		out := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			out[key] = flattenBeyond(elem, limit-1)
+		}
+		return out
+	case []interface{}:
+		if limit <= 0 {
+			data, _ := json.Marshal(v)
+			return string(data)
+		}
+		out := make([]interface{}, len(v))
+		for i, elem := range v {
+			out[i] = flattenBeyond(elem, limit-1)
+		}
+		return out
 	}
+	return value
+}
 
-	return updateDoc, nil
+// deadLetterDocument records a document that can't be converted, keeping its original string value
+func deadLetterDocument(ctx context.Context, collection *mongo.Collection, doc Document, config MigrationConfig, reason error) error {
+	name := config.DeadLetterCollection
+	if name == "" {
+		name = config.CollectionName + "_deadletter"
+	}
+
+	_, err := collection.Database().Collection(name).InsertOne(ctx, bson.M{
+		"DocumentId": doc["_id"],
+		"Collection": config.CollectionName,
+		"Field":      config.FieldName,
+		"Value":      fieldValue(doc, config.FieldName),
+		"Reason":     reason.Error(),
+		"CreatedAt":  time.Now(),
+	})
+	return err
 }
 
-// Helper function to create a backup collection (optional)
-func createBackup(ctx context.Context, client *mongo.Client, config MigrationConfig) error {
+// createBackup copies the collection being migrated and returns the name of the copy. The
+// cursor reading the collection is bounded by timeouts.Scan, and each inserted batch by
+// timeouts.Step.
+func createBackup(ctx context.Context, client *mongo.Client, config MigrationConfig) (string, error) {
 	sourceCollection := client.Database(config.DatabaseName).Collection(config.CollectionName)
 	backupCollectionName := fmt.Sprintf("%s_backup_%d", config.CollectionName, time.Now().Unix())
 
-	fmt.Printf("Creating backup collection: %s\n", backupCollectionName)
+	logger.Info(fmt.Sprintf("Creating backup collection: %s", backupCollectionName))
+
+	scanCtx, cancel := timeouts.ScanContext(ctx)
+	defer cancel()
 
 	// This is a simple approach - for large collections, consider using MongoDB's built-in backup tools
-	cursor, err := sourceCollection.Find(ctx, bson.M{})
+	cursor, err := sourceCollection.Find(scanCtx, bson.M{})
 	if err != nil {
-		return fmt.Errorf("failed to read source collection: %w", err)
+		return "", fmt.Errorf("failed to read source collection: %w", err)
 	}
 	defer cursor.Close(ctx)
 
 	backupCollection := client.Database(config.DatabaseName).Collection(backupCollectionName)
 	var docs []interface{}
+	insert := func() error {
+		batchCtx, cancel := timeouts.Step(ctx)
+		defer cancel()
+		_, err := backupCollection.InsertMany(batchCtx, docs)
+		docs = docs[:0]
+		return err
+	}
 
-	for cursor.Next(ctx) {
+	for cursor.Next(scanCtx) {
 		var doc bson.M
 		if err := cursor.Decode(&doc); err != nil {
-			continue
+			return "", fmt.Errorf("failed to decode document for backup: %w", err)
 		}
 		docs = append(docs, doc)
 
 		// Insert in batches to avoid memory issues
 		if len(docs) >= 1000 {
-			if _, err := backupCollection.InsertMany(ctx, docs); err != nil {
-				return fmt.Errorf("failed to insert backup documents: %w", err)
+			if err := insert(); err != nil {
+				return "", fmt.Errorf("failed to insert backup documents: %w", err)
 			}
-			docs = docs[:0] // Clear slice
 		}
 	}
+	if err := cursor.Err(); err != nil {
+		return "", fmt.Errorf("failed to read source collection: %w", err)
+	}
 
 	// Insert remaining documents
 	if len(docs) > 0 {
-		if _, err := backupCollection.InsertMany(ctx, docs); err != nil {
-			return fmt.Errorf("failed to insert remaining backup documents: %w", err)
+		if err := insert(); err != nil {
+			return "", fmt.Errorf("failed to insert remaining backup documents: %w", err)
 		}
 	}
 
-	fmt.Printf("Backup created successfully: %s\n", backupCollectionName)
-	return nil
+	logger.Info(fmt.Sprintf("Backup created successfully: %s", backupCollectionName))
+	return backupCollectionName, nil
 }
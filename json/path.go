@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fieldValue returns the value at a dot-separated path such as "Metadata.Payload",
+// or nil when a part of the path is missing or not an embedded document
+func fieldValue(doc Document, path string) interface{} {
+	var value interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		switch current := value.(type) {
+		case Document:
+			value = current[key]
+		case map[string]interface{}:
+			value = current[key]
+		case bson.M:
+			value = current[key]
+		case primitive.D:
+			value = nil
+			for _, elem := range current {
+				if elem.Key == key {
+					value = elem.Value
+					break
+				}
+			}
+		default:
+			return nil
+		}
+	}
+	return value
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// backupsCollection records the backups made by createBackup, so a rollback can find the latest one
+	backupsCollection = "JsonMigrationBackups"
+
+	// documents restored per BulkWrite by rollbackMigration
+	rollbackBatchSize = 1000
+)
+
+// recordBackup stores the name of a backup of the migrated collection in backupsCollection
+func recordBackup(ctx context.Context, client *mongo.Client, config MigrationConfig, backupName string) error {
+	ctx, cancel := timeouts.Step(ctx)
+	defer cancel()
+
+	_, err := client.Database(config.DatabaseName).Collection(backupsCollection).InsertOne(ctx, bson.M{
+		"Collection":       config.CollectionName,
+		"Field":            config.FieldName,
+		"BackupCollection": backupName,
+		"CreatedAt":        time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record backup %s: %w", backupName, err)
+	}
+	return nil
+}
+
+// latestBackup returns the name of the last backup recorded for the migrated collection
+func latestBackup(ctx context.Context, client *mongo.Client, config MigrationConfig) (string, error) {
+	ctx, cancel := timeouts.Step(ctx)
+	defer cancel()
+
+	var record struct {
+		BackupCollection string `bson:"BackupCollection"`
+	}
+	err := client.Database(config.DatabaseName).Collection(backupsCollection).FindOne(ctx,
+		bson.M{"Collection": config.CollectionName},
+		options.FindOne().SetSort(bson.M{"CreatedAt": -1}),
+	).Decode(&record)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", fmt.Errorf("no backup of collection %s is recorded in %s", config.CollectionName, backupsCollection)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to find the backup of collection %s: %w", config.CollectionName, err)
+	}
+	return record.BackupCollection, nil
+}
+
+// rollbackMigration replaces the documents of the migrated collection with their copy in
+// backupCollectionName, upserting by _id, which undoes the conversion. Documents inserted
+// after the backup are kept. An empty backupCollectionName rolls back from the latest backup.
+// The backup cursor is bounded by timeouts.Scan, and each restored batch by timeouts.Step.
+func rollbackMigration(ctx context.Context, client *mongo.Client, config MigrationConfig, backupCollectionName string) error {
+	if backupCollectionName == "" {
+		var err error
+		backupCollectionName, err = latestBackup(ctx, client, config)
+		if err != nil {
+			return err
+		}
+	}
+	logger.Info(fmt.Sprintf("Rolling back collection %s from %s", config.CollectionName, backupCollectionName))
+
+	database := client.Database(config.DatabaseName)
+	collection := database.Collection(config.CollectionName)
+
+	scanCtx, cancel := timeouts.ScanContext(ctx)
+	defer cancel()
+	cursor, err := database.Collection(backupCollectionName).Find(scanCtx, bson.M{})
+	if err != nil {
+		return fmt.Errorf("failed to read backup collection: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var restored int64
+	var ops []mongo.WriteModel
+	flush := func() error {
+		if len(ops) == 0 {
+			return nil
+		}
+		batchCtx, cancel := timeouts.Step(ctx)
+		defer cancel()
+		result, err := collection.BulkWrite(batchCtx, ops)
+		if err != nil {
+			return fmt.Errorf("failed to restore documents: %w", err)
+		}
+		restored += result.ModifiedCount + result.UpsertedCount
+		ops = ops[:0]
+		return nil
+	}
+
+	for cursor.Next(scanCtx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return fmt.Errorf("failed to decode backup document: %w", err)
+		}
+		ops = append(ops, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": doc["_id"]}).
+			SetReplacement(doc).
+			SetUpsert(true))
+
+		if len(ops) >= rollbackBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to read backup collection: %w", err)
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	logger.Summary(fmt.Sprintf("Rollback completed: %d documents restored from %s", restored, backupCollectionName))
+	return nil
+}
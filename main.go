@@ -3,16 +3,21 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 
 	// "os"
+	"sync"
 	"time"
 
+	appdb "github.com/duymanh3602/migrate-tool/internal/db"
+	"github.com/duymanh3602/migrate-tool/internal/env"
+	applog "github.com/duymanh3602/migrate-tool/internal/log"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
 
-	// "github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -32,7 +37,8 @@ type CourseLessonItem struct {
 	RefId              string             `bson:"RefId"`
 	Order              int                `bson:"Order"`
 	IsPublished        bool               `bson:"IsPublished"`
-	QuestionIds        *string            `bson:"QuestionIds,omitempty"`
+	QuestionIds        []string           `bson:"QuestionIds,omitempty"`
+	QuestionIdsRaw     *string            `bson:"QuestionIdsRaw,omitempty"`
 	MaxSubmitCount     *int               `bson:"MaxSubmitCount,omitempty"`
 	CreatedDate        time.Time          `bson:"CreatedDate"`
 	ModifiedDate       time.Time          `bson:"ModifiedDate"`
@@ -44,32 +50,35 @@ type CourseLessonItem struct {
 }
 
 func migrateCourseLessonItems() error {
-	mysqlDSN := "docker-mysql:123qwe@tcp(127.0.0.1:3306)/lms"
-	mysqlDB, err := sql.Open("mysql", mysqlDSN)
+	settings, err := env.Require(env.MySQLDSN, env.MongoURI)
 	if err != nil {
-		return fmt.Errorf("MySQL connection error: %v", err)
+		return err
+	}
+	mysqlDSN, mongoURI := settings[0], settings[1]
+	timeouts, err := appdb.TimeoutsFromEnv()
+	if err != nil {
+		return err
 	}
-	defer mysqlDB.Close()
-
-	mongoURI := "mongodb://localhost:27017"
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
 
-	mongoClient, err := mongo.Connect(ctx, options.Client().
-		ApplyURI(mongoURI).
-		SetServerSelectionTimeout(60*time.Second).
-		SetConnectTimeout(60*time.Second).
-		SetSocketTimeout(60*time.Second))
+	mysqlDB, err := appdb.ConnectMySQL(mysqlDSN)
 	if err != nil {
-		return fmt.Errorf("MongoDB connection error: %v", err)
+		return err
 	}
-	defer mongoClient.Disconnect(ctx)
+	defer mysqlDB.Close()
 
-	// Verify the connection
-	err = mongoClient.Ping(ctx, nil)
+	ctx := context.Background()
+
+	clientOptions := options.Client().ApplyURI(mongoURI)
+	if timeouts.Operation > 0 {
+		clientOptions.SetServerSelectionTimeout(timeouts.Operation).
+			SetConnectTimeout(timeouts.Operation).
+			SetSocketTimeout(timeouts.Operation)
+	}
+	mongoClient, err := appdb.ConnectMongoWithOptions(clientOptions)
 	if err != nil {
-		return fmt.Errorf("MongoDB ping error: %v", err)
+		return err
 	}
+	defer mongoClient.Disconnect(ctx)
 
 	collection := mongoClient.Database("lms").Collection("NewCourseLessonItem")
 
@@ -132,7 +141,7 @@ func migrateCourseLessonItems() error {
 			item.VideoUrl = &videoUrl.String
 		}
 		if questionIds.Valid {
-			item.QuestionIds = &questionIds.String
+			item.setQuestionIds(questionIds.String, oldId)
 		}
 		if maxSubmitCount.Valid {
 			val := int(maxSubmitCount.Int64)
@@ -161,27 +170,27 @@ func migrateCourseLessonItems() error {
 			item.ModifiedBy = lastModifiedBy.String
 		}
 
+		// each row gets its own deadline, however many rows the table has
+		rowCtx, cancel := timeouts.Step(ctx)
+
 		// lưu vào bảng NewCourseLessonItem
-		_, err = collection.InsertOne(ctx, item)
+		_, err = collection.InsertOne(rowCtx, item)
 		if err != nil {
+			cancel()
 			return fmt.Errorf("MongoDB insert error: %v", err)
 		}
 
-		// cập nhật lại NewLessonItemId trong bảng Transcript
-		// updateQuery := "UPDATE Transcript SET NewLessonItemId = ? WHERE LessonItemId = ?"
-		// _, err = mysqlDB.Exec(updateQuery, item.CourseLessonItemId, item.OldId)
-		// if err != nil {
-		// 	return fmt.Errorf("error updating Transcript table: %v", err)
-		// }
+		// NewLessonItemId trong bảng Transcript được cập nhật bởi MigrateCourseLessonItems (MySQLReferences)
 
 		// cập nhật lại ItemId trong bảng ItemAssignmentData
-		_, err = dataCollection.UpdateMany(ctx, bson.M{
+		_, err = dataCollection.UpdateMany(rowCtx, bson.M{
 			"ItemId": item.OldId,
 		}, bson.M{
 			"$set": bson.M{
 				"NewItemId": item.CourseLessonItemId,
 			},
 		})
+		cancel()
 		if err != nil {
 			return fmt.Errorf("error updating ItemAssignmentData table: %v", err)
 		}
@@ -197,77 +206,357 @@ func migrateCourseLessonItems() error {
 
 // optimized version
 const (
-	mysqlDSN   = "docker-mysql:123qwe@tcp(127.0.0.1:3306)/lms"
-	mongoURI   = "mongodb://localhost:27017"
 	dateLayout = "2006-01-02 15:04:05"
+	// IANA timezone the source DB stores Created/LastModified in, they are converted to UTC
+	sourceTimezone = "UTC"
+
+	// max batches whose ItemAssignmentData updates run concurrently, 1 keeps them serialized
+	referenceUpdateConcurrency = 4
+
+	// items failing validation are stored here instead of NewCourseLessonItem
+	quarantineCollectionName = "NewCourseLessonItemQuarantine"
 )
 
-func MigrateCourseLessonItems() error {
-	mysqlDB, err := sql.Open("mysql", mysqlDSN)
+// connectRetry retries connecting to MySQL and MongoDB with the default attempts and backoff
+var connectRetry = appdb.Retry{Warn: func(message string) { log.Printf("⚠️ %s", message) }}
+
+// CourseLessonItemConfig selects the source table and target collections of MigrateCourseLessonItems
+type CourseLessonItemConfig struct {
+	MySQLDSN                 string
+	MongoURI                 string
+	DatabaseName             string
+	SourceTable              string
+	TargetCollection         string
+	AssignmentDataCollection string
+	QuarantineCollection     string
+
+	// DateLayout and SourceTimezone describe how Created/LastModified are stored in SourceTable
+	DateLayout     string
+	SourceTimezone string
+
+	// TenantId limits the migration to the rows of one tenant, and the ItemAssignmentData
+	// updates to that tenant's documents. All tenants are migrated when it is nil.
+	TenantId *int
+
+	// MySQLTLS and MongoTLS, when set, connect to the servers over TLS
+	MySQLTLS *TLSOptions
+	MongoTLS *TLSOptions
+
+	// IDNamespace, when set, makes each CourseLessonItemId the UUIDv5 of the TenantId and OldId
+	// of its row in this namespace, so a row gets the same id in every run and environment.
+	// Ids are random (v4) when it is nil.
+	IDNamespace *uuid.UUID
+
+	// IDMapping receives the OldId to CourseLessonItemId and _id mapping of the migrated items
+	IDMapping IDMappingOutput
+
+	// MySQLReferences are updated with the new ids once the items are migrated, e.g.
+	// TranscriptReference. None are by default, since not every deployment has these tables.
+	MySQLReferences []MySQLReference
+
+	// Rows whose OldId is already in TargetCollection are skipped, so a re-run only migrates the
+	// missing ones. ForceRemigrate instead deletes the migrated documents first, the tenant's
+	// only when TenantId is set, and migrates every row again.
+	ForceRemigrate bool
+
+	// Optional CourseLessonItem fields (tagged omitempty) are left out of the document when nil.
+	// Consumers that tell a missing field apart from null can have them written as explicit null:
+	// every optional field with WriteAllNullFields, or the ones in ExplicitNullFields by bson name.
+	WriteAllNullFields bool
+	ExplicitNullFields map[string]bool
+
+	// EnumLabels maps a CourseLessonItem field to the labels of its int values, e.g.
+	// "Type": {1: "video", 2: "quiz"}. Each mapped field gets a "<Field>Label" companion,
+	// or is replaced by its label when ReplaceEnumValues is set.
+	EnumLabels        map[string]map[int]string
+	ReplaceEnumValues bool
+
+	// Validator checks each scanned item, the items it rejects are kept in QuarantineCollection
+	// instead of TargetCollection. ItemRules.Validate is used when it is nil.
+	Validator CourseLessonItemValidator
+	ItemRules CourseLessonItemRules
+
+	// Timeouts bound each batch and quarantined row, and Timeouts.Scan the steps reading or
+	// writing the whole collection: the re-run check, the index and the id mapping.
+	// appdb.DefaultTimeouts when nil.
+	Timeouts *appdb.Timeouts
+}
+
+// DefaultCourseLessonItemConfig returns the configuration of the lms migration, connecting to
+// the MYSQL_DSN and MONGO_URI of the environment
+func DefaultCourseLessonItemConfig() CourseLessonItemConfig {
+	return CourseLessonItemConfig{
+		MySQLDSN:                 env.Get(env.MySQLDSN),
+		MongoURI:                 env.Get(env.MongoURI),
+		DatabaseName:             "lms",
+		SourceTable:              "CourseLessonItems",
+		TargetCollection:         "NewCourseLessonItem",
+		AssignmentDataCollection: "ItemAssignmentData",
+		QuarantineCollection:     quarantineCollectionName,
+		DateLayout:               dateLayout,
+		SourceTimezone:           sourceTimezone,
+		IDMapping:                IDMappingOutput{Collection: "IdMapping"},
+	}
+}
+
+// MigrateTenantCourseLessonItems migrates the CourseLessonItems of a single tenant
+func MigrateTenantCourseLessonItems(config CourseLessonItemConfig, tenantId int) (CourseLessonItemMapping, error) {
+	config.TenantId = &tenantId
+	return MigrateCourseLessonItems(config)
+}
+
+// MigrateCourseLessonItems copies SourceTable into TargetCollection and returns the new ids of the
+// migrated rows, which are also written to IDMapping
+func MigrateCourseLessonItems(config CourseLessonItemConfig) (CourseLessonItemMapping, error) {
+	startTime := time.Now()
+
+	if config.MySQLDSN == "" || config.MongoURI == "" {
+		return nil, fmt.Errorf("MySQLDSN and MongoURI are required, set %s and %s in %s or the environment",
+			env.MySQLDSN, env.MongoURI, env.File)
+	}
+
+	dsn, err := withMySQLTLS(config.MySQLDSN, config.MySQLTLS)
 	if err != nil {
-		return fmt.Errorf("MySQL connection error: %v", err)
+		return nil, err
+	}
+	mysqlDB, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("MySQL connection error: %v", err)
 	}
 	defer mysqlDB.Close()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	location, err := time.LoadLocation(config.SourceTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source timezone %q: %v", config.SourceTimezone, err)
+	}
+	dates := DateConfig{Layout: config.DateLayout, Location: location}
+	validate := config.Validator
+	if validate == nil {
+		validate = config.ItemRules.Validate
+	}
+
+	if err := appdb.ConnectWithRetry(context.Background(), "MySQL", connectRetry, mysqlDB.PingContext, appdb.IsTransientSQLError); err != nil {
+		return nil, err
+	}
 
-	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	timeouts := appdb.DefaultTimeouts()
+	if config.Timeouts != nil {
+		timeouts = *config.Timeouts
+	}
+	ctx := context.Background()
+
+	clientOptions, err := mongoClientOptions(config.MongoURI, config.MongoTLS)
+	if err != nil {
+		return nil, err
+	}
+	connectCtx, cancel := timeouts.Step(ctx)
+	mongoClient, err := mongo.Connect(connectCtx, clientOptions)
+	cancel()
 	if err != nil {
-		return fmt.Errorf("MongoDB connection error: %v", err)
+		return nil, fmt.Errorf("MongoDB connection error: %v", err)
 	}
 	defer mongoClient.Disconnect(ctx)
 
-	db := mongoClient.Database("lms")
-	collection := db.Collection("NewCourseLessonItem")
-	dataCollection := db.Collection("ItemAssignmentData")
+	if err := appdb.ConnectWithRetry(ctx, "MongoDB", connectRetry, func(ctx context.Context) error {
+		return mongoClient.Ping(ctx, nil)
+	}, appdb.IsTransientMongoError); err != nil {
+		return nil, err
+	}
+
+	db := mongoClient.Database(config.DatabaseName)
+	collection := db.Collection(config.TargetCollection)
+	dataCollection := db.Collection(config.AssignmentDataCollection)
+	quarantineCollection := db.Collection(config.QuarantineCollection)
+
+	offloader, err := newGridFSOffloader(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each batch is inserted and linked in ItemAssignmentData in one transaction when the deployment supports it
+	var session mongo.Session
+	helloCtx, cancel := timeouts.Step(ctx)
+	transactions := supportsTransactions(helloCtx, mongoClient)
+	cancel()
+	if transactions {
+		session, err = mongoClient.StartSession()
+		if err != nil {
+			return nil, fmt.Errorf("failed to start session: %v", err)
+		}
+		defer session.EndSession(ctx)
+	} else {
+		log.Printf("⚠️  MongoDB is not a replica set, items and their ItemAssignmentData links are written without a transaction")
+	}
 
 	query := `SELECT 
 		LessonId, Title, Description, Content, Time, VideoUrl, Type, RefId,
 		` + "`Order`" + `, IsPublished, QuestionIds, MaxSubmitCount, TenantId, IsDeleted,
 		Created, LastModified, CreatedBy, LastModifiedBy, Id as OldId
-		FROM CourseLessonItems`
+		FROM ` + "`" + config.SourceTable + "`"
+	var args []interface{}
+	if config.TenantId != nil {
+		query += " WHERE TenantId = ?"
+		args = append(args, *config.TenantId)
+		log.Printf("Migrating tenant %d only", *config.TenantId)
+	}
 
-	rows, err := mysqlDB.Query(query)
+	// These read or write the whole collection, bounded by the scan timeout
+	scanCtx, cancel := timeouts.ScanContext(ctx)
+	defer cancel()
+	if config.ForceRemigrate {
+		if err := clearMigratedItems(scanCtx, collection, config.TenantId); err != nil {
+			return nil, err
+		}
+	}
+	if err := ensureOldIdIndex(scanCtx, collection); err != nil {
+		return nil, err
+	}
+	migrated, err := migratedItems(scanCtx, collection, config.TenantId)
+	cancel()
 	if err != nil {
-		return fmt.Errorf("MySQL query error: %v", err)
+		return nil, err
+	}
+	if len(migrated) > 0 {
+		log.Printf("%d items were already migrated to %s, skipping them", len(migrated), config.TargetCollection)
+	}
+
+	rows, err := mysqlDB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("MySQL query error: %v", err)
 	}
 	defer rows.Close()
 
-	var items []interface{}
 	batchSize := 100
-
-	for rows.Next() {
-		item, err := scanRow(rows)
+	updater := newReferenceUpdater(dataCollection, referenceUpdateConcurrency, config.TenantId != nil, timeouts)
+	// The items of a previous run keep their ids in the mapping and the MySQL references
+	mapping := make(CourseLessonItemMapping, len(migrated))
+	for oldId, ids := range migrated {
+		mapping[oldId] = ids
+	}
+	// Without a transaction the previous run may have died before linking them in
+	// ItemAssignmentData, the updates are sent again in batches of batchSize
+	var relink []CourseLessonItem
+	var writes BatchTally
+	documents := newItemDocuments(config)
+	batches := NewBatchProcessor(batchSize, func(items []CourseLessonItem) error {
+		batchCtx, cancel := timeouts.Step(ctx)
+		defer cancel()
+		mapped, tally, err := processBatch(batchCtx, items, collection, updater, documents, offloader, session)
 		if err != nil {
 			return err
 		}
+		writes.add(tally)
+		mapping.add(mapped)
+		return nil
+	})
+	var validCount, invalidCount, skippedCount int
 
-		items = append(items, item)
+	for rows.Next() {
+		item, err := scanRow(rows, dates, config.IDNamespace)
+		if err != nil {
+			updater.Wait()
+			return nil, err
+		}
+		if ids, ok := migrated[item.OldId]; ok {
+			skippedCount++
+			if session == nil {
+				item.CourseLessonItemId, item.Id = ids.CourseLessonItemId, ids.Id
+				relink = append(relink, item)
+				if len(relink) == batchSize {
+					updater.Submit(ctx, relink)
+					relink = nil
+				}
+			}
+			continue
+		}
 
-		if len(items) >= batchSize {
-			if err := processBatch(ctx, items, collection, dataCollection, mysqlDB); err != nil {
-				return err
+		if validationErr := validate(item); validationErr != nil {
+			log.Printf("⚠️  Item %d failed validation: %v", item.OldId, validationErr)
+			rowCtx, cancel := timeouts.Step(ctx)
+			err := quarantineItem(rowCtx, quarantineCollection, item, validationErr)
+			cancel()
+			if err != nil {
+				updater.Wait()
+				return nil, err
 			}
-			items = items[:0]
+			invalidCount++
+			continue
 		}
-	}
+		validCount++
 
-	if len(items) > 0 {
-		if err := processBatch(ctx, items, collection, dataCollection, mysqlDB); err != nil {
-			return err
+		if err := batches.Add(item); err != nil {
+			updater.Wait()
+			return nil, err
 		}
 	}
 
+	if err := batches.Flush(); err != nil {
+		updater.Wait()
+		return nil, err
+	}
+	updater.Submit(ctx, relink)
+
+	if err := updater.Wait(); err != nil {
+		return nil, err
+	}
+
 	if err := rows.Err(); err != nil {
-		return fmt.Errorf("rows iteration error: %v", err)
+		return nil, fmt.Errorf("rows iteration error: %v", err)
 	}
 
-	log.Println("✅ Migration completed successfully.")
-	return nil
+	scanCtx, cancel = timeouts.ScanContext(ctx)
+	defer cancel()
+	if err := writeCourseLessonItemMapping(scanCtx, db, config.TargetCollection, mapping, config.IDMapping); err != nil {
+		return mapping, err
+	}
+	for _, ref := range config.MySQLReferences {
+		if _, err := updateMySQLReferences(scanCtx, mysqlDB, ref, mapping); err != nil {
+			return mapping, err
+		}
+	}
+
+	documents.enums.report()
+	log.Printf("Offloaded large fields of %d documents to GridFS bucket %s", offloader.Offloaded(), gridFSBucketName)
+	log.Printf("Validation: %d valid, %d invalid (quarantined in %s)", validCount, invalidCount, config.QuarantineCollection)
+	if skippedCount > 0 {
+		log.Printf("Skipped %d items migrated by a previous run", skippedCount)
+	}
+	log.Printf("Writes: %d items inserted, %d rejected", writes.Written, writes.Failed)
+	if writes.Failed > 0 {
+		log.Printf("⚠️  %d items were rejected by %s, re-run the migration to retry them", writes.Failed, config.TargetCollection)
+	}
+	log.Printf("✅ Migration completed successfully in %v.", time.Since(startTime))
+	return mapping, nil
+}
+
+// deterministicItemId returns the UUIDv5 of a source row in namespace, named by its TenantId and OldId
+func deterministicItemId(namespace uuid.UUID, tenantId, oldId int) string {
+	return uuid.NewSHA1(namespace, []byte(fmt.Sprintf("%d/%d", tenantId, oldId))).String()
+}
+
+// DateConfig describes how the source DB stores dates
+type DateConfig struct {
+	Layout string
+	// Location is the timezone of the stored values, nil means UTC
+	Location *time.Location
 }
 
-func scanRow(rows *sql.Rows) (CourseLessonItem, error) {
+// parse reads a source date in the configured zone and returns it in UTC
+func (dc DateConfig) parse(value string) (time.Time, error) {
+	location := dc.Location
+	if location == nil {
+		location = time.UTC
+	}
+	t, err := time.ParseInLocation(dc.Layout, value, location)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
+
+// scanRow reads a CourseLessonItem, giving it a new _id and a CourseLessonItemId that is
+// derived from its row in idNamespace, or random when idNamespace is nil
+func scanRow(rows *sql.Rows, dates DateConfig, idNamespace *uuid.UUID) (CourseLessonItem, error) {
 	var item CourseLessonItem
 	var content, videoUrl, questionIds sql.NullString
 	var maxSubmitCount sql.NullInt64
@@ -287,8 +576,12 @@ func scanRow(rows *sql.Rows) (CourseLessonItem, error) {
 	}
 
 	item.Id = primitive.NewObjectID()
-	item.CourseLessonItemId = uuid.New().String()
 	item.OldId = oldId
+	if idNamespace != nil {
+		item.CourseLessonItemId = deterministicItemId(*idNamespace, item.TenantId, oldId)
+	} else {
+		item.CourseLessonItemId = uuid.New().String()
+	}
 
 	if content.Valid {
 		item.Content = &content.String
@@ -297,7 +590,7 @@ func scanRow(rows *sql.Rows) (CourseLessonItem, error) {
 		item.VideoUrl = &videoUrl.String
 	}
 	if questionIds.Valid {
-		item.QuestionIds = &questionIds.String
+		item.setQuestionIds(questionIds.String, oldId)
 	}
 	if maxSubmitCount.Valid {
 		val := int(maxSubmitCount.Int64)
@@ -305,13 +598,17 @@ func scanRow(rows *sql.Rows) (CourseLessonItem, error) {
 	}
 
 	if createdStr.Valid {
-		if createdTime, err := time.Parse(dateLayout, createdStr.String); err == nil {
+		if createdTime, err := dates.parse(createdStr.String); err == nil {
 			item.CreatedDate = createdTime
+		} else {
+			log.Printf("⚠️  Item %d has an invalid Created date %q: %v", oldId, createdStr.String, err)
 		}
 	}
 	if lastModifiedStr.Valid {
-		if modifiedTime, err := time.Parse(dateLayout, lastModifiedStr.String); err == nil {
+		if modifiedTime, err := dates.parse(lastModifiedStr.String); err == nil {
 			item.ModifiedDate = modifiedTime
+		} else {
+			log.Printf("⚠️  Item %d has an invalid LastModified date %q: %v", oldId, lastModifiedStr.String, err)
 		}
 	}
 
@@ -325,156 +622,675 @@ func scanRow(rows *sql.Rows) (CourseLessonItem, error) {
 	return item, nil
 }
 
-func processBatch(ctx context.Context, items []interface{}, collection *mongo.Collection, dataCollection *mongo.Collection, mysqlDB *sql.DB) error {
-	_, err := collection.InsertMany(ctx, items)
+// processBatch upserts a batch and links it in ItemAssignmentData, in one transaction with a
+// session and otherwise in the background by updater. It returns the items to map: the inserted
+// ones, and those the collection already had with their stored ids. Without a session the items
+// MongoDB rejects are logged and counted as failed, and a re-run migrates them again; with one
+// they abort the transaction and fail the whole batch.
+func processBatch(ctx context.Context, items []CourseLessonItem, collection *mongo.Collection, updater *referenceUpdater,
+	documents itemDocuments, offloader *gridFSOffloader, session mongo.Session) ([]CourseLessonItem, BatchTally, error) {
+	docs, err := documents.build(items)
 	if err != nil {
-		return fmt.Errorf("MongoDB bulk insert error: %v", err)
+		return nil, BatchTally{}, err
 	}
 
-	for _, item := range items {
-		courseLessonItem := item.(CourseLessonItem)
+	docs, err = offloader.Offload(docs)
+	if err != nil {
+		return nil, BatchTally{}, err
+	}
 
-		// Update Transcript table
-		// if _, err := mysqlDB.Exec("UPDATE Transcript SET NewLessonItemId = ? WHERE LessonItemId = ?",
-		// 	courseLessonItem.CourseLessonItemId, courseLessonItem.OldId); err != nil {
-		// 	return fmt.Errorf("error updating Transcript table: %v", err)
-		// }
+	describe := func(i int) string { return fmt.Sprintf("item %d", items[i].OldId) }
+	var inserted, existing []CourseLessonItem
+	if session != nil {
+		_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+			var failed map[int]string
+			var err error
+			if inserted, existing, failed, err = upsertItems(sc, collection, items, docs); err != nil {
+				return nil, err
+			}
+			if len(failed) > 0 {
+				return nil, fmt.Errorf("%d of %d items were rejected by %s: %s",
+					len(failed), len(items), collection.Name(), describeFailedWrites(failed, describe))
+			}
+			return nil, updateItemAssignmentData(sc, updater.dataCollection, append(inserted, existing...), updater.scopeToTenant)
+		})
+		return append(inserted, existing...), BatchTally{Written: len(inserted)}, err
+	}
+
+	inserted, existing, failed, err := upsertItems(ctx, collection, items, docs)
+	if err != nil {
+		return nil, BatchTally{}, err
+	}
+	tally := BatchTally{Written: len(inserted), Failed: len(failed)}
+	if tally.Failed > 0 {
+		log.Printf("⚠️  Batch of %d items: %d inserted, %d rejected by %s: %s", len(items), tally.Written, tally.Failed,
+			collection.Name(), describeFailedWrites(failed, describe))
+	}
 
-		// Update ItemAssignmentData
-		_, err = dataCollection.UpdateMany(ctx,
-			bson.M{"ItemId": courseLessonItem.OldId},
-			bson.M{"$set": bson.M{
+	// upsertItems returns new slices for every batch, so the updater can keep them
+	mapped := append(inserted, existing...)
+	updater.Submit(ctx, mapped)
+	return mapped, tally, nil
+}
+
+// supportsTransactions reports whether the deployment is a replica set or sharded cluster,
+// since standalone servers reject transactions
+func supportsTransactions(ctx context.Context, client *mongo.Client) bool {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		log.Printf("⚠️  Could not check the MongoDB deployment type: %v", err)
+		return false
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid"
+}
+
+// referenceUpdater runs the ItemAssignmentData updates of inserted batches in the
+// background, at most `limit` batches at a time, so the next InsertMany does not
+// wait for the previous batch's references to be patched.
+type referenceUpdater struct {
+	dataCollection *mongo.Collection
+	// scopeToTenant adds the item's TenantId to the ItemAssignmentData filter
+	scopeToTenant bool
+	// timeouts.Step bounds each batch update, which outlives the context of the batch it is submitted from
+	timeouts appdb.Timeouts
+	// update links a batch in dataCollection, updateItemAssignmentData unless replaced in tests
+	update func(ctx context.Context, dataCollection *mongo.Collection, batch []CourseLessonItem, scopeToTenant bool) error
+	sem    chan struct{}
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	errs []error
+}
+
+func newReferenceUpdater(dataCollection *mongo.Collection, limit int, scopeToTenant bool, timeouts appdb.Timeouts) *referenceUpdater {
+	if limit < 1 {
+		limit = 1
+	}
+	return &referenceUpdater{
+		dataCollection: dataCollection,
+		scopeToTenant:  scopeToTenant,
+		timeouts:       timeouts,
+		update:         updateItemAssignmentData,
+		sem:            make(chan struct{}, limit),
+	}
+}
+
+// Submit blocks until a slot is free, then updates the batch's references asynchronously.
+// With a limit of 1 the update runs inline, keeping the old serialized behavior.
+func (u *referenceUpdater) Submit(ctx context.Context, batch []CourseLessonItem) {
+	ctx, cancel := u.timeouts.Step(context.WithoutCancel(ctx))
+	if cap(u.sem) == 1 {
+		defer cancel()
+		if err := u.update(ctx, u.dataCollection, batch, u.scopeToTenant); err != nil {
+			u.addError(err)
+		}
+		return
+	}
+
+	u.sem <- struct{}{}
+	u.wg.Add(1)
+	go func() {
+		defer func() {
+			cancel()
+			<-u.sem
+			u.wg.Done()
+		}()
+		if err := u.update(ctx, u.dataCollection, batch, u.scopeToTenant); err != nil {
+			u.addError(err)
+		}
+	}()
+}
+
+func (u *referenceUpdater) addError(err error) {
+	u.mu.Lock()
+	u.errs = append(u.errs, err)
+	u.mu.Unlock()
+}
+
+// Wait blocks until every submitted batch has finished and returns all of their errors.
+func (u *referenceUpdater) Wait() error {
+	u.wg.Wait()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return errors.Join(u.errs...)
+}
+
+// updateItemAssignmentData points the ItemAssignmentData of a batch at the new item ids,
+// sending all of the batch's updates in a single unordered bulk write. With scopeToTenant
+// only the documents of each item's tenant are updated.
+func updateItemAssignmentData(ctx context.Context, dataCollection *mongo.Collection, batch []CourseLessonItem, scopeToTenant bool) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	models := make([]mongo.WriteModel, 0, len(batch))
+	for _, courseLessonItem := range batch {
+		filter := bson.M{"ItemId": courseLessonItem.OldId}
+		if scopeToTenant {
+			filter["TenantId"] = courseLessonItem.TenantId
+		}
+		models = append(models, mongo.NewUpdateManyModel().
+			SetFilter(filter).
+			SetUpdate(bson.M{"$set": bson.M{
 				// "OldItemId": courseLessonItem.OldId,
 				"NewItemId": courseLessonItem.CourseLessonItemId,
-			}},
-		)
-		if err != nil {
-			return fmt.Errorf("error updating ItemAssignmentData: %v", err)
-		}
+			}}))
 	}
 
+	if _, err := dataCollection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		return fmt.Errorf("error updating ItemAssignmentData for items %d to %d: %v",
+			batch[0].OldId, batch[len(batch)-1].OldId, err)
+	}
 	return nil
 }
 
-func cloneMongoDB(sourceURI, sourceDB, targetURI, targetDB string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-	defer cancel()
+// cloneCollectionOrder lists collections cloneMongoDB must copy first, in this order.
+// Collections not listed are cloned afterwards in their natural order.
+var cloneCollectionOrder = []string{}
+
+// orderCollections puts the collections listed in order first, skipping listed names that don't exist
+func orderCollections(collections, order []string) []string {
+	exists := make(map[string]bool, len(collections))
+	for _, name := range collections {
+		exists[name] = true
+	}
 
-	sourceClient, err := mongo.Connect(ctx, options.Client().ApplyURI(sourceURI))
+	ordered := make([]string, 0, len(collections))
+	listed := make(map[string]bool, len(order))
+	for _, name := range order {
+		if !exists[name] {
+			logger.Warn(fmt.Sprintf("collection %s from the clone order does not exist in the source, skipping", name))
+			continue
+		}
+		if !listed[name] {
+			ordered = append(ordered, name)
+			listed[name] = true
+		}
+	}
+
+	for _, name := range collections {
+		if !listed[name] {
+			ordered = append(ordered, name)
+		}
+	}
+	return ordered
+}
+
+// defaultCloneBatchSize is the number of documents cloneMongoDB reads and inserts at a time
+const defaultCloneBatchSize = 1000
+
+// CloneOptions narrows down what cloneMongoDB copies
+type CloneOptions struct {
+	// BatchSize documents are held in memory and inserted at a time, defaultCloneBatchSize when 0
+	BatchSize int
+	// Filter selects the documents cloned from every collection, all of them when nil
+	Filter bson.M
+	// Upsert replaces documents by _id instead of inserting them, so a clone can be run again
+	// to bring the target up to date. Plain inserts are faster into empty collections.
+	Upsert bool
+	// Timeouts bound each batch and collection step, appdb.DefaultTimeouts when nil. Copying the
+	// indexes, which builds them over the whole collection, is bounded by Timeouts.Scan.
+	Timeouts *appdb.Timeouts
+}
+
+func cloneMongoDB(sourceURI, sourceDB, targetURI, targetDB string, opts CloneOptions) error {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultCloneBatchSize
+	}
+	filter := opts.Filter
+	if filter == nil {
+		filter = bson.M{}
+	}
+	timeouts := appdb.DefaultTimeouts()
+	if opts.Timeouts != nil {
+		timeouts = *opts.Timeouts
+	}
+
+	ctx := context.Background()
+
+	sourceClient, err := appdb.ConnectMongo(sourceURI)
 	if err != nil {
-		return fmt.Errorf("failed to connect to source MongoDB: %v", err)
+		return fmt.Errorf("source: %v", err)
 	}
 	defer sourceClient.Disconnect(ctx)
 
-	targetClient, err := mongo.Connect(ctx, options.Client().ApplyURI(targetURI))
+	targetClient, err := appdb.ConnectMongo(targetURI)
 	if err != nil {
-		return fmt.Errorf("failed to connect to target MongoDB: %v", err)
+		return fmt.Errorf("target: %v", err)
 	}
 	defer targetClient.Disconnect(ctx)
 
 	sourceDatabase := sourceClient.Database(sourceDB)
 	targetDatabase := targetClient.Database(targetDB)
 
-	collections, err := sourceDatabase.ListCollectionNames(ctx, bson.D{})
+	listCtx, cancel := timeouts.Step(ctx)
+	collections, err := sourceDatabase.ListCollectionNames(listCtx, bson.D{})
+	cancel()
 	if err != nil {
 		return fmt.Errorf("failed to list collections: %v", err)
 	}
 
+	collections = orderCollections(collections, cloneCollectionOrder)
+	logger.Info(fmt.Sprintf("Clone order: %v", collections))
+
+	deadLetter := targetDatabase.Collection(depthDeadLetterCollection)
+	tooDeep := 0
+	var writes BatchTally
+
 	for _, collName := range collections {
-		fmt.Printf("Cloning collection: %s\n", collName)
+		logger.Info(fmt.Sprintf("Cloning collection: %s", collName))
 
-		sourceColl := sourceDatabase.Collection(collName)
-		targetColl := targetDatabase.Collection(collName)
+		createCtx, cancel := timeouts.Step(ctx)
+		err := createCollectionLike(createCtx, sourceDatabase, targetDatabase, collName)
+		cancel()
+		if err != nil {
+			return err
+		}
 
-		cursor, err := sourceColl.Find(ctx, bson.D{})
+		tally, overLimit, err := cloneCollection(ctx, sourceDatabase.Collection(collName), targetDatabase.Collection(collName),
+			filter, batchSize, opts.Upsert, deadLetter, timeouts)
 		if err != nil {
-			return fmt.Errorf("failed to find documents in %s: %v", collName, err)
+			return err
+		}
+		if overLimit > 0 {
+			logger.Warn(fmt.Sprintf("%d documents in %s exceed the nesting limit (policy: %s)", overLimit, collName, cloneDepthPolicy))
+			tooDeep += overLimit
+		}
+		writes.add(tally)
+		if tally.Failed > 0 {
+			logger.Warn(fmt.Sprintf("Cloned %d documents into %s, %d were rejected", tally.Written, collName, tally.Failed))
+		} else {
+			logger.Info(fmt.Sprintf("Cloned %d documents into %s", tally.Written, collName))
 		}
 
-		var docs []interface{}
-		if err := cursor.All(ctx, &docs); err != nil {
-			return fmt.Errorf("failed to read documents in %s: %v", collName, err)
+		// Indexes are built once the documents are in, which is faster than maintaining them per insert
+		indexCtx, cancel := timeouts.ScanContext(ctx)
+		indexes, err := copyIndexes(indexCtx, sourceDatabase.Collection(collName), targetDatabase.Collection(collName))
+		cancel()
+		if err != nil {
+			return err
 		}
+		if indexes > 0 {
+			logger.Info(fmt.Sprintf("Copied %d indexes to %s", indexes, collName))
+		}
+	}
 
-		if len(docs) > 0 {
-			_, err = targetColl.InsertMany(ctx, docs)
+	if tooDeep > 0 {
+		logger.Summary(fmt.Sprintf("%d documents exceeded the nesting limit of %d", tooDeep, maxDocumentDepth))
+	}
+	if writes.Failed > 0 {
+		logger.Summary(fmt.Sprintf("Database clone completed: %d documents cloned, %d rejected by the target", writes.Written, writes.Failed))
+		return nil
+	}
+	logger.Summary("Database clone completed successfully.")
+	return nil
+}
+
+// cloneCollection streams the documents matching filter from source into target, batchSize at a time,
+// and returns the tally of the documents written and rejected and how many were over the nesting
+// limit. Reading and inserting each batch gets a new timeouts.Step deadline, however long the
+// collection is. Batches are written unordered: a document the target rejects is logged and
+// counted while the rest of its batch is kept.
+func cloneCollection(ctx context.Context, source, target *mongo.Collection, filter bson.M, batchSize int, upsert bool,
+	deadLetter *mongo.Collection, timeouts appdb.Timeouts) (BatchTally, int, error) {
+	var writes BatchTally
+	batchCtx, cancel := timeouts.Step(ctx)
+	defer func() { cancel() }()
+
+	cursor, err := source.Find(batchCtx, filter, options.Find().SetBatchSize(int32(batchSize)))
+	if err != nil {
+		return writes, 0, fmt.Errorf("failed to find documents in %s: %v", source.Name(), err)
+	}
+	defer cursor.Close(ctx)
+
+	tooDeep := 0
+	docs := make([]interface{}, 0, batchSize)
+	flush := func() error {
+		kept, overLimit, err := checkDocumentDepths(batchCtx, source.Name(), docs, deadLetter)
+		if err != nil {
+			return err
+		}
+		tooDeep += overLimit
+		if len(kept) > 0 {
+			var tally BatchTally
+			if upsert {
+				tally, err = upsertDocuments(batchCtx, target, kept)
+			} else {
+				tally, err = insertDocuments(batchCtx, target, kept)
+			}
 			if err != nil {
-				return fmt.Errorf("failed to insert documents into %s: %v", collName, err)
+				return err
 			}
+			writes.add(tally)
 		}
+		docs = docs[:0]
+		cancel()
+		batchCtx, cancel = timeouts.Step(ctx)
+		return nil
 	}
 
-	fmt.Println("Database clone completed successfully.")
-	return nil
+	for cursor.Next(batchCtx) {
+		var doc bson.D
+		if err := cursor.Decode(&doc); err != nil {
+			return writes, tooDeep, fmt.Errorf("failed to read documents in %s: %v", source.Name(), err)
+		}
+		docs = append(docs, doc)
+		if len(docs) == batchSize {
+			if err := flush(); err != nil {
+				return writes, tooDeep, err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return writes, tooDeep, fmt.Errorf("failed to read documents in %s: %v", source.Name(), err)
+	}
+	if len(docs) > 0 {
+		if err := flush(); err != nil {
+			return writes, tooDeep, err
+		}
+	}
+	return writes, tooDeep, nil
 }
 
-func convertStringIDsToObjectIDs(uri, dbName, collectionName string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// insertDocuments inserts docs into target unordered, logging the documents it rejects
+func insertDocuments(ctx context.Context, target *mongo.Collection, docs []interface{}) (BatchTally, error) {
+	_, err := target.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	failed, err := failedWrites(err)
+	if err != nil {
+		return BatchTally{}, fmt.Errorf("failed to insert documents into %s: %v", target.Name(), err)
+	}
+	return tallyDocuments(target, docs, failed), nil
+}
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+// upsertDocuments replaces the documents with the same _id in target, inserting the missing ones,
+// and logs the documents it rejects
+func upsertDocuments(ctx context.Context, target *mongo.Collection, docs []interface{}) (BatchTally, error) {
+	models := make([]mongo.WriteModel, 0, len(docs))
+	for _, doc := range docs {
+		d, ok := doc.(primitive.D)
+		if !ok {
+			return BatchTally{}, fmt.Errorf("cannot upsert %T into %s, expected a document", doc, target.Name())
+		}
+		id, ok := d.Map()["_id"]
+		if !ok {
+			return BatchTally{}, fmt.Errorf("cannot upsert a document without _id into %s", target.Name())
+		}
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": id}).
+			SetReplacement(d).
+			SetUpsert(true))
+	}
+
+	_, err := target.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	failed, err := failedWrites(err)
 	if err != nil {
-		return fmt.Errorf("failed to connect to MongoDB: %v", err)
+		return BatchTally{}, fmt.Errorf("failed to upsert documents into %s: %v", target.Name(), err)
+	}
+	return tallyDocuments(target, docs, failed), nil
+}
+
+// tallyDocuments counts a batch of cloned docs, logging the ones target rejected
+func tallyDocuments(target *mongo.Collection, docs []interface{}, failed map[int]string) BatchTally {
+	tally := BatchTally{Written: len(docs) - len(failed), Failed: len(failed)}
+	if tally.Failed > 0 {
+		logger.Warn(fmt.Sprintf("Batch of %d documents: %d written, %d rejected by %s: %s", len(docs), tally.Written, tally.Failed,
+			target.Name(), describeFailedWrites(failed, func(i int) string { return clonedDocumentID(docs[i]) })))
+	}
+	return tally
+}
+
+// clonedDocumentID names a cloned document by its _id for the logs
+func clonedDocumentID(doc interface{}) string {
+	if d, ok := doc.(bson.D); ok {
+		for _, elem := range d {
+			if elem.Key == "_id" {
+				return fmt.Sprintf("_id %v", elem.Value)
+			}
+		}
+	}
+	return "document without _id"
+}
+
+// IDConversionStats counts the documents handled by convertStringIDsToObjectIDs
+type IDConversionStats struct {
+	Converted int
+	Failed    int
+}
+
+// convertStringIDsToObjectIDs replaces string _ids of a collection with new ObjectIDs, batchSize documents
+// per bulk write. When references are given, each batch and the updates of its references run in one
+// transaction, which needs a replica set.
+func convertStringIDsToObjectIDs(uri, dbName, collectionName string, batchSize int, output IDMappingOutput, references []IDReference,
+	timeouts appdb.Timeouts) (IDMapping, IDConversionStats, error) {
+	var stats IDConversionStats
+	if batchSize <= 0 {
+		batchSize = idMappingBatchSize
+	}
+
+	ctx := context.Background()
+
+	client, err := appdb.ConnectMongo(uri)
+	if err != nil {
+		return nil, stats, err
 	}
 	defer client.Disconnect(ctx)
 
-	collection := client.Database(dbName).Collection(collectionName)
+	db := client.Database(dbName)
+	collection := db.Collection(collectionName)
+	mapping := make(IDMapping)
 
-	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$type": "string"}})
+	session, err := client.StartSession()
 	if err != nil {
-		return fmt.Errorf("failed to find documents: %v", err)
+		return nil, stats, fmt.Errorf("failed to start session: %v", err)
 	}
-	defer cursor.Close(ctx)
+	defer session.EndSession(ctx)
 
-	for cursor.Next(ctx) {
-		var doc bson.M
+	// Collect the ids first, so the collection is not modified while the cursor reads it
+	scanCtx, cancel := timeouts.ScanContext(ctx)
+	defer cancel()
+	cursor, err := collection.Find(scanCtx, bson.M{"_id": bson.M{"$type": "string"}},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, stats, fmt.Errorf("failed to find documents: %v", err)
+	}
+	var oldIDs []string
+	for cursor.Next(scanCtx) {
+		var doc struct {
+			ID string `bson:"_id"`
+		}
 		if err := cursor.Decode(&doc); err != nil {
 			log.Printf("failed to decode document: %v", err)
+			stats.Failed++
 			continue
 		}
+		oldIDs = append(oldIDs, doc.ID)
+	}
+	if err := cursor.Err(); err != nil {
+		cursor.Close(ctx)
+		return nil, stats, fmt.Errorf("failed to read document ids: %v", err)
+	}
+	cursor.Close(ctx)
 
-		oldID, ok := doc["_id"].(string)
-		if !ok {
-			continue
+	for start := 0; start < len(oldIDs); start += batchSize {
+		batchIDs := oldIDs[start:min(start+batchSize, len(oldIDs))]
+
+		swap := func(ctx context.Context) (IDMapping, error) {
+			return convertIDBatch(ctx, db, collection, batchIDs, references)
+		}
+
+		// each batch gets its own deadline, however many ids there are
+		batchCtx, cancel := timeouts.Step(ctx)
+		var converted IDMapping
+		if len(references) == 0 {
+			converted, err = swap(batchCtx)
+		} else {
+			_, err = session.WithTransaction(batchCtx, func(sc mongo.SessionContext) (interface{}, error) {
+				converted, err = swap(sc)
+				return nil, err
+			})
+			if err != nil {
+				// The transaction was aborted, none of the batch was converted
+				converted = nil
+			}
+		}
+		cancel()
+		if err != nil {
+			log.Printf("failed to convert %d of %d _ids starting at %s: %v",
+				len(batchIDs)-len(converted), len(batchIDs), batchIDs[0], err)
+		}
+
+		for oldID, newID := range converted {
+			mapping[oldID] = newID
 		}
+		stats.Converted += len(converted)
+		stats.Failed += len(batchIDs) - len(converted)
+		log.Printf("converted %d of %d string _ids to ObjectIds", stats.Converted, len(oldIDs))
+	}
+
+	mappingCtx, cancel := timeouts.ScanContext(ctx)
+	defer cancel()
+	if err := writeIDMapping(mappingCtx, db, collectionName, mapping, output); err != nil {
+		return mapping, stats, err
+	}
+
+	return mapping, stats, nil
+}
+
+// convertIDBatch reinserts the documents of oldIDs under new ObjectIDs with one ordered bulk write and
+// returns the ids that were converted
+func convertIDBatch(ctx context.Context, db *mongo.Database, collection *mongo.Collection, oldIDs []string,
+	references []IDReference) (IDMapping, error) {
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": oldIDs}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find documents: %v", err)
+	}
+	var docs []bson.M
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("failed to decode documents: %v", err)
+	}
 
+	// Each document is inserted right before its old version is deleted, so an ordered
+	// bulk write that stops at an error never deletes a document that was not copied
+	pending := make([]string, 0, len(docs))
+	newIDs := make([]primitive.ObjectID, 0, len(docs))
+	models := make([]mongo.WriteModel, 0, 2*len(docs))
+	for _, doc := range docs {
+		oldID := doc["_id"].(string)
 		newID := primitive.NewObjectID()
 		doc["_id"] = newID
+		pending = append(pending, oldID)
+		newIDs = append(newIDs, newID)
+		models = append(models,
+			mongo.NewInsertOneModel().SetDocument(doc),
+			mongo.NewDeleteOneModel().SetFilter(bson.M{"_id": oldID}))
+	}
 
-		_, err := collection.InsertOne(ctx, doc)
-		if err != nil {
-			log.Printf("failed to insert new document for _id %s: %v", oldID, err)
-			continue
+	converted := make(IDMapping, len(pending))
+	if len(models) > 0 {
+		_, err = collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(true))
+		var bulkErr mongo.BulkWriteException
+		if err != nil && !errors.As(err, &bulkErr) {
+			return nil, fmt.Errorf("bulk write failed: %v", err)
 		}
 
-		_, err = collection.DeleteOne(ctx, bson.M{"_id": oldID})
+		done := len(pending)
+		if len(bulkErr.WriteErrors) > 0 {
+			// Only the pairs before the first failed write completed, a failed delete leaves its copy behind
+			done = bulkErr.WriteErrors[0].Index / 2
+			if bulkErr.WriteErrors[0].Index%2 == 1 {
+				log.Printf("document %s was copied to %s but its string _id version could not be deleted",
+					pending[done], newIDs[done].Hex())
+			}
+		}
+		for i := 0; i < done; i++ {
+			converted[pending[i]] = newIDs[i]
+		}
 		if err != nil {
-			log.Printf("failed to delete old document with _id %s: %v", oldID, err)
-			continue
+			return converted, fmt.Errorf("bulk write failed: %v", err)
 		}
+	}
 
-		log.Printf("converted _id from string (%s) to ObjectId (%s)", oldID, newID.Hex())
+	for oldID, newID := range converted {
+		if err := updateIDReferences(ctx, db, references, oldID, newID); err != nil {
+			return converted, err
+		}
 	}
 
-	return nil
+	if len(docs) < len(oldIDs) {
+		log.Printf("%d documents were deleted before they could be converted", len(oldIDs)-len(docs))
+	}
+	return converted, nil
 }
 
+// logger prints the progress of the tools in this package, at the verbosity of -quiet and -verbose
+var logger = applog.NewConsole(applog.LevelInfo)
+
 func main() {
-	err := convertStringIDsToObjectIDs("source đb đã che", "lms_dev", "NewCourseLessonItem")
+	quiet := flag.Bool("quiet", false, "only print warnings and the final summary, not the per-batch progress")
+	verbose := flag.Bool("verbose", false, "also print debug messages")
+	flag.Parse()
+	logger = applog.NewConsole(applog.Verbosity(*quiet, *verbose))
+
+	if err := env.Load(); err != nil {
+		log.Fatalf("Configuration failed: %v", err)
+	}
+	settings, err := env.Require(env.MongoURI, env.DBName)
+	if err != nil {
+		log.Fatalf("Configuration failed: %v", err)
+	}
+	timeouts, err := appdb.TimeoutsFromEnv()
+	if err != nil {
+		log.Fatalf("Configuration failed: %v", err)
+	}
+
+	_, _, err = convertStringIDsToObjectIDs(settings[0], settings[1], "NewCourseLessonItem", idMappingBatchSize,
+		IDMappingOutput{Collection: "NewCourseLessonItemIdMapping"}, nil, timeouts)
 	if err != nil {
 		log.Fatalf("Conversion failed: %v", err)
 	}
 }
 
 // func main() {
+// 	if err := env.Load(); err != nil {
+// 		log.Fatalf("Configuration failed: %v", err)
+// 	}
 // 	if err := migrateCourseLessonItems(); err != nil {
 // 		log.Fatalf("Migration failed: %v", err)
 // 	}
 // }
 
 // func main() {
-// 	err := cloneMongoDB("source nguồn", "lms", "target đích", "lms_dev")
+// 	if err := env.Load(); err != nil {
+// 		log.Fatalf("Configuration failed: %v", err)
+// 	}
+// 	settings, err := env.Require(env.MongoURI, env.DBName, env.TargetMongoURI, env.TargetDBName)
+// 	if err != nil {
+// 		log.Fatalf("Configuration failed: %v", err)
+// 	}
+// 	timeouts, err := appdb.TimeoutsFromEnv()
+// 	if err != nil {
+// 		log.Fatalf("Configuration failed: %v", err)
+// 	}
+// 	err = cloneMongoDB(settings[0], settings[1], settings[2], settings[3], CloneOptions{Timeouts: &timeouts})
 // 	if err != nil {
 // 		log.Fatalf("Error cloning database: %v", err)
 // 	}
 // }
+
+// func main() {
+// 	if err := env.Load(); err != nil {
+// 		log.Fatalf("Configuration failed: %v", err)
+// 	}
+// 	settings, err := env.Require(env.MongoURI, env.DBName)
+// 	if err != nil {
+// 		log.Fatalf("Configuration failed: %v", err)
+// 	}
+// 	if err := dumpMongoDB(settings[0], settings[1], "lms-dump"); err != nil {
+// 		log.Fatalf("Error dumping database: %v", err)
+// 	}
+// }
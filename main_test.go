@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	appdb "github.com/duymanh3602/migrate-tool/internal/db"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Simulated round trips of one batch: its upsert into the target collection and its
+// ItemAssignmentData update
+const (
+	benchmarkInsertLatency = 2 * time.Millisecond
+	benchmarkUpdateLatency = 4 * time.Millisecond
+	benchmarkBatches       = 50
+)
+
+// BenchmarkReferenceUpdater measures a migration of benchmarkBatches batches end to end,
+// with the references updated inline (limit 1) and in the background
+func BenchmarkReferenceUpdater(b *testing.B) {
+	for _, limit := range []int{1, referenceUpdateConcurrency} {
+		b.Run(fmt.Sprintf("limit=%d", limit), func(b *testing.B) {
+			batch := make([]CourseLessonItem, 100)
+			for i := 0; i < b.N; i++ {
+				updater := newReferenceUpdater(nil, limit, false, appdb.DefaultTimeouts())
+				updater.update = func(context.Context, *mongo.Collection, []CourseLessonItem, bool) error {
+					time.Sleep(benchmarkUpdateLatency)
+					return nil
+				}
+				for n := 0; n < benchmarkBatches; n++ {
+					time.Sleep(benchmarkInsertLatency)
+					updater.Submit(context.Background(), batch)
+				}
+				if err := updater.Wait(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
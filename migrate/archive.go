@@ -0,0 +1,427 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	archiveManifestName = "manifest.json"
+	archiveSchemaName   = "schema.sql"
+	archiveTimeLayout   = "2006-01-02 15:04:05.999999"
+)
+
+// ArchiveManifest is the first entry of an export archive and describes its tables
+// in the order they must be restored
+type ArchiveManifest struct {
+	CreatedAt      time.Time      `json:"createdAt"`
+	SourceDatabase string         `json:"sourceDatabase"`
+	Tables         []ArchiveTable `json:"tables"`
+}
+
+// ArchiveTable describes one exported table
+type ArchiveTable struct {
+	Name      string   `json:"name"`
+	Columns   []string `json:"columns"`
+	DependsOn []string `json:"dependsOn"`
+	Rows      int      `json:"rows"`
+}
+
+// archiveBinary wraps values that aren't valid UTF-8 so they survive the JSON encoding
+type archiveBinary struct {
+	Base64 string `json:"$base64"`
+}
+
+// ExportToArchive writes the schema and data of every source table into a single
+// tar.gz file. Each table's data is split into one JSONL entry per batch, so only
+// BatchSize rows are held in memory at a time.
+func (dm *DatabaseMigrator) ExportToArchive(archivePath string) error {
+	dm.logger.Log(fmt.Sprintf("Starting export to archive: %s", archivePath))
+	startTime := time.Now()
+
+	tables, err := dm.GetTables()
+	if err != nil {
+		return err
+	}
+
+	dependencies, err := dm.GetTableDependencies(tables)
+	if err != nil {
+		return err
+	}
+
+	sortedTables, err := sortByDependencies(tables, dependencies)
+	if err != nil {
+		return fmt.Errorf("failed to sort tables by dependencies: %v", err)
+	}
+
+	manifest := ArchiveManifest{
+		CreatedAt:      time.Now(),
+		SourceDatabase: dm.config.Source.Database,
+	}
+	for _, tableName := range sortedTables {
+		columns, err := dm.GetTableColumns(tableName)
+		if err != nil {
+			return err
+		}
+		rowCount, err := dm.sourceRowCount(tableName)
+		if err != nil {
+			return err
+		}
+		manifest.Tables = append(manifest.Tables, ArchiveTable{
+			Name:      tableName,
+			Columns:   columns,
+			DependsOn: dependencies[tableName],
+			Rows:      rowCount,
+		})
+	}
+
+	file, err := os.Create(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %v", err)
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %v", err)
+	}
+	if err := writeArchiveEntry(tw, archiveManifestName, manifestData); err != nil {
+		return err
+	}
+
+	for _, table := range manifest.Tables {
+		if err := dm.exportTable(tw, table); err != nil {
+			return fmt.Errorf("failed to export table %s: %v", table.Name, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive compression: %v", err)
+	}
+
+	dm.logger.Log(fmt.Sprintf("Exported %d tables to %s in %v", len(manifest.Tables), archivePath, time.Since(startTime)))
+	return nil
+}
+
+func (dm *DatabaseMigrator) exportTable(tw *tar.Writer, table ArchiveTable) error {
+	createStmt, err := dm.GetTableSchema(table.Name)
+	if err != nil {
+		return err
+	}
+	if err := writeArchiveEntry(tw, path.Join(table.Name, archiveSchemaName), []byte(createStmt)); err != nil {
+		return err
+	}
+
+	columnNames := strings.Join(table.Columns, "`, `")
+	infos, err := dm.GetTableColumnInfo(table.Name)
+	if err != nil {
+		return err
+	}
+	transformers := dm.columnTransformers(table.Name, infos)
+	filter, err := dm.rowFilter(table.Name)
+	if err != nil {
+		return err
+	}
+	where := ""
+	if filter != "" {
+		where = " WHERE " + filter
+	}
+	// Ordering by the key keeps the OFFSET chunks from overlapping
+	keyColumns, _, err := dm.pagingKey(table.Name, table.Columns)
+	if err != nil {
+		return err
+	}
+	order := ""
+	if keyColumns != nil {
+		order = " ORDER BY `" + strings.Join(keyColumns, "`, `") + "`"
+	}
+	var buf bytes.Buffer
+	chunk := 0
+	exportedRows := 0
+
+	cancel := func() {}
+	defer func() { cancel() }()
+	for offset := 0; offset < table.Rows; offset += dm.config.BatchSize {
+		selectQuery := fmt.Sprintf("SELECT `%s` FROM `%s`%s%s LIMIT %d OFFSET %d",
+			columnNames, table.Name, where, order, dm.config.BatchSize, offset)
+
+		cancel()
+		var ctx context.Context
+		ctx, cancel = dm.queryContext()
+
+		rows, err := dm.sourceDB.QueryContext(ctx, selectQuery)
+		if err != nil {
+			return fmt.Errorf("failed to select data: %v", err)
+		}
+
+		buf.Reset()
+		for rows.Next() {
+			values := make([]interface{}, len(table.Columns))
+			valuePtrs := make([]interface{}, len(table.Columns))
+			for i := range values {
+				valuePtrs[i] = &values[i]
+			}
+
+			if err := rows.Scan(valuePtrs...); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan row: %v", err)
+			}
+			if err := transformRow(table.Columns, transformers, values); err != nil {
+				rows.Close()
+				return fmt.Errorf("table %s: %v", table.Name, err)
+			}
+
+			line, err := json.Marshal(encodeArchiveValues(values))
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to encode row: %v", err)
+			}
+			buf.Write(line)
+			buf.WriteByte('\n')
+			exportedRows++
+		}
+		rows.Close()
+
+		chunk++
+		name := path.Join(table.Name, fmt.Sprintf("data-%06d.jsonl", chunk))
+		if err := writeArchiveEntry(tw, name, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	dm.logger.Log(fmt.Sprintf("Exported table %s (%d rows)", table.Name, exportedRows))
+	return nil
+}
+
+func writeArchiveEntry(tw *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write archive header for %s: %v", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write archive entry %s: %v", name, err)
+	}
+	return nil
+}
+
+func encodeArchiveValues(values []interface{}) []interface{} {
+	encoded := make([]interface{}, len(values))
+	for i, val := range values {
+		switch v := val.(type) {
+		case []byte:
+			if utf8.Valid(v) {
+				encoded[i] = string(v)
+			} else {
+				encoded[i] = archiveBinary{Base64: base64.StdEncoding.EncodeToString(v)}
+			}
+		case time.Time:
+			encoded[i] = v.Format(archiveTimeLayout)
+		default:
+			encoded[i] = v
+		}
+	}
+	return encoded
+}
+
+func decodeArchiveValues(line []byte) ([]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+
+	var raw []interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	for i, val := range raw {
+		switch v := val.(type) {
+		case json.Number:
+			raw[i] = v.String()
+		case map[string]interface{}:
+			encoded, ok := v["$base64"].(string)
+			if !ok {
+				return nil, fmt.Errorf("unexpected object value in column %d", i)
+			}
+			data, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("invalid binary value in column %d: %v", i, err)
+			}
+			raw[i] = data
+		}
+	}
+	return raw, nil
+}
+
+// ImportFromArchive restores an archive written by ExportToArchive into the destination
+// database. Entries are streamed in archive order, which follows the dependency order
+// recorded in the manifest.
+func (dm *DatabaseMigrator) ImportFromArchive(archivePath string) error {
+	dm.logger.Log(fmt.Sprintf("Starting import from archive: %s", archivePath))
+	startTime := time.Now()
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %v", err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("failed to read archive compression: %v", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+
+	header, err := tr.Next()
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %v", err)
+	}
+	if header.Name != archiveManifestName {
+		return fmt.Errorf("archive does not start with %s", archiveManifestName)
+	}
+
+	var manifest ArchiveManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return fmt.Errorf("failed to decode manifest: %v", err)
+	}
+
+	tables := make(map[string]ArchiveTable)
+	var tableNames []string
+	dependencies := make(map[string][]string)
+	for _, table := range manifest.Tables {
+		tables[table.Name] = table
+		tableNames = append(tableNames, table.Name)
+		dependencies[table.Name] = table.DependsOn
+	}
+
+	restoreOrder, err := sortByDependencies(tableNames, dependencies)
+	if err != nil {
+		return fmt.Errorf("failed to sort archived tables by dependencies: %v", err)
+	}
+	dm.logger.Log(fmt.Sprintf("Restoring %d tables from %s: %v", len(restoreOrder), manifest.SourceDatabase, restoreOrder))
+
+	if err := dm.DisableForeignKeyChecks(); err != nil {
+		return err
+	}
+	defer dm.EnableForeignKeyChecks()
+
+	restored := make(map[string]bool)
+	importedRows := make(map[string]int)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %v", err)
+		}
+
+		tableName, entry := path.Split(header.Name)
+		tableName = strings.TrimSuffix(tableName, "/")
+		table, ok := tables[tableName]
+		if !ok {
+			return fmt.Errorf("archive entry %s belongs to unknown table %s", header.Name, tableName)
+		}
+
+		if entry == archiveSchemaName {
+			for _, dep := range table.DependsOn {
+				if !restored[dep] {
+					return fmt.Errorf("table %s appears in the archive before its dependency %s", tableName, dep)
+				}
+			}
+
+			createStmt, err := io.ReadAll(tr)
+			if err != nil {
+				return fmt.Errorf("failed to read schema for table %s: %v", tableName, err)
+			}
+			stmt, err := dm.rewriteVersionDDL(tableName, string(createStmt))
+			if err != nil {
+				return err
+			}
+			if err := dm.CreateTable(stmt); err != nil {
+				return fmt.Errorf("failed to create table %s: %v", tableName, err)
+			}
+			restored[tableName] = true
+			dm.logger.Log(fmt.Sprintf("Created table schema for: %s", tableName))
+			continue
+		}
+
+		count, err := dm.importArchiveChunk(tr, table)
+		if err != nil {
+			return fmt.Errorf("failed to import %s: %v", header.Name, err)
+		}
+		importedRows[tableName] += count
+	}
+
+	for _, tableName := range restoreOrder {
+		expected := tables[tableName].Rows
+		if importedRows[tableName] != expected {
+			dm.logger.Warn(fmt.Sprintf("Table %s imported %d rows, manifest expected %d",
+				tableName, importedRows[tableName], expected))
+		}
+	}
+
+	dm.logger.Log(fmt.Sprintf("Imported %d tables from %s in %v", len(restored), archivePath, time.Since(startTime)))
+	return nil
+}
+
+func (dm *DatabaseMigrator) importArchiveChunk(r io.Reader, table ArchiveTable) (int, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(table.Columns)), ",")
+	insertQuery := fmt.Sprintf("INSERT INTO `%s` (`%s`) VALUES (%s)",
+		table.Name, strings.Join(table.Columns, "`, `"), placeholders)
+
+	insertStmt, err := dm.destDB.PrepareContext(dm.ctx, insertQuery)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare insert statement: %v", err)
+	}
+	defer insertStmt.Close()
+
+	reader := bufio.NewReader(r)
+	count := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			values, decodeErr := decodeArchiveValues(line)
+			if decodeErr != nil {
+				return count, fmt.Errorf("failed to decode row %d: %v", count+1, decodeErr)
+			}
+			ctx, cancel := dm.queryContext()
+			_, execErr := insertStmt.ExecContext(ctx, values...)
+			cancel()
+			if execErr != nil {
+				return count, fmt.Errorf("failed to insert row: %v", execErr)
+			}
+			count++
+		}
+		if errors.Is(err, io.EOF) {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// preserveAutoIncrement sets the AUTO_INCREMENT counter of a copied MySQL table to the source's, so
+// rows the application inserts next don't reuse ids, including those of rows deleted in the source.
+// Tables without an auto-increment column are left alone; PostgreSQL sequences are reset by finishTable.
+func (dm *DatabaseMigrator) preserveAutoIncrement(ctx context.Context, tableName string, columns []ColumnInfo) error {
+	if dm.source.name() != driverMySQL || dm.dest.name() != driverMySQL {
+		return nil
+	}
+	hasAutoIncrement := false
+	for _, col := range columns {
+		if isAutoIncrement(col) {
+			hasAutoIncrement = true
+			break
+		}
+	}
+	if !hasAutoIncrement {
+		return nil
+	}
+
+	var next sql.NullInt64
+	err := dm.sourceDB.QueryRowContext(ctx,
+		"SELECT AUTO_INCREMENT FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?",
+		dm.config.Source.Database, tableName).Scan(&next)
+	if err != nil {
+		return fmt.Errorf("failed to read AUTO_INCREMENT of %s: %v", tableName, err)
+	}
+	if !next.Valid {
+		return nil
+	}
+
+	// MySQL raises a lower value to the highest copied key + 1 itself
+	query := fmt.Sprintf("ALTER TABLE %s AUTO_INCREMENT = %d", dm.dest.quote(tableName), next.Int64)
+	if _, err := dm.destDB.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to set AUTO_INCREMENT of %s: %v", tableName, err)
+	}
+	dm.logger.Debug(fmt.Sprintf("Table %s: AUTO_INCREMENT set to %d", tableName, next.Int64))
+	return nil
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	applog "github.com/duymanh3602/migrate-tool/internal/log"
+)
+
+// tableCopy holds what MigrateTableData works out about a table before copying its rows,
+// shared by the goroutines copying it
+type tableCopy struct {
+	tableName   string
+	logger      *applog.Logger
+	columns     []string
+	columnInfos []ColumnInfo
+	// condition and whereArgs select the rows to copy, from RowFilters and SampleRates
+	condition string
+	whereArgs []interface{}
+	// pkColumns is the key the table is paged by, nil when it is read in a single pass
+	pkColumns    []string
+	keyIndexes   []int
+	transformers []ValueTransformer
+	srids        map[int]uint32
+	// existingKeys are skipped under SkipExistingRows, pkIndex is the column they are matched on
+	existingKeys  *keySet
+	pkIndex       int
+	insertQuery   string
+	maxReprepares int
+	sampled       bool
+	randomSample  bool
+	totalRows     int
+	bar           *tableProgress
+}
+
+// copyStats counts the rows of a table by what happened to them
+type copyStats struct {
+	migrated int
+	skipped  int
+	rejected int
+	bytes    int64
+}
+
+func (s *copyStats) add(other copyStats) {
+	s.migrated += other.migrated
+	s.skipped += other.skipped
+	s.rejected += other.rejected
+	s.bytes += other.bytes
+}
+
+// batchRows is a batch read from the source, with the rows ready to insert
+type batchRows struct {
+	values   [][]interface{}
+	read     int
+	skipped  int
+	rejected int
+	// lastKey is the primary key of the last row read, when the table is paged by key
+	lastKey []interface{}
+}
+
+// batchInserter is the insert statement of one goroutine copying a table, prepared again
+// when its connection drops
+type batchInserter struct {
+	stmt       *sql.Stmt
+	reprepares int
+}
+
+func (dm *DatabaseMigrator) newBatchInserter(ctx context.Context, tc *tableCopy) (*batchInserter, error) {
+	stmt, err := dm.destDB.PrepareContext(ctx, tc.insertQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare insert statement: %v", err)
+	}
+	return &batchInserter{stmt: stmt}, nil
+}
+
+func (ins *batchInserter) close() {
+	ins.stmt.Close()
+}
+
+// readBatch scans the rows of a batch, leaving out the existing rows under SkipExistingRows
+// and, with ContinueOnError, the rows the transformers reject
+func (dm *DatabaseMigrator) readBatch(tc *tableCopy, rows *sql.Rows) (batchRows, error) {
+	defer rows.Close()
+	return dm.readRows(tc, rows, 0)
+}
+
+// readRows is readBatch for the next limit rows of an open result, all of them when limit is 0
+func (dm *DatabaseMigrator) readRows(tc *tableCopy, rows *sql.Rows, limit int) (batchRows, error) {
+	var batch batchRows
+	for (limit == 0 || batch.read < limit) && rows.Next() {
+		// Create slice to hold values
+		values := make([]interface{}, len(tc.columns))
+		valuePtrs := make([]interface{}, len(tc.columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+
+		// Scan values
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return batch, fmt.Errorf("failed to scan row: %v", err)
+		}
+		batch.read++
+		if tc.keyIndexes != nil {
+			batch.lastKey = rowKey(values, tc.keyIndexes)
+		}
+
+		if tc.existingKeys != nil {
+			if key, ok := toInt64(values[tc.pkIndex]); ok && tc.existingKeys.Has(key) {
+				batch.skipped++
+				continue
+			}
+		}
+
+		// Process values to handle invalid dates and other problematic values
+		if err := transformRow(tc.columns, tc.transformers, values); err != nil {
+			if !dm.config.ContinueOnError {
+				return batch, fmt.Errorf("table %s: %v", tc.tableName, err)
+			}
+			if err := dm.failedRows.record(tc.tableName, tc.columns, values, err); err != nil {
+				return batch, err
+			}
+			batch.rejected++
+			continue
+		}
+		applySRIDs(tc.srids, values)
+		dm.dest.prepareValues(tc.columnInfos, values)
+		batch.values = append(batch.values, values)
+	}
+	if err := rows.Err(); err != nil {
+		return batch, fmt.Errorf("failed to read data from table %s: %v", tc.tableName, err)
+	}
+	return batch, nil
+}
+
+// insertRows inserts a batch in one transaction, so a failure leaves none of its rows behind.
+// It returns the rows inserted, which under ContinueOnError may leave out rejected ones.
+// at describes the position of the batch in the log.
+func (dm *DatabaseMigrator) insertRows(ctx context.Context, tc *tableCopy, ins *batchInserter,
+	batch [][]interface{}, at string) ([][]interface{}, error) {
+	err := dm.insertBatch(ctx, ins.stmt, batch)
+	for err != nil && isBrokenConnection(err) && ins.reprepares < tc.maxReprepares {
+		// The statement died with its connection, prepare it again on a fresh
+		// one and retry the batch so the table continues from where it was
+		ins.reprepares++
+		tc.logger.Log(fmt.Sprintf("Table %s: insert statement lost its connection in the batch at %s (%v), re-preparing (attempt %d/%d)",
+			tc.tableName, at, err, ins.reprepares, tc.maxReprepares))
+
+		ins.stmt.Close()
+		ins.stmt, err = dm.destDB.PrepareContext(ctx, tc.insertQuery)
+		if err != nil {
+			return nil, fmt.Errorf("failed to re-prepare insert statement: %v", err)
+		}
+		err = dm.insertBatch(ctx, ins.stmt, batch)
+	}
+	if err != nil && dm.config.ContinueOnError && !isBrokenConnection(err) {
+		tc.logger.Warn(fmt.Sprintf("Table %s: batch at %s was rejected (%v), inserting its rows one at a time",
+			tc.tableName, at, err))
+		return dm.insertRowsIndividually(ctx, ins.stmt, tc.tableName, tc.columns, batch)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("batch at %s of table %s was rolled back: %v", at, tc.tableName, err)
+	}
+	return batch, nil
+}
+
+// countInserted adds the rows of a batch that were inserted to stats
+func (dm *DatabaseMigrator) countInserted(tc *tableCopy, stats *copyStats, inserted [][]interface{}) {
+	for _, values := range inserted {
+		if tc.sampled {
+			dm.recordSampledKeys(tc.tableName, tc.columns, values)
+		}
+		stats.bytes += rowSize(values)
+	}
+	stats.migrated += len(inserted)
+}
+
+// copyBatches copies the rows of a table one batch after the other, continuing from the
+// checkpoint of a resumed table
+func (dm *DatabaseMigrator) copyBatches(ctx context.Context, tc *tableCopy, saved TableCheckpoint) (copyStats, error) {
+	if tc.pkColumns == nil && !tc.randomSample {
+		return dm.copyCursor(ctx, tc, saved)
+	}
+	var stats copyStats
+
+	ins, err := dm.newBatchInserter(ctx, tc)
+	if err != nil {
+		return stats, err
+	}
+	defer func() {
+		ins.close()
+	}()
+
+	// Migrate data in batches
+	offset := saved.Offset
+	var lastKey []interface{}
+	for _, key := range saved.LastKey {
+		lastKey = append(lastKey, key)
+	}
+	batches := 0
+
+	// A batch that has started is finished even when ctx is cancelled, the table stops before
+	// the next one. QueryTimeout applies to each batch as a whole.
+	cancelBatch := func() {}
+	defer func() { cancelBatch() }()
+
+	for {
+		cancelBatch()
+		var batchCtx context.Context
+		batchCtx, cancelBatch = dm.withQueryTimeout(context.WithoutCancel(ctx))
+
+		if err := ctx.Err(); err != nil {
+			// Keep the rows copied so far, no matter how far the last periodic checkpoint is behind
+			cpErr := dm.checkpoint.update(tc.tableName, func(t *TableCheckpoint) {
+				t.Offset = offset
+				t.LastKey = keyText(lastKey)
+			})
+			if cpErr != nil {
+				return stats, cpErr
+			}
+			return stats, fmt.Errorf("data migration of table %s stopped after %d rows: %v", tc.tableName, stats.migrated, err)
+		}
+
+		// The rows read so far count towards MaxRowsPerTable, a random sample is read at once
+		limit := dm.config.BatchSize
+		if dm.config.MaxRowsPerTable > 0 {
+			limit = dm.config.MaxRowsPerTable - offset
+			if !tc.randomSample {
+				limit = min(limit, dm.config.BatchSize)
+			}
+			if limit <= 0 {
+				break
+			}
+		}
+		var selectQuery string
+		var selectArgs []interface{}
+		if tc.randomSample {
+			selectQuery, selectArgs = dm.randomSampleQuery(tc.tableName, tc.columns, tc.condition, tc.whereArgs, limit)
+		} else {
+			selectQuery, selectArgs = dm.pageQuery(tc.tableName, tc.columns, tc.pkColumns, tc.condition, tc.whereArgs, lastKey, limit)
+		}
+		rows, err := dm.sourceDB.QueryContext(batchCtx, selectQuery, selectArgs...)
+		if err != nil {
+			return stats, fmt.Errorf("failed to select data from table %s: %v", tc.tableName, err)
+		}
+
+		// Read the batch, so it can be retried as a whole if the destination connection drops
+		batch, err := dm.readBatch(tc, rows)
+		if err != nil {
+			return stats, err
+		}
+		if batch.lastKey != nil {
+			lastKey = batch.lastKey
+		}
+		stats.skipped += batch.skipped
+		stats.rejected += batch.rejected
+
+		inserted, err := dm.insertRows(batchCtx, tc, ins, batch.values, fmt.Sprintf("row %d", offset))
+		stats.rejected += len(batch.values) - len(inserted)
+		if err != nil {
+			return stats, err
+		}
+
+		dm.countInserted(tc, &stats, inserted)
+		offset += batch.read
+
+		batches++
+		if dm.config.CheckpointEvery > 0 && batches%dm.config.CheckpointEvery == 0 {
+			err := dm.checkpoint.update(tc.tableName, func(t *TableCheckpoint) {
+				t.Offset = offset
+				t.LastKey = keyText(lastKey)
+			})
+			if err != nil {
+				return stats, err
+			}
+		}
+
+		// Log progress
+		dm.logTableProgress(tc, offset, stats.migrated)
+
+		if batch.read < limit || tc.randomSample {
+			break
+		}
+	}
+	return stats, nil
+}
+
+// copyCursor copies a table without a key to page by in a single pass over one SELECT, inserting
+// its rows BatchSize at a time. QueryTimeout only applies to the inserts. A resumed table skips
+// the rows read before its checkpoint, which assumes the source returns them in the same order.
+func (dm *DatabaseMigrator) copyCursor(ctx context.Context, tc *tableCopy, saved TableCheckpoint) (copyStats, error) {
+	var stats copyStats
+
+	ins, err := dm.newBatchInserter(ctx, tc)
+	if err != nil {
+		return stats, err
+	}
+	defer func() {
+		ins.close()
+	}()
+
+	// Like a batch of copyBatches, the rows being inserted are finished when ctx is cancelled
+	readCtx, cancelRead := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelRead()
+	selectQuery := dm.scanQuery(tc.tableName, tc.columns, tc.condition, dm.config.MaxRowsPerTable)
+	rows, err := dm.sourceDB.QueryContext(readCtx, selectQuery, tc.whereArgs...)
+	if err != nil {
+		return stats, fmt.Errorf("failed to select data from table %s: %v", tc.tableName, err)
+	}
+	defer rows.Close()
+
+	offset := 0
+	for offset < saved.Offset && rows.Next() {
+		offset++
+	}
+
+	batches := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			cpErr := dm.checkpoint.update(tc.tableName, func(t *TableCheckpoint) { t.Offset = offset })
+			if cpErr != nil {
+				return stats, cpErr
+			}
+			return stats, fmt.Errorf("data migration of table %s stopped after %d rows: %v", tc.tableName, stats.migrated, err)
+		}
+
+		batch, err := dm.readRows(tc, rows, dm.config.BatchSize)
+		if err != nil {
+			return stats, err
+		}
+		stats.skipped += batch.skipped
+		stats.rejected += batch.rejected
+
+		batchCtx, cancelBatch := dm.withQueryTimeout(context.WithoutCancel(ctx))
+		inserted, err := dm.insertRows(batchCtx, tc, ins, batch.values, fmt.Sprintf("row %d", offset))
+		cancelBatch()
+		stats.rejected += len(batch.values) - len(inserted)
+		if err != nil {
+			return stats, err
+		}
+		dm.countInserted(tc, &stats, inserted)
+		offset += batch.read
+
+		batches++
+		if dm.config.CheckpointEvery > 0 && batches%dm.config.CheckpointEvery == 0 {
+			if err := dm.checkpoint.update(tc.tableName, func(t *TableCheckpoint) { t.Offset = offset }); err != nil {
+				return stats, err
+			}
+		}
+		dm.logTableProgress(tc, offset, stats.migrated)
+
+		if batch.read < dm.config.BatchSize {
+			return stats, nil
+		}
+	}
+}
+
+// logTableProgress shows the rows read so far on the progress bar, or logs them
+func (dm *DatabaseMigrator) logTableProgress(tc *tableCopy, read, migrated int) {
+	if tc.bar != nil {
+		tc.bar.update(read)
+		return
+	}
+	progress := float64(min(read, tc.totalRows)) / float64(tc.totalRows) * 100
+	tc.logger.Log(fmt.Sprintf("Table %s: %d/%d rows migrated (%.2f%%)",
+		tc.tableName, migrated, tc.totalRows, progress))
+}
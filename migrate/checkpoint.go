@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// TableCheckpoint is the saved progress of one table
+type TableCheckpoint struct {
+	SchemaCreated bool `json:"schemaCreated"`
+	DataStarted   bool `json:"dataStarted"`
+	// Offset is the number of rows copied so far, rounded down to the last checkpointed batch,
+	// and LastKey the primary key of its last row when the table is paged by key
+	Offset    int      `json:"offset"`
+	LastKey   []string `json:"lastKey,omitempty"`
+	Completed bool     `json:"completed"`
+	// Deferred keeps the definitions stripped by DeferIndexes, which are only known while creating the table
+	Deferred *deferredDefinitions `json:"deferred,omitempty"`
+}
+
+// checkpointState is the content of the checkpoint file
+type checkpointState struct {
+	UpdatedAt time.Time                   `json:"updatedAt"`
+	Tables    map[string]*TableCheckpoint `json:"tables"`
+}
+
+// checkpoint records migration progress in CheckpointFile so a failed run can be resumed.
+// All methods are safe for concurrent use and do nothing on a nil checkpoint.
+type checkpoint struct {
+	mu    sync.Mutex
+	path  string
+	state checkpointState
+	// resumed reports whether the state was loaded from a previous run
+	resumed bool
+	// autosave writes the file on every update, otherwise only save does
+	autosave bool
+}
+
+// openCheckpoint starts a checkpoint at path, continuing from the saved state when resume is set
+func openCheckpoint(path string, resume, autosave bool) (*checkpoint, error) {
+	cp := &checkpoint{path: path, state: checkpointState{Tables: make(map[string]*TableCheckpoint)}, autosave: autosave}
+	if !resume {
+		return cp, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+	if err := json.Unmarshal(data, &cp.state); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint %s: %v", path, err)
+	}
+	if cp.state.Tables == nil {
+		cp.state.Tables = make(map[string]*TableCheckpoint)
+	}
+	cp.resumed = true
+	return cp, nil
+}
+
+// table returns a copy of the saved progress of a table
+func (cp *checkpoint) table(tableName string) TableCheckpoint {
+	if cp == nil {
+		return TableCheckpoint{}
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if t, ok := cp.state.Tables[tableName]; ok {
+		return *t
+	}
+	return TableCheckpoint{}
+}
+
+// update changes the progress of a table and writes the checkpoint file when autosave is set
+func (cp *checkpoint) update(tableName string, change func(t *TableCheckpoint)) error {
+	if cp == nil {
+		return nil
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	t, ok := cp.state.Tables[tableName]
+	if !ok {
+		t = &TableCheckpoint{}
+		cp.state.Tables[tableName] = t
+	}
+	change(t)
+	cp.state.UpdatedAt = time.Now()
+
+	if !cp.autosave {
+		return nil
+	}
+	return cp.write()
+}
+
+// save writes the checkpoint file, also when autosave is off
+func (cp *checkpoint) save() error {
+	if cp == nil {
+		return nil
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.write()
+}
+
+// write stores the state in the checkpoint file, cp.mu must be held
+func (cp *checkpoint) write() error {
+	data, err := json.MarshalIndent(cp.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %v", err)
+	}
+
+	// Write to a temporary file and rename it, so a crash never leaves a truncated checkpoint
+	tmp, err := os.CreateTemp(filepath.Dir(cp.path), filepath.Base(cp.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write checkpoint: %v", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write checkpoint: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write checkpoint: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), cp.path); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %v", err)
+	}
+	return nil
+}
+
+// remove deletes the checkpoint file once the migration has completed
+func (cp *checkpoint) remove() error {
+	if cp == nil {
+		return nil
+	}
+	if err := os.Remove(cp.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove checkpoint: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	appdb "github.com/duymanh3602/migrate-tool/internal/db"
+	"github.com/duymanh3602/migrate-tool/internal/env"
+	applog "github.com/duymanh3602/migrate-tool/internal/log"
+)
+
+// cliOptions holds the command-line settings that are not part of MigrationConfig
+type cliOptions struct {
+	ConfigFile string
+	VerifyOnly bool
+	// SummaryJSON is the file the report of the run is written to as JSON
+	SummaryJSON string
+}
+
+// defaultConfig is the starting point when no -config file is given
+func defaultConfig() MigrationConfig {
+	return MigrationConfig{
+		Source: DatabaseConfig{
+			Port:     "3306",
+			Username: "root",
+		},
+		Destination: DatabaseConfig{
+			Port:     "3306",
+			Username: "root",
+		},
+		BatchSize: 1000,
+		LogFile:   "migration.log",
+	}
+}
+
+// parseFlags builds the migration config from the command line. Values come from -config when
+// given, then from the SOURCE_* and DEST_* environment variables, and any flag set explicitly
+// overrides them. Passwords are only read from the config file and the environment.
+func parseFlags(fs *flag.FlagSet, args []string) (MigrationConfig, cliOptions, error) {
+	var opts cliOptions
+	fs.StringVar(&opts.ConfigFile, "config", "", "load the migration config from this YAML or JSON file")
+	fs.BoolVar(&opts.VerifyOnly, "verify-only", false, "only verify the destination against -manifest, without migrating")
+	fs.StringVar(&opts.SummaryJSON, "summary-json", "", "write the migration report as JSON to this file, also when the migration fails")
+
+	sourceDriver := fs.String("source-driver", driverMySQL, "source database driver: mysql or postgres")
+	sourceHost := fs.String("source-host", "", "source database host (required)")
+	sourcePort := fs.String("source-port", "3306", "source database port")
+	sourceUser := fs.String("source-user", "root", "source database user")
+	sourceDB := fs.String("source-db", "", "source database (required)")
+	destDriver := fs.String("dest-driver", driverMySQL, "destination database driver: mysql, postgres or sqlite (-dest-db is then the file)")
+	destHost := fs.String("dest-host", "", "destination database host (required)")
+	destPort := fs.String("dest-port", "3306", "destination database port")
+	destUser := fs.String("dest-user", "root", "destination database user")
+	destDB := fs.String("dest-db", "", "destination database (required)")
+	batchSize := fs.Int("batch-size", 1000, "rows copied per batch")
+	concurrency := fs.Int("concurrency", 1, "number of tables copied in parallel")
+	tableWorkers := fs.Int("table-workers", 1, "goroutines copying key ranges of each table with an integer primary key")
+	skipTables := fs.String("skip-tables", "", "comma-separated tables not to migrate")
+	onlyTables := fs.String("only-tables", "", "comma-separated tables to migrate, instead of all of them")
+	maxRows := fs.Int("max-rows-per-table", 0, "copy at most this many rows of each table, 0 for all of them")
+	sampleStrategy := fs.String("sample-strategy", sampleHead, "rows kept by -max-rows-per-table: head (first in key order) or random")
+	logFile := fs.String("log-file", "migration.log", "file the migration log is appended to")
+	resume := fs.Bool("resume", false, "resume a failed migration from its checkpoint file")
+	checkpointFile := fs.String("checkpoint", defaultCheckpointFile, "file the migration progress is checkpointed to")
+	checkpointEvery := fs.Int("checkpoint-every", 0, "also checkpoint every N batches within a table (0 = only after each table)")
+	continueOnError := fs.Bool("continue-on-error", false, "write rows the destination rejects to -failed-rows and keep migrating")
+	failedRowsFile := fs.String("failed-rows", defaultFailedRowsFile, "file rows rejected under -continue-on-error are written to")
+	dryRun := fs.Bool("dry-run", false, "only log the tables that would be migrated and their row counts")
+	verify := fs.Bool("verify", false, "compare source and destination row counts after migrating")
+	verifyChecksum := fs.Bool("verify-checksum", false, "with -verify, also compare table checksums")
+	connectAttempts := fs.Int("connect-attempts", appdb.DefaultConnectAttempts, "times to try connecting to each database")
+	connectDelay := fs.Duration("connect-delay", appdb.DefaultConnectRetryDelay, "delay before the first connection retry, doubled after each attempt")
+	progress := fs.Bool("progress", false, "show a progress bar instead of per-batch log lines when run in a terminal")
+	logLevel := fs.String("log-level", "", "lowest level logged: debug, info, warn or error")
+	logFormat := fs.String("log-format", "", "log format: text or json")
+	quiet := fs.Bool("quiet", false, "only log warnings and errors, leaving out the per-batch progress (same as -log-level warn)")
+	verbose := fs.Bool("verbose", false, "also log debug messages (same as -log-level debug)")
+	queryTimeout := fs.Duration("query-timeout", 0, "cancel SQL statements running longer than this, 0 for no limit")
+	skipExistingTables := fs.Bool("skip-existing-tables", false, "keep destination tables that already exist and only copy their data")
+	truncate := fs.Bool("truncate-before-insert", false, "empty each destination table before copying its rows, deleting the data it holds")
+	schemaOnly := fs.Bool("schema-only", false, "only create the tables, indexes and foreign keys, without copying rows")
+	dataOnly := fs.Bool("data-only", false, "only copy rows into tables that already exist in the destination")
+	targetVersion := fs.String("target-mysql-version", "", "rewrite the copied CREATE TABLE statements for this older MySQL destination version, e.g. 5.7")
+	checkSchema := fs.Bool("check-schema", false, "with -data-only or -skip-existing-tables, abort before copying if the destination columns are incompatible")
+	allowCycles := fs.Bool("allow-circular-dependencies", false, "migrate tables with circular foreign keys with a warning instead of failing")
+	manifestFile := fs.String("manifest", "", "write a manifest of the migrated tables to this file")
+
+	if err := fs.Parse(args); err != nil {
+		return MigrationConfig{}, opts, err
+	}
+
+	config := defaultConfig()
+	if opts.ConfigFile != "" {
+		var err error
+		config, err = readConfigFile(opts.ConfigFile)
+		if err != nil {
+			return config, opts, err
+		}
+	}
+	applyEnv(&config)
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "source-driver":
+			config.Source.Driver = *sourceDriver
+		case "source-host":
+			config.Source.Host = *sourceHost
+		case "source-port":
+			config.Source.Port = *sourcePort
+		case "source-user":
+			config.Source.Username = *sourceUser
+		case "source-db":
+			config.Source.Database = *sourceDB
+		case "dest-driver":
+			config.Destination.Driver = *destDriver
+		case "dest-host":
+			config.Destination.Host = *destHost
+		case "dest-port":
+			config.Destination.Port = *destPort
+		case "dest-user":
+			config.Destination.Username = *destUser
+		case "dest-db":
+			config.Destination.Database = *destDB
+		case "batch-size":
+			config.BatchSize = *batchSize
+		case "concurrency":
+			config.Concurrency = *concurrency
+		case "table-workers":
+			config.TableWorkers = *tableWorkers
+		case "skip-tables":
+			config.SkipTables = splitList(*skipTables)
+		case "only-tables":
+			config.OnlyTables = splitList(*onlyTables)
+		case "max-rows-per-table":
+			config.MaxRowsPerTable = *maxRows
+		case "sample-strategy":
+			config.SampleStrategy = *sampleStrategy
+		case "log-file":
+			config.LogFile = *logFile
+		case "log-level":
+			config.LogLevel = *logLevel
+		case "quiet", "verbose":
+			config.LogLevel = applog.Verbosity(*quiet, *verbose).String()
+		case "log-format":
+			config.LogFormat = *logFormat
+		case "resume":
+			config.Resume = *resume
+		case "checkpoint":
+			config.CheckpointFile = *checkpointFile
+		case "checkpoint-every":
+			config.CheckpointEvery = *checkpointEvery
+		case "continue-on-error":
+			config.ContinueOnError = *continueOnError
+		case "failed-rows":
+			config.FailedRowsFile = *failedRowsFile
+		case "dry-run":
+			config.DryRun = *dryRun
+		case "verify":
+			config.Verify = *verify
+		case "verify-checksum":
+			config.VerifyChecksum = *verifyChecksum
+		case "progress":
+			config.Progress = *progress
+		case "connect-attempts":
+			config.ConnectMaxAttempts = *connectAttempts
+		case "query-timeout":
+			config.QueryTimeout = *queryTimeout
+		case "connect-delay":
+			config.ConnectRetryDelay = *connectDelay
+		case "skip-existing-tables":
+			config.SkipExistingTables = *skipExistingTables
+		case "truncate-before-insert":
+			config.TruncateBeforeInsert = *truncate
+		case "schema-only":
+			config.SchemaOnly = *schemaOnly
+		case "data-only":
+			config.DataOnly = *dataOnly
+		case "target-mysql-version":
+			config.TargetMySQLVersion = *targetVersion
+		case "check-schema":
+			config.CheckSchema = *checkSchema
+		case "allow-circular-dependencies":
+			config.AllowCircularDependencies = *allowCycles
+		case "manifest":
+			config.ManifestFile = *manifestFile
+		}
+	})
+
+	if err := config.Validate(); err != nil {
+		return config, opts, err
+	}
+	if opts.VerifyOnly && config.ManifestFile == "" {
+		return config, opts, fmt.Errorf("-verify-only requires -manifest")
+	}
+
+	return config, opts, nil
+}
+
+// applyEnv sets the connection settings of the source and destination that are given in the
+// environment, usually loaded from .env
+func applyEnv(config *MigrationConfig) {
+	settings := []struct {
+		name  string
+		field *string
+	}{
+		{env.SourceDriver, &config.Source.Driver},
+		{env.SourceHost, &config.Source.Host},
+		{env.SourcePort, &config.Source.Port},
+		{env.SourceUser, &config.Source.Username},
+		{env.SourcePassword, &config.Source.Password},
+		{env.SourceDB, &config.Source.Database},
+		{env.DestDriver, &config.Destination.Driver},
+		{env.DestHost, &config.Destination.Host},
+		{env.DestPort, &config.Destination.Port},
+		{env.DestUser, &config.Destination.Username},
+		{env.DestPassword, &config.Destination.Password},
+		{env.DestDB, &config.Destination.Database},
+	}
+	for _, setting := range settings {
+		if value := env.Get(setting.name); value != "" {
+			*setting.field = value
+		}
+	}
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
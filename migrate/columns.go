@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ColumnMapping renames and drops columns of a table while it is migrated
+type ColumnMapping struct {
+	// Renames gives source columns a different name in the destination
+	Renames []ColumnRename `yaml:"renames"`
+	// Exclude lists source columns that are neither copied nor created in the destination
+	Exclude []string `yaml:"exclude"`
+}
+
+// ColumnRename maps a source column to its destination name
+type ColumnRename struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// hasColumnMapping reports whether ColumnMappings renames or drops columns of a table
+func (dm *DatabaseMigrator) hasColumnMapping(tableName string) bool {
+	mapping, ok := dm.config.ColumnMappings[tableName]
+	return ok && (len(mapping.Renames) > 0 || len(mapping.Exclude) > 0)
+}
+
+// isExcludedColumn reports whether a source column is dropped by ColumnMappings
+func (dm *DatabaseMigrator) isExcludedColumn(tableName, column string) bool {
+	for _, excluded := range dm.config.ColumnMappings[tableName].Exclude {
+		if strings.EqualFold(excluded, column) {
+			return true
+		}
+	}
+	return false
+}
+
+// destColumnName returns the destination name of a source column
+func (dm *DatabaseMigrator) destColumnName(tableName, column string) string {
+	for _, rename := range dm.config.ColumnMappings[tableName].Renames {
+		if strings.EqualFold(rename.From, column) {
+			return rename.To
+		}
+	}
+	return column
+}
+
+// mappedColumns leaves out the source columns excluded by ColumnMappings
+func (dm *DatabaseMigrator) mappedColumns(tableName string, columns []ColumnInfo) []ColumnInfo {
+	if !dm.hasColumnMapping(tableName) {
+		return columns
+	}
+
+	var mapped []ColumnInfo
+	for _, col := range columns {
+		if !dm.isExcludedColumn(tableName, col.Name) {
+			mapped = append(mapped, col)
+		}
+	}
+	return mapped
+}
+
+// destColumnInfos returns the columns under their destination names
+func (dm *DatabaseMigrator) destColumnInfos(tableName string, columns []ColumnInfo) []ColumnInfo {
+	if !dm.hasColumnMapping(tableName) {
+		return columns
+	}
+
+	renamed := make([]ColumnInfo, len(columns))
+	for i, col := range columns {
+		renamed[i] = col
+		renamed[i].Name = dm.destColumnName(tableName, col.Name)
+	}
+	return renamed
+}
+
+// mapIndex renames the columns of a source index for the destination. It returns false when the
+// index covers an excluded column, which cannot be created.
+func (dm *DatabaseMigrator) mapIndex(tableName string, idx IndexInfo) (IndexInfo, bool) {
+	if !dm.hasColumnMapping(tableName) {
+		return idx, true
+	}
+
+	mapped := idx
+	mapped.Columns = make([]string, len(idx.Columns))
+	for i, col := range idx.Columns {
+		// prefix indexes keep their length, e.g. name(10)
+		name, prefix, hasPrefix := strings.Cut(col, "(")
+		if dm.isExcludedColumn(tableName, name) {
+			return IndexInfo{}, false
+		}
+		mapped.Columns[i] = dm.destColumnName(tableName, name)
+		if hasPrefix {
+			mapped.Columns[i] += "(" + prefix
+		}
+	}
+	return mapped, true
+}
+
+// validateColumnMapping checks that every rename is complete and that renames neither collide
+// nor touch an excluded column
+func validateColumnMapping(tableName string, mapping ColumnMapping) error {
+	seen := make(map[string]bool)
+	for _, rename := range mapping.Renames {
+		if rename.From == "" || rename.To == "" {
+			return fmt.Errorf("columnMappings.%s: renames need both from and to", tableName)
+		}
+		to := strings.ToLower(rename.To)
+		if seen[to] {
+			return fmt.Errorf("columnMappings.%s: more than one column renamed to %s", tableName, rename.To)
+		}
+		seen[to] = true
+		for _, excluded := range mapping.Exclude {
+			if strings.EqualFold(excluded, rename.From) {
+				return fmt.Errorf("columnMappings.%s: column %s is both renamed and excluded", tableName, rename.From)
+			}
+		}
+	}
+	return nil
+}
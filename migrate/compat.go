@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kinds of SchemaDifference
+const (
+	diffMissingTable  = "missing table"
+	diffMissingColumn = "missing column"
+	diffExtraColumn   = "extra column"
+	diffType          = "type"
+	diffNullable      = "nullability"
+)
+
+// SchemaDifference is one way a destination table differs from the source table copied into it
+type SchemaDifference struct {
+	Kind   string
+	Column string
+	// Source and Destination describe the column on each side, e.g. its type
+	Source      string
+	Destination string
+	// Breaking differences make the inserts of the copied rows fail
+	Breaking bool
+}
+
+func (d SchemaDifference) String() string {
+	switch d.Kind {
+	case diffMissingTable:
+		return "the table is missing from the destination"
+	case diffMissingColumn:
+		return fmt.Sprintf("column %s is missing from the destination", d.Column)
+	case diffExtraColumn:
+		if d.Breaking {
+			return fmt.Sprintf("destination column %s is NOT NULL without a default and is not copied", d.Column)
+		}
+		return fmt.Sprintf("destination column %s is not copied", d.Column)
+	case diffNullable:
+		return fmt.Sprintf("column %s is nullable in the source but NOT NULL in the destination", d.Column)
+	}
+	return fmt.Sprintf("column %s is %s in the source and %s in the destination", d.Column, d.Source, d.Destination)
+}
+
+// SchemaDiff lists the differences between a source table and the destination table
+type SchemaDiff struct {
+	Table       string
+	Differences []SchemaDifference
+}
+
+// Breaking returns the differences that make inserts fail
+func (d SchemaDiff) Breaking() []SchemaDifference {
+	var breaking []SchemaDifference
+	for _, diff := range d.Differences {
+		if diff.Breaking {
+			breaking = append(breaking, diff)
+		}
+	}
+	return breaking
+}
+
+// CheckSchemaCompatibility compares the columns copied from a source table, under their
+// destination names, with the columns of the destination table: their names, types and
+// nullability. Types are compared by what they hold (numbers, text, dates or bytes) between
+// different drivers, and exactly between databases of the same driver.
+func (dm *DatabaseMigrator) CheckSchemaCompatibility(tableName string) (SchemaDiff, error) {
+	diff := SchemaDiff{Table: tableName}
+
+	exists, err := dm.destTableExists(tableName)
+	if err != nil {
+		return diff, err
+	}
+	if !exists {
+		diff.Differences = append(diff.Differences, SchemaDifference{Kind: diffMissingTable, Breaking: true})
+		return diff, nil
+	}
+
+	sourceInfos, err := dm.GetTableColumnInfo(tableName)
+	if err != nil {
+		return diff, err
+	}
+	copied, _ := splitGeneratedColumns(dm.mappedColumns(tableName, sourceInfos))
+	copied = dm.destColumnInfos(tableName, copied)
+
+	ctx, cancel := dm.queryContext()
+	defer cancel()
+	destInfos, err := dm.dest.columnInfo(ctx, dm.destDB, tableName)
+	if err != nil {
+		return diff, fmt.Errorf("failed to get destination columns of table %s: %v", tableName, err)
+	}
+	destByName := make(map[string]ColumnInfo, len(destInfos))
+	for _, info := range destInfos {
+		destByName[strings.ToLower(info.Name)] = info
+	}
+
+	sameDriver := dm.source.name() == dm.dest.name()
+	copiedNames := make(map[string]bool, len(copied))
+	for _, src := range copied {
+		copiedNames[strings.ToLower(src.Name)] = true
+		dest, ok := destByName[strings.ToLower(src.Name)]
+		if !ok {
+			diff.Differences = append(diff.Differences, SchemaDifference{Kind: diffMissingColumn, Column: src.Name, Breaking: true})
+			continue
+		}
+
+		sourceFamily, destFamily := typeFamily(src.Type), typeFamily(dest.Type)
+		if sourceFamily != destFamily || (sameDriver && !strings.EqualFold(src.Type, dest.Type)) {
+			diff.Differences = append(diff.Differences, SchemaDifference{
+				Kind:        diffType,
+				Column:      src.Name,
+				Source:      src.Type,
+				Destination: dest.Type,
+				Breaking:    sourceFamily != destFamily,
+			})
+		}
+		if src.Nullable && !dest.Nullable {
+			diff.Differences = append(diff.Differences, SchemaDifference{Kind: diffNullable, Column: src.Name, Breaking: true})
+		}
+	}
+
+	for _, dest := range destInfos {
+		if copiedNames[strings.ToLower(dest.Name)] || isGeneratedColumn(dest) {
+			continue
+		}
+		diff.Differences = append(diff.Differences, SchemaDifference{
+			Kind:     diffExtraColumn,
+			Column:   dest.Name,
+			Breaking: !dest.Nullable && !dest.Default.Valid && !isAutoIncrement(dest),
+		})
+	}
+	return diff, nil
+}
+
+// checkSchemas runs CheckSchemaCompatibility on the tables rows are copied into, warning about
+// the harmless differences and failing with a report of the breaking ones. Under
+// SkipExistingTables only the tables the destination already has are checked.
+func (dm *DatabaseMigrator) checkSchemas(tables []string) error {
+	dm.logger.Log("Checking destination schema compatibility...")
+	var report []string
+	for _, tableName := range tables {
+		if !dm.config.DataOnly {
+			exists, err := dm.destTableExists(tableName)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				continue
+			}
+		}
+
+		diff, err := dm.CheckSchemaCompatibility(tableName)
+		if err != nil {
+			return err
+		}
+		for _, d := range diff.Differences {
+			if d.Breaking {
+				report = append(report, fmt.Sprintf("  %s: %s", tableName, d))
+			} else {
+				dm.logger.Warn(fmt.Sprintf("Table %s: %s", tableName, d))
+			}
+		}
+	}
+
+	if len(report) > 0 {
+		return fmt.Errorf("destination schema is incompatible with the source, inserts would fail:\n%s",
+			strings.Join(report, "\n"))
+	}
+	return nil
+}
+
+// typeFamily groups column types by the values they hold, which the destination converts
+// between types of one family but rejects across families
+func typeFamily(columnType string) string {
+	t := strings.ToLower(columnType)
+	switch {
+	case isBinaryType(t):
+		return "binary"
+	case isIntegerType(t):
+		return "number"
+	}
+	for _, family := range []struct {
+		name     string
+		prefixes []string
+	}{
+		{"number", []string{"decimal", "numeric", "float", "double", "real", "serial", "bigserial", "smallserial"}},
+		{"date", []string{"date", "time", "year"}},
+		{"text", []string{"char", "varchar", "character", "text", "tinytext", "mediumtext", "longtext", "enum", "set", "json", "uuid"}},
+	} {
+		for _, prefix := range family.prefixes {
+			if strings.HasPrefix(t, prefix) {
+				return family.name
+			}
+		}
+	}
+	if base, _, ok := strings.Cut(t, "("); ok {
+		return base
+	}
+	return t
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/duymanh3602/migrate-tool/internal/env"
+	applog "github.com/duymanh3602/migrate-tool/internal/log"
+	"gopkg.in/yaml.v3"
+)
+
+// envVarPattern matches the ${ENV_VAR} references expanded in password fields
+var envVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// LoadConfig reads a MigrationConfig from a YAML or JSON file (JSON is parsed as YAML).
+// Passwords may reference environment variables as ${ENV_VAR} so secrets stay out of the file.
+func LoadConfig(path string) (MigrationConfig, error) {
+	config, err := readConfigFile(path)
+	if err != nil {
+		return config, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return config, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// readConfigFile parses a config file without validating it, so command-line flags can fill the gaps
+func readConfigFile(path string) (MigrationConfig, error) {
+	var config MigrationConfig
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	config.Source.Password, err = expandEnv(config.Source.Password)
+	if err != nil {
+		return config, fmt.Errorf("source.password: %v", err)
+	}
+	config.Destination.Password, err = expandEnv(config.Destination.Password)
+	if err != nil {
+		return config, fmt.Errorf("destination.password: %v", err)
+	}
+
+	return config, nil
+}
+
+// expandEnv replaces ${ENV_VAR} references with their value, failing on unset variables
+func expandEnv(value string) (string, error) {
+	var missing []string
+	expanded := envVarPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envVarPattern.FindStringSubmatch(ref)[1]
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// Validate checks that the required settings are present, naming the first missing one
+func (c MigrationConfig) Validate() error {
+	required := []struct {
+		name  string
+		env   string
+		value string
+	}{
+		{"source.host", env.SourceHost, c.Source.Host},
+		{"source.database", env.SourceDB, c.Source.Database},
+		{"destination.host", env.DestHost, c.Destination.Host},
+		{"destination.database", env.DestDB, c.Destination.Database},
+	}
+	for _, field := range required {
+		// A SQLite destination is just a file, named by destination.database
+		if field.name == "destination.host" && c.Destination.Driver == driverSQLite {
+			continue
+		}
+		if strings.TrimSpace(field.value) == "" {
+			return fmt.Errorf("%s is required, set it in the config file, with its flag or as %s", field.name, field.env)
+		}
+	}
+
+	if c.BatchSize <= 0 {
+		return fmt.Errorf("batchSize must be greater than 0, got %d", c.BatchSize)
+	}
+	if _, err := applog.ParseLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if c.LogFormat != "" && c.LogFormat != applog.FormatText && c.LogFormat != applog.FormatJSON {
+		return fmt.Errorf("logFormat must be %s or %s, got %q", applog.FormatText, applog.FormatJSON, c.LogFormat)
+	}
+	if c.Concurrency < 0 {
+		return fmt.Errorf("concurrency must not be negative, got %d", c.Concurrency)
+	}
+	if c.TableWorkers < 0 {
+		return fmt.Errorf("tableWorkers must not be negative, got %d", c.TableWorkers)
+	}
+	if c.CheckpointEvery < 0 {
+		return fmt.Errorf("checkpointEvery must not be negative, got %d", c.CheckpointEvery)
+	}
+	if c.ConnectMaxAttempts < 0 {
+		return fmt.Errorf("connectMaxAttempts must not be negative, got %d", c.ConnectMaxAttempts)
+	}
+	if c.SampleMaxReferencedKeys < 0 {
+		return fmt.Errorf("sampleMaxReferencedKeys must not be negative, got %d", c.SampleMaxReferencedKeys)
+	}
+	if c.MaxRowsPerTable < 0 {
+		return fmt.Errorf("maxRowsPerTable must not be negative, got %d", c.MaxRowsPerTable)
+	}
+	if c.SampleStrategy != "" && c.SampleStrategy != sampleHead && c.SampleStrategy != sampleRandom {
+		return fmt.Errorf("sampleStrategy must be %s or %s, got %q", sampleHead, sampleRandom, c.SampleStrategy)
+	}
+	if c.QueryTimeout < 0 {
+		return fmt.Errorf("queryTimeout must not be negative, got %v", c.QueryTimeout)
+	}
+	if c.TruncateBeforeInsert && c.SkipExistingRows {
+		return fmt.Errorf("truncateBeforeInsert and skipExistingRows cannot both be set")
+	}
+	if c.SchemaOnly && c.DataOnly {
+		return fmt.Errorf("schemaOnly and dataOnly cannot both be set")
+	}
+	// Without rows there is nothing to compare
+	if c.SchemaOnly && (c.Verify || c.ManifestFile != "") {
+		return fmt.Errorf("verify and manifestFile cannot be used with schemaOnly")
+	}
+	if c.TargetMySQLVersion != "" {
+		if _, err := parseMySQLVersion(c.TargetMySQLVersion); err != nil {
+			return fmt.Errorf("targetMySQLVersion: %v", err)
+		}
+	}
+	// Only tables that exist before the migration can differ from the source
+	if c.CheckSchema && !c.DataOnly && !c.SkipExistingTables {
+		return fmt.Errorf("checkSchema requires dataOnly or skipExistingTables")
+	}
+	// Definitions are only deferred while creating the tables
+	if c.DataOnly && c.DeferIndexes {
+		return fmt.Errorf("deferIndexes cannot be used with dataOnly")
+	}
+	if c.ConnectRetryDelay < 0 {
+		return fmt.Errorf("connectRetryDelay must not be negative, got %v", c.ConnectRetryDelay)
+	}
+	for _, db := range []struct {
+		name string
+		cfg  DatabaseConfig
+	}{{"source", c.Source}, {"destination", c.Destination}} {
+		if db.cfg.MaxOpenConns < 0 || db.cfg.MaxIdleConns < 0 || db.cfg.ConnMaxLifetime < 0 {
+			return fmt.Errorf("%s: maxOpenConns, maxIdleConns and connMaxLifetime must not be negative", db.name)
+		}
+		if db.cfg.TLS != nil && (db.cfg.TLS.ClientCert == "") != (db.cfg.TLS.ClientKey == "") {
+			return fmt.Errorf("%s.tls: clientCert and clientKey must be set together", db.name)
+		}
+		if db.cfg.MaxOpenConns > 0 && db.cfg.MaxIdleConns > db.cfg.MaxOpenConns {
+			return fmt.Errorf("%s.maxIdleConns (%d) cannot exceed maxOpenConns (%d)", db.name, db.cfg.MaxIdleConns, db.cfg.MaxOpenConns)
+		}
+	}
+	for tableName, columns := range c.OrderBy {
+		if len(columns) == 0 {
+			return fmt.Errorf("orderBy of table %s must list at least one column", tableName)
+		}
+	}
+	for tableName, mapping := range c.ColumnMappings {
+		if err := validateColumnMapping(tableName, mapping); err != nil {
+			return err
+		}
+	}
+
+	for _, db := range []struct {
+		name   string
+		driver string
+	}{{"source", c.Source.Driver}, {"destination", c.Destination.Driver}} {
+		if _, err := dialectFor(db.driver); err != nil {
+			return fmt.Errorf("%s.driver: %v", db.name, err)
+		}
+	}
+
+	if c.Source.Driver == driverSQLite {
+		return fmt.Errorf("source.driver: %s is only supported as the destination", driverSQLite)
+	}
+
+	// These features rely on MySQL-only SQL (ON DUPLICATE KEY, online ALTER, CHECKSUM TABLE)
+	if c.Source.Driver == driverPostgres || (c.Destination.Driver != "" && c.Destination.Driver != driverMySQL) {
+		mysqlOnly := []struct {
+			name string
+			set  bool
+		}{
+			{"skipExistingRows", c.SkipExistingRows},
+			{"deferIndexes", c.DeferIndexes},
+			{"manifestFile", c.ManifestFile != ""},
+		}
+		for _, feature := range mysqlOnly {
+			if feature.set {
+				return fmt.Errorf("%s is only supported between MySQL databases", feature.name)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// the MySQL 8.0 default collations, e.g. utf8mb4_0900_ai_ci
+	collation0900Pattern = regexp.MustCompile(`\butf8mb4_0900_(\w+)`)
+	// an index definition line of SHOW CREATE TABLE, up to the opening parenthesis of its key parts
+	indexLinePattern = regexp.MustCompile("^\\s*(?:UNIQUE |FULLTEXT |SPATIAL )?KEY `([^`]+)` ")
+	// a key part that is an expression rather than a column, written as ((expr))
+	functionalKeyPartPattern = regexp.MustCompile(`[(,]\s*\(`)
+	// a column definition line, to name the column whose default is dropped
+	columnLinePattern = regexp.MustCompile("^\\s*`([^`]+)`")
+)
+
+// mysqlVersion is a MySQL server version, compared by major, minor and patch
+type mysqlVersion struct {
+	major, minor, patch int
+}
+
+// parseMySQLVersion reads a version like 5.7 or 8.0.13, missing parts being 0
+func parseMySQLVersion(version string) (mysqlVersion, error) {
+	parts := strings.SplitN(strings.TrimSpace(version), ".", 3)
+	var numbers [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(leadingDigits(part))
+		if err != nil {
+			return mysqlVersion{}, fmt.Errorf("invalid MySQL version %q, expected e.g. 5.7 or 8.0.13", version)
+		}
+		numbers[i] = n
+	}
+	return mysqlVersion{numbers[0], numbers[1], numbers[2]}, nil
+}
+
+func (v mysqlVersion) before(major, minor, patch int) bool {
+	if v.major != major {
+		return v.major < major
+	}
+	if v.minor != minor {
+		return v.minor < minor
+	}
+	return v.patch < patch
+}
+
+// rewriteVersionDDL downgrades the syntax of a SHOW CREATE TABLE statement that an older
+// TargetMySQLVersion rejects, logging every rewrite:
+//   - utf8mb4_0900_* collations (8.0.1) become utf8mb4_general_ci, or utf8mb4_bin for _bin
+//   - expression defaults such as DEFAULT (uuid()) (8.0.13) are dropped
+//   - functional indexes (8.0.13) are dropped
+func (dm *DatabaseMigrator) rewriteVersionDDL(tableName, createStmt string) (string, error) {
+	if dm.config.TargetMySQLVersion == "" || dm.dest.name() != driverMySQL {
+		return createStmt, nil
+	}
+	target, err := parseMySQLVersion(dm.config.TargetMySQLVersion)
+	if err != nil {
+		return "", err
+	}
+
+	if target.before(8, 0, 1) {
+		createStmt = collation0900Pattern.ReplaceAllStringFunc(createStmt, func(collation string) string {
+			replacement := "utf8mb4_general_ci"
+			if strings.HasSuffix(collation, "_bin") {
+				replacement = "utf8mb4_bin"
+			}
+			dm.logger.Warn(fmt.Sprintf("Table %s: MySQL %s has no collation %s, using %s",
+				tableName, dm.config.TargetMySQLVersion, collation, replacement))
+			return replacement
+		})
+	}
+	if !target.before(8, 0, 13) {
+		return createStmt, nil
+	}
+
+	var kept []string
+	for _, line := range strings.Split(createStmt, "\n") {
+		if match := indexLinePattern.FindStringSubmatch(line); match != nil {
+			if functionalKeyPartPattern.MatchString(line[len(match[0]):]) {
+				dm.logger.Warn(fmt.Sprintf("Table %s: MySQL %s has no functional indexes, dropping index %s",
+					tableName, dm.config.TargetMySQLVersion, match[1]))
+				continue
+			}
+		} else if match := columnLinePattern.FindStringSubmatch(line); match != nil {
+			if rewritten, dropped := dropExpressionDefault(line); dropped != "" {
+				dm.logger.Warn(fmt.Sprintf("Table %s: MySQL %s has no expression defaults, dropping DEFAULT %s of column %s",
+					tableName, dm.config.TargetMySQLVersion, dropped, match[1]))
+				line = rewritten
+			}
+		}
+		kept = append(kept, line)
+	}
+
+	// A dropped index may have been the definition closing the list, which then keeps a comma
+	for i := 1; i < len(kept); i++ {
+		if strings.HasPrefix(strings.TrimSpace(kept[i]), ")") {
+			kept[i-1] = strings.TrimSuffix(kept[i-1], ",")
+			break
+		}
+	}
+	return strings.Join(kept, "\n"), nil
+}
+
+// dropExpressionDefault removes a DEFAULT (expr) clause from a column definition, returning
+// the definition and the removed expression, empty when the column has none. Quoted strings
+// and nested parentheses of the expression are skipped over.
+func dropExpressionDefault(line string) (string, string) {
+	start := strings.Index(line, " DEFAULT (")
+	if start < 0 {
+		return line, ""
+	}
+	open := start + len(" DEFAULT ")
+	depth := 0
+	var quote byte
+	for i := open; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return line[:start] + line[i+1:], line[open : i+1]
+			}
+		}
+	}
+	return line, ""
+}
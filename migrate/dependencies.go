@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ForeignKeyProvider looks up the foreign keys of a table, which the DatabaseMigrator reads from
+// the source database. The table ordering only depends on it, so it can be computed without one.
+type ForeignKeyProvider interface {
+	GetTableForeignKeys(tableName string) ([]ForeignKeyInfo, error)
+}
+
+// sortTablesByDependencies sorts tables so that tables without dependencies come first
+func sortTablesByDependencies(provider ForeignKeyProvider, tables []string) ([]string, error) {
+	dependencies, err := tableDependencies(provider, tables)
+	if err != nil {
+		return nil, err
+	}
+
+	return sortByDependencies(tables, dependencies)
+}
+
+// tableDependencies maps each table to the tables in the list it references through foreign keys
+func tableDependencies(provider ForeignKeyProvider, tables []string) (map[string][]string, error) {
+	// Build dependency map
+	dependencies := make(map[string][]string)
+
+	// Get foreign keys for all tables
+	for _, tableName := range tables {
+		fks, err := provider.GetTableForeignKeys(tableName)
+		if err != nil {
+			return nil, err
+		}
+
+		var deps []string
+		for _, fk := range fks {
+			// Skip self-referencing foreign keys (they don't prevent table creation)
+			if fk.ReferencedTable == tableName {
+				continue
+			}
+
+			// Only include dependencies that are in our table list
+			for _, t := range tables {
+				if t == fk.ReferencedTable {
+					deps = append(deps, fk.ReferencedTable)
+					break
+				}
+			}
+		}
+		dependencies[tableName] = deps
+	}
+
+	return dependencies, nil
+}
+
+// CycleError reports tables whose foreign keys reference each other in a circle
+type CycleError struct {
+	// Cycle lists the tables in reference order, ending with the first one again
+	Cycle []string
+}
+
+func (e *CycleError) Error() string {
+	return fmt.Sprintf("circular dependency detected: %s", strings.Join(e.Cycle, " -> "))
+}
+
+// sortByDependencies topologically sorts tables so that every table comes after the tables it depends on,
+// returning a *CycleError when that is not possible
+func sortByDependencies(tables []string, dependencies map[string][]string) ([]string, error) {
+	// Topological sort
+	var result []string
+	visited := make(map[string]bool)
+	temp := make(map[string]bool)
+	// stack is the path of tables being visited, to report the whole cycle
+	var stack []string
+
+	var visit func(string) error
+	visit = func(tableName string) error {
+		if temp[tableName] {
+			return &CycleError{Cycle: cyclePath(stack, tableName)}
+		}
+		if visited[tableName] {
+			return nil
+		}
+
+		temp[tableName] = true
+		stack = append(stack, tableName)
+
+		for _, dep := range dependencies[tableName] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		temp[tableName] = false
+		visited[tableName] = true
+		result = append(result, tableName)
+		return nil
+	}
+
+	for _, tableName := range tables {
+		if !visited[tableName] {
+			if err := visit(tableName); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// cyclePath returns the part of stack from tableName on, closed by tableName again
+func cyclePath(stack []string, tableName string) []string {
+	for i, t := range stack {
+		if t == tableName {
+			return append(append([]string(nil), stack[i:]...), tableName)
+		}
+	}
+	return []string{tableName, tableName}
+}
+
+// breakDependencyCycles drops the dependencies that close a cycle, so the tables can still be
+// ordered. It returns the remaining dependencies and every cycle that was broken.
+func breakDependencyCycles(tables []string, dependencies map[string][]string) (map[string][]string, [][]string) {
+	pruned := make(map[string][]string, len(dependencies))
+	var cycles [][]string
+	visited := make(map[string]bool)
+	onStack := make(map[string]bool)
+	var stack []string
+
+	var visit func(string)
+	visit = func(tableName string) {
+		visited[tableName] = true
+		onStack[tableName] = true
+		stack = append(stack, tableName)
+
+		for _, dep := range dependencies[tableName] {
+			if onStack[dep] {
+				cycles = append(cycles, cyclePath(stack, dep))
+				continue
+			}
+			pruned[tableName] = append(pruned[tableName], dep)
+			if !visited[dep] {
+				visit(dep)
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		onStack[tableName] = false
+	}
+
+	for _, tableName := range tables {
+		if !visited[tableName] {
+			visit(tableName)
+		}
+	}
+	return pruned, cycles
+}
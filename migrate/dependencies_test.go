@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// fakeForeignKeys is a ForeignKeyProvider over table -> referenced tables
+type fakeForeignKeys map[string][]string
+
+func (f fakeForeignKeys) GetTableForeignKeys(tableName string) ([]ForeignKeyInfo, error) {
+	var fks []ForeignKeyInfo
+	for _, referenced := range f[tableName] {
+		fks = append(fks, ForeignKeyInfo{TableName: tableName, ColumnName: referenced + "_id", ReferencedTable: referenced, ReferencedColumn: "id"})
+	}
+	return fks, nil
+}
+
+type failingForeignKeys struct{}
+
+func (failingForeignKeys) GetTableForeignKeys(string) ([]ForeignKeyInfo, error) {
+	return nil, errors.New("connection lost")
+}
+
+func TestSortTablesByDependencies(t *testing.T) {
+	tests := []struct {
+		name   string
+		fks    fakeForeignKeys
+		tables []string
+		want   []string
+	}{
+		{
+			name:   "no foreign keys keeps the order",
+			tables: []string{"b", "a", "c"},
+			want:   []string{"b", "a", "c"},
+		},
+		{
+			name:   "chain",
+			fks:    fakeForeignKeys{"orders": {"customers"}, "order_items": {"orders"}},
+			tables: []string{"order_items", "orders", "customers"},
+			want:   []string{"customers", "orders", "order_items"},
+		},
+		{
+			name:   "diamond",
+			fks:    fakeForeignKeys{"b": {"a"}, "c": {"a"}, "d": {"b", "c"}},
+			tables: []string{"d", "c", "b", "a"},
+			want:   []string{"a", "b", "c", "d"},
+		},
+		{
+			name:   "self reference is ignored",
+			fks:    fakeForeignKeys{"categories": {"categories"}, "products": {"categories"}},
+			tables: []string{"products", "categories"},
+			want:   []string{"categories", "products"},
+		},
+		{
+			name:   "tables outside the list are ignored",
+			fks:    fakeForeignKeys{"posts": {"users", "blogs"}},
+			tables: []string{"posts", "blogs"},
+			want:   []string{"blogs", "posts"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sortTablesByDependencies(tt.fks, tt.tables)
+			if err != nil {
+				t.Fatalf("sortTablesByDependencies() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sortTablesByDependencies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortTablesByDependenciesCycles(t *testing.T) {
+	tests := []struct {
+		name      string
+		fks       fakeForeignKeys
+		tables    []string
+		wantCycle []string
+	}{
+		{
+			name:      "two tables",
+			fks:       fakeForeignKeys{"a": {"b"}, "b": {"a"}},
+			tables:    []string{"a", "b"},
+			wantCycle: []string{"a", "b", "a"},
+		},
+		{
+			name:      "full path of a longer cycle",
+			fks:       fakeForeignKeys{"a": {"b"}, "b": {"c"}, "c": {"a"}},
+			tables:    []string{"a", "b", "c"},
+			wantCycle: []string{"a", "b", "c", "a"},
+		},
+		{
+			name:      "cycle reached through another table",
+			fks:       fakeForeignKeys{"x": {"a"}, "a": {"b"}, "b": {"a"}},
+			tables:    []string{"x", "a", "b"},
+			wantCycle: []string{"a", "b", "a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sortTablesByDependencies(tt.fks, tt.tables)
+			var cycleErr *CycleError
+			if !errors.As(err, &cycleErr) {
+				t.Fatalf("sortTablesByDependencies() error = %v, want a *CycleError", err)
+			}
+			if !reflect.DeepEqual(cycleErr.Cycle, tt.wantCycle) {
+				t.Errorf("cycle = %v, want %v", cycleErr.Cycle, tt.wantCycle)
+			}
+		})
+	}
+}
+
+func TestSortTablesByDependenciesProviderError(t *testing.T) {
+	if _, err := sortTablesByDependencies(failingForeignKeys{}, []string{"a"}); err == nil {
+		t.Fatal("sortTablesByDependencies() error = nil, want the provider error")
+	}
+}
+
+func TestBreakDependencyCycles(t *testing.T) {
+	tables := []string{"a", "b", "c"}
+	dependencies := map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}}
+
+	pruned, cycles := breakDependencyCycles(tables, dependencies)
+	if want := [][]string{{"a", "b", "c", "a"}}; !reflect.DeepEqual(cycles, want) {
+		t.Errorf("cycles = %v, want %v", cycles, want)
+	}
+	got, err := sortByDependencies(tables, pruned)
+	if err != nil {
+		t.Fatalf("sortByDependencies() after breaking cycles error = %v", err)
+	}
+	if want := []string{"c", "b", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sortByDependencies() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Supported values of DatabaseConfig.Driver
+const (
+	driverMySQL    = "mysql"
+	driverPostgres = "postgres"
+	driverSQLite   = "sqlite"
+)
+
+// Supported values of MigrationConfig.SampleStrategy
+const (
+	sampleHead   = "head"
+	sampleRandom = "random"
+)
+
+// dialect holds the SQL that differs between the supported databases. The table
+// ordering and batch copy in DatabaseMigrator only go through these methods.
+type dialect interface {
+	// name is the DatabaseConfig.Driver value of the dialect
+	name() string
+	open(cfg DatabaseConfig) (*sql.DB, error)
+	quote(identifier string) string
+	// placeholder is the bind parameter for the n-th (1-based) argument of a query
+	placeholder(n int) string
+	// randomOrder is the ORDER BY expression shuffling the rows of a query
+	randomOrder() string
+	// sampleHash is a non-negative integer expression hashing the columns of a row together with
+	// the seed expression, the same for the same values on every run
+	sampleHash(seed string, columns []string) (string, error)
+
+	listTables(ctx context.Context, db Querier) ([]string, error)
+	columnInfo(ctx context.Context, db Querier, tableName string) ([]ColumnInfo, error)
+	primaryKey(ctx context.Context, db Querier, tableName string) ([]string, error)
+	foreignKeys(ctx context.Context, db Querier, database, tableName string) ([]ForeignKeyInfo, error)
+	// indexes lists the secondary indexes of a table, leaving out the primary key
+	indexes(ctx context.Context, db Querier, tableName string) ([]IndexInfo, error)
+	createIndex(tableName string, idx IndexInfo) (string, error)
+	setForeignKeyChecks(ctx context.Context, db Querier, enabled bool) error
+
+	// truncate builds the statement removing every row of a table
+	truncate(tableName string) string
+
+	// upsert builds an INSERT that doesn't fail when a row with the same key already exists
+	upsert(tableName string, columns []string, placeholders string) string
+
+	// columnType translates a column of a table read from the source dialect into this dialect's type
+	columnType(source dialect, info ColumnInfo) (string, error)
+	// prepareValues converts scanned source values, in place, into values this dialect accepts
+	prepareValues(columns []ColumnInfo, values []interface{})
+	// finishTable runs once a table's data is loaded, e.g. to move identity sequences past the copied keys
+	finishTable(ctx context.Context, db Querier, tableName string, columns []ColumnInfo) error
+}
+
+func dialectFor(driver string) (dialect, error) {
+	switch driver {
+	case "", driverMySQL:
+		return mysqlDialect{}, nil
+	case driverPostgres:
+		return postgresDialect{}, nil
+	case driverSQLite:
+		return sqliteDialect{}, nil
+	}
+	return nil, fmt.Errorf("unsupported driver %q, must be %q, %q or %q", driver, driverMySQL, driverPostgres, driverSQLite)
+}
+
+// quoteList quotes identifiers and joins them into a column list
+func quoteList(d dialect, identifiers []string) string {
+	quoted := make([]string, len(identifiers))
+	for i, id := range identifiers {
+		quoted[i] = d.quote(id)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// placeholderList returns the bind parameters for a row of n values
+func placeholderList(d dialect, n int) string {
+	params := make([]string, n)
+	for i := range params {
+		params[i] = d.placeholder(i + 1)
+	}
+	return strings.Join(params, ",")
+}
+
+// buildCreateTable generates a CREATE TABLE statement in the dest dialect from the source
+// columns. Only column types, nullability and the primary key are carried over; indexes,
+// foreign keys and defaults are not, so such tables are loaded without constraint checks.
+func buildCreateTable(source, dest dialect, tableName string, columns []ColumnInfo, primaryKey []string) (string, error) {
+	var defs []string
+	for _, col := range columns {
+		typ, err := dest.columnType(source, col)
+		if err != nil {
+			return "", fmt.Errorf("column %s: %v", col.Name, err)
+		}
+
+		// The expression of a generated column is not carried over and its rows are not
+		// copied, so it is left NULL
+		def := dest.quote(col.Name) + " " + typ
+		if !col.Nullable && !isGeneratedColumn(col) {
+			def += " NOT NULL"
+		}
+		defs = append(defs, def)
+	}
+	if len(primaryKey) > 0 {
+		defs = append(defs, fmt.Sprintf("PRIMARY KEY (%s)", quoteList(dest, primaryKey)))
+	}
+
+	return fmt.Sprintf("CREATE TABLE %s (\n  %s\n)", dest.quote(tableName), strings.Join(defs, ",\n  ")), nil
+}
+
+// isAutoIncrement reports whether a column takes its value from a sequence
+func isAutoIncrement(col ColumnInfo) bool {
+	return strings.Contains(strings.ToLower(col.Extra), "auto_increment")
+}
+
+// isGeneratedColumn reports whether a column is computed by the database from an expression,
+// VIRTUAL or STORED GENERATED in SHOW COLUMNS. MySQL rejects values inserted into it.
+// DEFAULT_GENERATED only marks an expression default and the column is still written.
+func isGeneratedColumn(col ColumnInfo) bool {
+	extra := strings.ToLower(col.Extra)
+	return strings.Contains(extra, "virtual generated") || strings.Contains(extra, "stored generated")
+}
+
+// splitGeneratedColumns separates the columns rows are copied from, from the names of the
+// generated ones
+func splitGeneratedColumns(columns []ColumnInfo) ([]ColumnInfo, []string) {
+	var copied []ColumnInfo
+	var generated []string
+	for _, info := range columns {
+		if isGeneratedColumn(info) {
+			generated = append(generated, info.Name)
+		} else {
+			copied = append(copied, info)
+		}
+	}
+	return copied, generated
+}
+
+// mysqlDialect is the original MySQL behaviour of the migrator
+type mysqlDialect struct{}
+
+func (mysqlDialect) name() string { return driverMySQL }
+
+func (mysqlDialect) open(cfg DatabaseConfig) (*sql.DB, error) {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true",
+		cfg.Username, cfg.Password, cfg.Host, cfg.Port, cfg.Database)
+	if cfg.TLS != nil {
+		name, err := registerMySQLTLS(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS settings: %v", err)
+		}
+		dsn += "&tls=" + name
+	}
+	// parameters the driver doesn't know are set as session variables on every connection
+	if cfg.withoutForeignKeyChecks {
+		dsn += "&foreign_key_checks=0"
+	}
+	return sql.Open("mysql", dsn)
+}
+
+func (mysqlDialect) quote(identifier string) string {
+	return "`" + strings.ReplaceAll(identifier, "`", "``") + "`"
+}
+
+func (mysqlDialect) placeholder(int) string { return "?" }
+
+func (mysqlDialect) randomOrder() string { return "RAND()" }
+
+func (d mysqlDialect) sampleHash(seed string, columns []string) (string, error) {
+	return fmt.Sprintf("CRC32(CONCAT_WS(',', %s, %s))", seed, quoteList(d, columns)), nil
+}
+
+func (mysqlDialect) upsert(tableName string, columns []string, placeholders string) string {
+	return upsertQuery(tableName, columns, placeholders)
+}
+
+func (d mysqlDialect) truncate(tableName string) string {
+	return "TRUNCATE TABLE " + d.quote(tableName)
+}
+
+func (mysqlDialect) listTables(ctx context.Context, db Querier) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SHOW TABLES")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tables: %v", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			return nil, fmt.Errorf("failed to scan table name: %v", err)
+		}
+		tables = append(tables, tableName)
+	}
+	return tables, rows.Err()
+}
+
+func (mysqlDialect) columnInfo(ctx context.Context, db Querier, tableName string) ([]ColumnInfo, error) {
+	query := fmt.Sprintf("SHOW COLUMNS FROM `%s`", tableName)
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns for table %s: %v", tableName, err)
+	}
+	defer rows.Close()
+
+	var columns []ColumnInfo
+	for rows.Next() {
+		var field, typ, null, key, defaultVal, extra sql.NullString
+		if err := rows.Scan(&field, &typ, &null, &key, &defaultVal, &extra); err != nil {
+			return nil, fmt.Errorf("failed to scan column info: %v", err)
+		}
+		columns = append(columns, ColumnInfo{
+			Name:     field.String,
+			Type:     typ.String,
+			Nullable: null.String == "YES",
+			Key:      key.String,
+			Default:  defaultVal,
+			Extra:    extra.String,
+		})
+	}
+
+	return columns, nil
+}
+
+func (mysqlDialect) primaryKey(ctx context.Context, db Querier, tableName string) ([]string, error) {
+	query := fmt.Sprintf("SHOW KEYS FROM `%s` WHERE Key_name = 'PRIMARY'", tableName)
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get primary key for table %s: %v", tableName, err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key columns for table %s: %v", tableName, err)
+	}
+
+	var pkColumns []string
+	for rows.Next() {
+		// SHOW KEYS has a version-dependent column set, so scan generically
+		values := make([]interface{}, len(cols))
+		valuePtrs := make([]interface{}, len(cols))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return nil, fmt.Errorf("failed to scan key info: %v", err)
+		}
+
+		for i, col := range cols {
+			if col == "Column_name" {
+				pkColumns = append(pkColumns, fmt.Sprintf("%s", values[i]))
+			}
+		}
+	}
+
+	return pkColumns, rows.Err()
+}
+
+func (mysqlDialect) foreignKeys(ctx context.Context, db Querier, database, tableName string) ([]ForeignKeyInfo, error) {
+	query := `
+		SELECT
+			COLUMN_NAME,
+			REFERENCED_TABLE_NAME,
+			REFERENCED_COLUMN_NAME
+		FROM INFORMATION_SCHEMA.KEY_COLUMN_USAGE
+		WHERE TABLE_SCHEMA = ?
+		AND TABLE_NAME = ?
+		AND REFERENCED_TABLE_NAME IS NOT NULL`
+
+	rows, err := db.QueryContext(ctx, query, database, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get foreign keys for table %s: %v", tableName, err)
+	}
+	defer rows.Close()
+
+	return scanForeignKeys(rows, tableName)
+}
+
+// scanForeignKeys reads (column, referenced table, referenced column) rows
+func scanForeignKeys(rows *sql.Rows, tableName string) ([]ForeignKeyInfo, error) {
+	var foreignKeys []ForeignKeyInfo
+	for rows.Next() {
+		var columnName, referencedTable, referencedColumn sql.NullString
+		if err := rows.Scan(&columnName, &referencedTable, &referencedColumn); err != nil {
+			return nil, fmt.Errorf("failed to scan foreign key info: %v", err)
+		}
+
+		if referencedTable.Valid && referencedColumn.Valid {
+			foreignKeys = append(foreignKeys, ForeignKeyInfo{
+				TableName:        tableName,
+				ColumnName:       columnName.String,
+				ReferencedTable:  referencedTable.String,
+				ReferencedColumn: referencedColumn.String,
+			})
+		}
+	}
+
+	return foreignKeys, rows.Err()
+}
+
+// indexes reads SHOW INDEX, skipping functional key parts that have no column
+func (mysqlDialect) indexes(ctx context.Context, db Querier, tableName string) ([]IndexInfo, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SHOW INDEX FROM `%s`", tableName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get indexes for table %s: %v", tableName, err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index columns for table %s: %v", tableName, err)
+	}
+
+	var indexes []IndexInfo
+	byName := make(map[string]int)
+	for rows.Next() {
+		// SHOW INDEX has a version-dependent column set, so scan generically
+		values := make([]sql.NullString, len(cols))
+		valuePtrs := make([]interface{}, len(cols))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return nil, fmt.Errorf("failed to scan index info: %v", err)
+		}
+
+		field := make(map[string]sql.NullString, len(cols))
+		for i, col := range cols {
+			field[col] = values[i]
+		}
+		name := field["Key_name"].String
+		if name == "PRIMARY" || !field["Column_name"].Valid {
+			continue
+		}
+
+		column := field["Column_name"].String
+		if part := field["Sub_part"]; part.Valid {
+			column += "(" + part.String + ")"
+		}
+
+		i, ok := byName[name]
+		if !ok {
+			i = len(indexes)
+			byName[name] = i
+			indexes = append(indexes, IndexInfo{
+				Name:   name,
+				Unique: field["Non_unique"].String == "0",
+				Type:   field["Index_type"].String,
+			})
+		}
+		indexes[i].Columns = append(indexes[i].Columns, column)
+	}
+
+	return indexes, rows.Err()
+}
+
+func (d mysqlDialect) createIndex(tableName string, idx IndexInfo) (string, error) {
+	kind := "INDEX"
+	switch {
+	case idx.Type != "" && idx.Type != "BTREE" && idx.Type != "HASH" && idx.Type != "FULLTEXT" && idx.Type != "SPATIAL":
+		return "", fmt.Errorf("%s indexes have no MySQL equivalent", idx.Type)
+	case idx.Type == "FULLTEXT" || idx.Type == "SPATIAL":
+		kind = idx.Type + " INDEX"
+	case idx.Unique:
+		kind = "UNIQUE INDEX"
+	}
+
+	columns := make([]string, len(idx.Columns))
+	for i, col := range idx.Columns {
+		// Keep a prefix length outside the quoted name
+		name, prefix, _ := strings.Cut(col, "(")
+		columns[i] = d.quote(name)
+		if prefix != "" {
+			columns[i] += "(" + prefix
+		}
+	}
+
+	return fmt.Sprintf("ALTER TABLE %s ADD %s %s (%s)",
+		d.quote(tableName), kind, d.quote(idx.Name), strings.Join(columns, ", ")), nil
+}
+
+func (mysqlDialect) setForeignKeyChecks(ctx context.Context, db Querier, enabled bool) error {
+	value := 0
+	if enabled {
+		value = 1
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf("SET FOREIGN_KEY_CHECKS = %d", value))
+	return err
+}
+
+// pgTypePattern splits a format_type() type into its name and optional length or precision,
+// e.g. character varying(255) or timestamp(3) without time zone
+var pgTypePattern = regexp.MustCompile(`^([a-z][a-z ]*?)(\([\d, ]+\))?([a-z ]*)(\[\])?$`)
+
+// columnType maps Postgres types to their closest MySQL equivalent
+func (mysqlDialect) columnType(source dialect, info ColumnInfo) (string, error) {
+	if source.name() == driverMySQL {
+		// only reached for tables with ColumnMappings, the others are copied with SHOW CREATE TABLE
+		if isAutoIncrement(info) {
+			return info.Type + " AUTO_INCREMENT", nil
+		}
+		return info.Type, nil
+	}
+
+	match := pgTypePattern.FindStringSubmatch(strings.ToLower(info.Type))
+	if match == nil {
+		return "", fmt.Errorf("unrecognized Postgres type %s", info.Type)
+	}
+	if match[4] != "" {
+		// Arrays have no MySQL column type; the driver returns their text form, e.g. {1,2}
+		return "longtext", nil
+	}
+	base, size := strings.TrimSpace(match[1]+match[3]), match[2]
+
+	var typ string
+	switch base {
+	case "smallint":
+		typ = "smallint"
+	case "integer":
+		typ = "int"
+	case "bigint":
+		typ = "bigint"
+	case "boolean":
+		typ = "tinyint(1)"
+	case "real":
+		typ = "float"
+	case "double precision":
+		typ = "double"
+	case "numeric":
+		typ = "decimal" + size
+	case "character varying":
+		if size == "" {
+			typ = "longtext"
+		} else {
+			typ = "varchar" + size
+		}
+	case "character":
+		typ = "char" + size
+	case "text":
+		typ = "longtext"
+	case "uuid":
+		typ = "char(36)"
+	case "json", "jsonb":
+		typ = "json"
+	case "bytea":
+		typ = "longblob"
+	case "date":
+		typ = "date"
+	case "timestamp without time zone", "timestamp with time zone":
+		typ = "datetime" + size
+	case "time without time zone", "time with time zone":
+		typ = "time" + size
+	default:
+		return "", fmt.Errorf("no MySQL equivalent for Postgres type %s", info.Type)
+	}
+
+	if isAutoIncrement(info) {
+		typ += " AUTO_INCREMENT"
+	}
+	return typ, nil
+}
+
+func (mysqlDialect) prepareValues([]ColumnInfo, []interface{}) {}
+
+func (mysqlDialect) finishTable(context.Context, Querier, string, []ColumnInfo) error { return nil }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsGeneratedColumn(t *testing.T) {
+	tests := []struct {
+		extra string
+		want  bool
+	}{
+		{extra: "", want: false},
+		{extra: "auto_increment", want: false},
+		{extra: "VIRTUAL GENERATED", want: true},
+		{extra: "STORED GENERATED", want: true},
+		{extra: "virtual generated", want: true},
+		{extra: "DEFAULT_GENERATED", want: false},
+		{extra: "DEFAULT_GENERATED on update CURRENT_TIMESTAMP", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.extra, func(t *testing.T) {
+			if got := isGeneratedColumn(ColumnInfo{Name: "c", Extra: tt.extra}); got != tt.want {
+				t.Errorf("isGeneratedColumn(Extra %q) = %v, want %v", tt.extra, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitGeneratedColumns(t *testing.T) {
+	columns := []ColumnInfo{
+		{Name: "id", Type: "int", Extra: "auto_increment"},
+		{Name: "price", Type: "decimal(10,2)"},
+		{Name: "total", Type: "decimal(10,2)", Extra: "VIRTUAL GENERATED"},
+		{Name: "created", Type: "datetime", Extra: "DEFAULT_GENERATED"},
+		{Name: "search", Type: "text", Extra: "STORED GENERATED"},
+	}
+
+	copied, generated := splitGeneratedColumns(columns)
+	var copiedNames []string
+	for _, info := range copied {
+		copiedNames = append(copiedNames, info.Name)
+	}
+	if want := []string{"id", "price", "created"}; !reflect.DeepEqual(copiedNames, want) {
+		t.Errorf("copied = %v, want %v", copiedNames, want)
+	}
+	if want := []string{"total", "search"}; !reflect.DeepEqual(generated, want) {
+		t.Errorf("generated = %v, want %v", generated, want)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+)
+
+// logDryRun reports the tables Migrate would create, in order, and how many rows each
+// would copy, without writing anything to the destination
+func (dm *DatabaseMigrator) logDryRun(sortedTables []string, dependencies map[string][]string) error {
+	dm.logger.Log("DRY RUN MODE - No tables will be created and no rows copied")
+
+	totalRows := 0
+	for i, tableName := range sortedTables {
+		rowCount, err := dm.GetTableRowCount(tableName)
+		if err != nil {
+			return err
+		}
+		totalRows += rowCount
+
+		line := fmt.Sprintf("Would migrate table %d/%d: %s (%d rows)", i+1, len(sortedTables), tableName, rowCount)
+		if rate, ok := dm.config.SampleRates[tableName]; ok && rate < 1 {
+			line += fmt.Sprintf(", sampled at %.2f%%", rate*100)
+		}
+		if deps := dependencies[tableName]; len(deps) > 0 {
+			line += fmt.Sprintf(", after %v", deps)
+		}
+		dm.logger.Log(line)
+	}
+
+	if len(dm.config.OnlyTables) > 0 {
+		dm.logger.Log(fmt.Sprintf("Only migrating tables: %v", dm.config.OnlyTables))
+	}
+	if len(dm.config.SkipTables) > 0 {
+		dm.logger.Log(fmt.Sprintf("Would skip tables: %v", dm.config.SkipTables))
+	}
+	dm.logger.Log(fmt.Sprintf("Dry run complete: %d tables, %d rows would be migrated", len(sortedTables), totalRows))
+	return nil
+}
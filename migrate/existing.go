@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// keySet is a sparse bitset of integer primary keys, one bit per key grouped
+// into 64-bit words, so dense id ranges cost ~1 bit per row
+type keySet struct {
+	words map[int64]uint64
+	size  int
+}
+
+func newKeySet() *keySet {
+	return &keySet{words: make(map[int64]uint64)}
+}
+
+func (ks *keySet) Add(key int64) {
+	word, bit := key>>6, uint64(1)<<uint(key&63)
+	if ks.words[word]&bit == 0 {
+		ks.words[word] |= bit
+		ks.size++
+	}
+}
+
+func (ks *keySet) Has(key int64) bool {
+	return ks.words[key>>6]&(uint64(1)<<uint(key&63)) != 0
+}
+
+func (ks *keySet) Len() int {
+	return ks.size
+}
+
+// isIntegerType reports whether a SHOW COLUMNS type is one of MySQL's integer types
+func isIntegerType(columnType string) bool {
+	columnType = strings.ToLower(columnType)
+	for _, prefix := range []string{"tinyint", "smallint", "mediumint", "int", "bigint"} {
+		if strings.HasPrefix(columnType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// toInt64 converts a scanned integer value, which the driver returns as int64
+// or as its textual []byte form depending on the protocol used
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case uint64:
+		return int64(v), true
+	case []byte:
+		n, err := strconv.ParseInt(string(v), 10, 64)
+		return n, err == nil
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// loadExistingKeys reads the primary keys already present in the destination table,
+// paging through them by key so very large tables are never loaded in one result set.
+// It returns a nil set when the table has no single integer primary key, in which
+// case the caller should fall back to upserting.
+func (dm *DatabaseMigrator) loadExistingKeys(tableName string) (*keySet, string, error) {
+	pkColumns, err := dm.GetPrimaryKeyColumns(tableName)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(pkColumns) != 1 {
+		return nil, "", nil
+	}
+	pkColumn := pkColumns[0]
+	if dm.isExcludedColumn(tableName, pkColumn) {
+		return nil, "", nil
+	}
+	destColumn := dm.destColumnName(tableName, pkColumn)
+
+	infos, err := dm.GetTableColumnInfo(tableName)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, info := range infos {
+		if info.Name == pkColumn && !isIntegerType(info.Type) {
+			return nil, "", nil
+		}
+	}
+
+	keys := newKeySet()
+	query := fmt.Sprintf("SELECT `%s` FROM `%s` WHERE `%s` > ? ORDER BY `%s` LIMIT %d",
+		destColumn, tableName, destColumn, destColumn, dm.config.BatchSize)
+
+	var last int64 = -1 << 63
+	cancel := func() {}
+	defer func() { cancel() }()
+	for {
+		cancel()
+		var ctx context.Context
+		ctx, cancel = dm.queryContext()
+
+		rows, err := dm.destDB.QueryContext(ctx, query, last)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read existing keys from destination table %s: %v", tableName, err)
+		}
+
+		count := 0
+		for rows.Next() {
+			var key int64
+			if err := rows.Scan(&key); err != nil {
+				rows.Close()
+				return nil, "", fmt.Errorf("failed to scan existing key: %v", err)
+			}
+			keys.Add(key)
+			last = key
+			count++
+		}
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return nil, "", fmt.Errorf("failed to read existing keys from destination table %s: %v", tableName, err)
+		}
+		if count < dm.config.BatchSize {
+			break
+		}
+	}
+
+	return keys, pkColumn, nil
+}
+
+// upsertQuery builds an INSERT that updates the row when its key already exists
+func upsertQuery(tableName string, columns []string, placeholders string) string {
+	var updates []string
+	for _, col := range columns {
+		updates = append(updates, fmt.Sprintf("`%s` = VALUES(`%s`)", col, col))
+	}
+
+	return fmt.Sprintf("INSERT INTO `%s` (`%s`) VALUES (%s) ON DUPLICATE KEY UPDATE %s",
+		tableName, strings.Join(columns, "`, `"), placeholders, strings.Join(updates, ", "))
+}
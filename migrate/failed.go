@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultFailedRowsFile receives the rows rejected under ContinueOnError when FailedRowsFile is not set
+const defaultFailedRowsFile = "failed_rows.jsonl"
+
+// FailedRow is one line of the failed rows file
+type FailedRow struct {
+	Table  string                 `json:"table"`
+	Values map[string]interface{} `json:"values"`
+	Error  string                 `json:"error"`
+	Time   time.Time              `json:"time"`
+}
+
+// FailedRowsError is returned by Migrate when ContinueOnError skipped rows that the destination rejected
+type FailedRowsError struct {
+	File   string
+	Tables map[string]int
+}
+
+func (e *FailedRowsError) Rows() int {
+	total := 0
+	for _, count := range e.Tables {
+		total += count
+	}
+	return total
+}
+
+func (e *FailedRowsError) Error() string {
+	var tables []string
+	for table, count := range e.Tables {
+		tables = append(tables, fmt.Sprintf("%s: %d", table, count))
+	}
+	sort.Strings(tables)
+	return fmt.Sprintf("%d rows failed to migrate (%s), see %s", e.Rows(), strings.Join(tables, ", "), e.File)
+}
+
+// failedRowLog appends rejected rows to a JSONL file, which is only created once a row fails
+type failedRowLog struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	counts map[string]int
+}
+
+func newFailedRowLog(path string) *failedRowLog {
+	if path == "" {
+		path = defaultFailedRowsFile
+	}
+	return &failedRowLog{path: path, counts: make(map[string]int)}
+}
+
+func (fl *failedRowLog) record(tableName string, columns []string, values []interface{}, rowErr error) error {
+	row := FailedRow{
+		Table:  tableName,
+		Values: make(map[string]interface{}, len(columns)),
+		Error:  rowErr.Error(),
+		Time:   time.Now(),
+	}
+	for i, val := range encodeArchiveValues(values) {
+		row.Values[columns[i]] = val
+	}
+
+	line, err := json.Marshal(row)
+	if err != nil {
+		return fmt.Errorf("failed to encode failed row: %v", err)
+	}
+
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	if fl.file == nil {
+		fl.file, err = os.OpenFile(fl.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to create failed rows file: %v", err)
+		}
+	}
+	if _, err := fl.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write failed row: %v", err)
+	}
+	fl.counts[tableName]++
+	return nil
+}
+
+// failures returns the failed row counts per table, or nil when no row failed
+func (fl *failedRowLog) failures() *FailedRowsError {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	if len(fl.counts) == 0 {
+		return nil
+	}
+
+	tables := make(map[string]int, len(fl.counts))
+	for table, count := range fl.counts {
+		tables[table] = count
+	}
+	return &FailedRowsError{File: fl.path, Tables: tables}
+}
+
+func (fl *failedRowLog) Close() {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	if fl.file != nil {
+		fl.file.Close()
+	}
+}
+
+// insertRowsIndividually retries a rejected batch one row at a time outside a transaction,
+// recording the rows that still fail, and returns the rows that were inserted
+func (dm *DatabaseMigrator) insertRowsIndividually(ctx context.Context, insertStmt *sql.Stmt, tableName string,
+	columns []string, batch [][]interface{}) ([][]interface{}, error) {
+	var inserted [][]interface{}
+	for _, values := range batch {
+		if _, err := insertStmt.ExecContext(ctx, values...); err != nil {
+			if isBrokenConnection(err) || ctx.Err() != nil {
+				return inserted, fmt.Errorf("failed to insert row: %v", err)
+			}
+			if err := dm.failedRows.record(tableName, columns, values, err); err != nil {
+				return inserted, err
+			}
+			continue
+		}
+		inserted = append(inserted, values)
+	}
+	return inserted, nil
+}
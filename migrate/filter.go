@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// filterToken matches the parts of a row filter that matter for validation: string
+// literals, quoted identifiers and words, each optionally followed by an opening parenthesis
+var filterToken = regexp.MustCompile("'(?:[^'\\\\]|\\\\.|'')*'|\"(?:[^\"]|\"\")*\"|`(?:[^`]|``)*`|[A-Za-z_][A-Za-z0-9_$.]*\\s*\\(?")
+
+// filterKeywords are the words of a row filter that are not column names
+var filterKeywords = map[string]bool{
+	"and": true, "or": true, "not": true, "xor": true, "null": true, "is": true, "in": true,
+	"like": true, "escape": true, "regexp": true, "rlike": true, "between": true, "true": true,
+	"false": true, "div": true, "mod": true, "case": true, "when": true, "then": true, "else": true,
+	"end": true, "interval": true, "binary": true, "collate": true, "current_date": true,
+	"current_time": true, "current_timestamp": true, "microsecond": true, "second": true,
+	"minute": true, "hour": true, "day": true, "week": true, "month": true, "quarter": true, "year": true,
+}
+
+// rowFilter returns the RowFilters condition of a table, or "" when the table is copied in full.
+// Every column the filter mentions must exist in the source table.
+func (dm *DatabaseMigrator) rowFilter(tableName string) (string, error) {
+	filter := strings.TrimSpace(dm.config.RowFilters[tableName])
+	if filter == "" {
+		return "", nil
+	}
+
+	columns, err := dm.GetTableColumns(tableName)
+	if err != nil {
+		return "", err
+	}
+	known := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		known[strings.ToLower(column)] = true
+	}
+
+	for _, token := range filterToken.FindAllString(filter, -1) {
+		var column string
+		switch {
+		case strings.HasPrefix(token, "'"):
+			continue
+		case strings.HasPrefix(token, "`"):
+			column = strings.ReplaceAll(token[1:len(token)-1], "``", "`")
+		case strings.HasPrefix(token, "\""):
+			// Postgres quotes identifiers with double quotes, MySQL uses them for strings
+			if dm.source.name() != driverPostgres {
+				continue
+			}
+			column = strings.ReplaceAll(token[1:len(token)-1], "\"\"", "\"")
+		case strings.HasSuffix(token, "("):
+			// A function call
+			continue
+		default:
+			column = strings.TrimSpace(token)
+			if i := strings.LastIndex(column, "."); i >= 0 {
+				column = column[i+1:]
+			}
+			if filterKeywords[strings.ToLower(column)] {
+				continue
+			}
+		}
+		if !known[strings.ToLower(column)] {
+			return "", fmt.Errorf("row filter of table %s references unknown column %s", tableName, column)
+		}
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// OrphanedRows counts the destination rows of a foreign key column whose value has no
+// matching row in the referenced table
+type OrphanedRows struct {
+	ForeignKeyInfo
+	Count int
+}
+
+// ForeignKeyReport is the result of ValidateForeignKeys
+type ForeignKeyReport struct {
+	// Checked is the number of foreign key columns checked
+	Checked int
+	// Orphans lists the foreign keys with orphaned rows
+	Orphans []OrphanedRows
+}
+
+// OK reports whether every checked foreign key holds, so enabling foreign key checks will succeed
+func (r ForeignKeyReport) OK() bool {
+	return len(r.Orphans) == 0
+}
+
+func (r ForeignKeyReport) String() string {
+	if r.OK() {
+		return fmt.Sprintf("%d foreign keys checked, no orphaned rows", r.Checked)
+	}
+	var lines []string
+	for _, o := range r.Orphans {
+		lines = append(lines, fmt.Sprintf("%s.%s -> %s.%s: %d orphaned rows",
+			o.TableName, o.ColumnName, o.ReferencedTable, o.ReferencedColumn, o.Count))
+	}
+	return fmt.Sprintf("%d foreign keys checked, %d with orphaned rows:\n  %s",
+		r.Checked, len(r.Orphans), strings.Join(lines, "\n  "))
+}
+
+// ValidateForeignKeys counts, for every source foreign key of the tables, the destination rows
+// referencing a row that does not exist. Rows are copied with foreign key checks disabled, so
+// this finds the dangling references before constraints are enforced again. NULL references are
+// not counted, and foreign keys to tables missing from the destination are skipped.
+func (dm *DatabaseMigrator) ValidateForeignKeys(tables []string) (ForeignKeyReport, error) {
+	var report ForeignKeyReport
+
+	cancel := func() {}
+	defer func() { cancel() }()
+	for _, tableName := range tables {
+		fks, err := dm.GetTableForeignKeys(tableName)
+		if err != nil {
+			return report, err
+		}
+
+		for _, fk := range fks {
+			if dm.isExcludedColumn(fk.TableName, fk.ColumnName) || dm.isExcludedColumn(fk.ReferencedTable, fk.ReferencedColumn) {
+				continue
+			}
+			exists, err := dm.destTableExists(fk.ReferencedTable)
+			if err != nil {
+				return report, err
+			}
+			if !exists {
+				dm.logger.Warn(fmt.Sprintf("Not checking %s.%s: table %s does not exist in destination",
+					fk.TableName, fk.ColumnName, fk.ReferencedTable))
+				continue
+			}
+
+			cancel()
+			var ctx context.Context
+			ctx, cancel = dm.queryContext()
+
+			column := dm.dest.quote(dm.destColumnName(fk.TableName, fk.ColumnName))
+			referenced := dm.dest.quote(dm.destColumnName(fk.ReferencedTable, fk.ReferencedColumn))
+			query := fmt.Sprintf("SELECT COUNT(*) FROM %s c LEFT JOIN %s p ON c.%s = p.%s WHERE c.%s IS NOT NULL AND p.%s IS NULL",
+				dm.dest.quote(fk.TableName), dm.dest.quote(fk.ReferencedTable), column, referenced, column, referenced)
+
+			var count int
+			if err := dm.destDB.QueryRowContext(ctx, query).Scan(&count); err != nil {
+				return report, fmt.Errorf("failed to count orphaned rows of %s.%s: %v", fk.TableName, fk.ColumnName, err)
+			}
+			report.Checked++
+			if count > 0 {
+				dm.logger.Warn(fmt.Sprintf("Table %s: %d rows reference missing %s.%s through %s",
+					fk.TableName, count, fk.ReferencedTable, fk.ReferencedColumn, fk.ColumnName))
+				report.Orphans = append(report.Orphans, OrphanedRows{ForeignKeyInfo: fk, Count: count})
+			}
+		}
+	}
+
+	dm.logger.Log(report.String())
+	return report, nil
+}
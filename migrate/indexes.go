@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deferredDefinitions holds the secondary indexes and foreign keys stripped from a
+// table's CREATE TABLE so they can be built once the data is loaded
+type deferredDefinitions struct {
+	Indexes     []string
+	Constraints []string
+}
+
+// splitDeferredDefinitions removes the secondary index and foreign key definitions from a
+// SHOW CREATE TABLE statement, returning the reduced statement and what was removed
+func splitDeferredDefinitions(createStmt string) (string, deferredDefinitions) {
+	var deferred deferredDefinitions
+	var kept []string
+
+	for _, line := range strings.Split(createStmt, "\n") {
+		def := strings.TrimSuffix(strings.TrimSpace(line), ",")
+		switch {
+		case strings.HasPrefix(def, "KEY "),
+			strings.HasPrefix(def, "UNIQUE KEY "),
+			strings.HasPrefix(def, "FULLTEXT KEY "),
+			strings.HasPrefix(def, "SPATIAL KEY "):
+			deferred.Indexes = append(deferred.Indexes, def)
+		case strings.HasPrefix(def, "CONSTRAINT ") && strings.Contains(def, "FOREIGN KEY"):
+			deferred.Constraints = append(deferred.Constraints, def)
+		default:
+			kept = append(kept, line)
+		}
+	}
+
+	// The definition now closing the column list must not keep its trailing comma
+	for i := 1; i < len(kept); i++ {
+		if strings.HasPrefix(strings.TrimSpace(kept[i]), ")") {
+			kept[i-1] = strings.TrimSuffix(kept[i-1], ",")
+			break
+		}
+	}
+
+	return strings.Join(kept, "\n"), deferred
+}
+
+// indexTask is one deferred definition to add to a table
+type indexTask struct {
+	Table      string
+	Definition string
+}
+
+// addDefinition runs ALTER TABLE ... ADD, preferring a non-blocking in-place build and
+// falling back to the server's default algorithm when that is not supported
+func (dm *DatabaseMigrator) addDefinition(task indexTask, online bool) error {
+	ctx, cancel := dm.queryContext()
+	defer cancel()
+
+	if online {
+		query := fmt.Sprintf("ALTER TABLE `%s` ADD %s, ALGORITHM=INPLACE, LOCK=NONE", task.Table, task.Definition)
+		if _, err := dm.destDB.ExecContext(ctx, query); err == nil {
+			return nil
+		}
+	}
+
+	query := fmt.Sprintf("ALTER TABLE `%s` ADD %s", task.Table, task.Definition)
+	if _, err := dm.destDB.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to add %s to table %s: %v", task.Definition, task.Table, err)
+	}
+	return nil
+}
+
+// BuildDeferredIndexes builds the indexes stripped by DeferIndexes using up to
+// IndexConcurrency workers across tables, logging how long each index took
+func (dm *DatabaseMigrator) BuildDeferredIndexes(tables []string) error {
+	var tasks []indexTask
+	for _, tableName := range tables {
+		for _, def := range dm.deferred[tableName].Indexes {
+			tasks = append(tasks, indexTask{Table: tableName, Definition: def})
+		}
+	}
+	return dm.runIndexTasks("index", tasks, true)
+}
+
+// AddDeferredConstraints adds the foreign keys stripped by DeferIndexes, after their indexes exist
+func (dm *DatabaseMigrator) AddDeferredConstraints(tables []string) error {
+	var tasks []indexTask
+	for _, tableName := range tables {
+		for _, def := range dm.deferred[tableName].Constraints {
+			tasks = append(tasks, indexTask{Table: tableName, Definition: def})
+		}
+	}
+	return dm.runIndexTasks("constraint", tasks, false)
+}
+
+func (dm *DatabaseMigrator) runIndexTasks(kind string, tasks []indexTask, online bool) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	workers := dm.config.IndexConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	workers = min(workers, len(tasks))
+	dm.logger.Log(fmt.Sprintf("Building %d deferred %s definitions with %d workers", len(tasks), kind, workers))
+
+	startTime := time.Now()
+	taskCh := make(chan indexTask)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []string
+	done := 0
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range taskCh {
+				taskStart := time.Now()
+				err := dm.addDefinition(task, online)
+
+				mu.Lock()
+				done++
+				if err != nil {
+					errs = append(errs, err.Error())
+					dm.logger.Error(fmt.Sprintf("Failed %s %d/%d on %s: %v", kind, done, len(tasks), task.Table, err))
+				} else {
+					dm.logger.Log(fmt.Sprintf("Built %s %d/%d on %s in %v: %s",
+						kind, done, len(tasks), task.Table, time.Since(taskStart).Round(time.Millisecond), task.Definition))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, task := range tasks {
+		taskCh <- task
+	}
+	close(taskCh)
+	wg.Wait()
+
+	dm.logger.Log(fmt.Sprintf("Built %d deferred %s definitions in %v", len(tasks)-len(errs), kind, time.Since(startTime)))
+	if len(errs) > 0 {
+		return fmt.Errorf("%d %s definitions failed: %s", len(errs), kind, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// IndexInfo describes a secondary index of a table
+type IndexInfo struct {
+	Name   string
+	Unique bool
+	// Type is the index method, e.g. BTREE, FULLTEXT or SPATIAL
+	Type string
+	// Columns are in index order, with a MySQL prefix length as "col(10)"
+	Columns []string
+}
+
+// sameIndex reports whether two indexes cover the same columns in the same way
+func sameIndex(a, b IndexInfo) bool {
+	if a.Unique != b.Unique || len(a.Columns) != len(b.Columns) {
+		return false
+	}
+	for i := range a.Columns {
+		if !strings.EqualFold(a.Columns[i], b.Columns[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// MigrateIndexes creates the secondary indexes of a source table that the destination table
+// is missing, e.g. because its schema was created by hand or generated for another driver.
+// An index is considered present when the destination has one with the same name or the
+// same columns. The primary key is created with the table and never touched.
+func (dm *DatabaseMigrator) MigrateIndexes(tableName string) error {
+	ctx, cancel := dm.queryContext()
+	sourceIndexes, err := dm.source.indexes(ctx, dm.sourceDB, tableName)
+	if err != nil {
+		cancel()
+		return err
+	}
+	destIndexes, err := dm.dest.indexes(ctx, dm.destDB, tableName)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	created := 0
+	for _, idx := range sourceIndexes {
+		mapped, ok := dm.mapIndex(tableName, idx)
+		if !ok {
+			dm.logger.Warn(fmt.Sprintf("Not creating index %s on table %s: it covers an excluded column", idx.Name, tableName))
+			continue
+		}
+		idx = mapped
+		present := false
+		for _, existing := range destIndexes {
+			if strings.EqualFold(existing.Name, idx.Name) || sameIndex(existing, idx) {
+				present = true
+				break
+			}
+		}
+		if present {
+			continue
+		}
+
+		stmt, err := dm.dest.createIndex(tableName, idx)
+		if err != nil {
+			dm.logger.Warn(fmt.Sprintf("Not creating index %s on table %s: %v", idx.Name, tableName, err))
+			continue
+		}
+		ctx, cancel := dm.queryContext()
+		_, err = dm.destDB.ExecContext(ctx, stmt)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to create index %s on table %s: %v", idx.Name, tableName, err)
+		}
+		dm.logger.Log(fmt.Sprintf("Created index %s on table %s (%s)", idx.Name, tableName, strings.Join(idx.Columns, ", ")))
+		created++
+	}
+
+	if created > 0 {
+		dm.logger.Log(fmt.Sprintf("Created %d missing indexes on table %s", created, tableName))
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+//go:build integration
+
+package main
+
+// The integration tests run the migration against a MySQL container, so they need Docker:
+//
+//	go test -tags integration ./migrate/
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	tcmysql "github.com/testcontainers/testcontainers-go/modules/mysql"
+)
+
+const (
+	integrationMySQLImage    = "mysql:8.0.36"
+	integrationMySQLPassword = "secret"
+)
+
+// integrationSchema is the source database of TestMigrateIntegration: a chain of foreign keys,
+// generated and binary columns and enough rows to be read in several batches
+var integrationSchema = []string{
+	`CREATE TABLE customers (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		created_at DATETIME NOT NULL
+	)`,
+	`CREATE TABLE orders (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		customer_id INT NOT NULL,
+		total DECIMAL(10,2) NOT NULL,
+		total_cents INT AS (total * 100) VIRTUAL,
+		FOREIGN KEY (customer_id) REFERENCES customers (id)
+	)`,
+	`CREATE TABLE order_items (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		order_id INT NOT NULL,
+		sku VARCHAR(32) NOT NULL,
+		payload BLOB,
+		FOREIGN KEY (order_id) REFERENCES orders (id)
+	)`,
+}
+
+// startMySQL runs a MySQL container for the test and returns the config of its root user,
+// connected to database
+func startMySQL(t *testing.T, database string) DatabaseConfig {
+	t.Helper()
+	ctx := context.Background()
+
+	container, err := tcmysql.Run(ctx, integrationMySQLImage,
+		tcmysql.WithUsername("root"),
+		tcmysql.WithPassword(integrationMySQLPassword),
+		tcmysql.WithDatabase(database))
+	if err != nil {
+		t.Fatalf("failed to start MySQL: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Terminate(context.Background()); err != nil {
+			t.Logf("failed to stop MySQL: %v", err)
+		}
+	})
+
+	host, err := container.Host(ctx)
+	if err != nil {
+		t.Fatalf("failed to get the MySQL host: %v", err)
+	}
+	port, err := container.MappedPort(ctx, "3306/tcp")
+	if err != nil {
+		t.Fatalf("failed to get the MySQL port: %v", err)
+	}
+	return DatabaseConfig{Host: host, Port: port.Port(), Username: "root", Password: integrationMySQLPassword, Database: database}
+}
+
+func openIntegrationDB(t *testing.T, cfg DatabaseConfig) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", cfg.Username, cfg.Password, cfg.Host, cfg.Port, cfg.Database))
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", cfg.Database, err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func countRows(t *testing.T, db *sql.DB, table string) int {
+	t.Helper()
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM `" + table + "`").Scan(&count); err != nil {
+		t.Fatalf("failed to count %s: %v", table, err)
+	}
+	return count
+}
+
+func TestMigrateIntegration(t *testing.T) {
+	source := startMySQL(t, "shop")
+	dest := source
+	dest.Database = "shop_copy"
+
+	sourceDB := openIntegrationDB(t, source)
+	if _, err := sourceDB.Exec("CREATE DATABASE shop_copy"); err != nil {
+		t.Fatalf("failed to create the destination database: %v", err)
+	}
+	for _, stmt := range integrationSchema {
+		if _, err := sourceDB.Exec(stmt); err != nil {
+			t.Fatalf("failed to create the source schema: %v", err)
+		}
+	}
+
+	want := map[string]int{"customers": 5, "orders": 7, "order_items": 10}
+	for i := 1; i <= want["customers"]; i++ {
+		if _, err := sourceDB.Exec("INSERT INTO customers (name, created_at) VALUES (?, ?)",
+			fmt.Sprintf("customer %d", i), "2024-01-02 03:04:05"); err != nil {
+			t.Fatalf("failed to seed customers: %v", err)
+		}
+	}
+	for i := 1; i <= want["orders"]; i++ {
+		if _, err := sourceDB.Exec("INSERT INTO orders (customer_id, total) VALUES (?, ?)", i%want["customers"]+1, float64(i)*9.5); err != nil {
+			t.Fatalf("failed to seed orders: %v", err)
+		}
+	}
+	// the first payload reads as a zero date and must be copied byte for byte
+	payloads := [][]byte{[]byte("0000-00-00 00:00:00"), {0x00, 0xff, 0x10}}
+	for i := 1; i <= want["order_items"]; i++ {
+		if _, err := sourceDB.Exec("INSERT INTO order_items (order_id, sku, payload) VALUES (?, ?, ?)",
+			i%want["orders"]+1, fmt.Sprintf("SKU-%03d", i), payloads[i%len(payloads)]); err != nil {
+			t.Fatalf("failed to seed order_items: %v", err)
+		}
+	}
+
+	config := defaultConfig()
+	config.Source = source
+	config.Destination = dest
+	config.BatchSize = 3
+	config.LogFile = filepath.Join(t.TempDir(), "migration.log")
+	if err := config.Validate(); err != nil {
+		t.Fatalf("invalid config: %v", err)
+	}
+
+	migrator, err := NewDatabaseMigrator(context.Background(), config)
+	if err != nil {
+		t.Fatalf("NewDatabaseMigrator() error = %v", err)
+	}
+	defer migrator.Close()
+
+	report, err := migrator.Migrate()
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if total := want["customers"] + want["orders"] + want["order_items"]; report.RowsMigrated != total || report.FailedRows != 0 {
+		t.Errorf("report: %d rows migrated and %d failed, want %d and 0", report.RowsMigrated, report.FailedRows, total)
+	}
+
+	destDB := openIntegrationDB(t, dest)
+	for table, count := range want {
+		if got := countRows(t, destDB, table); got != count {
+			t.Errorf("%s has %d rows in the destination, want %d", table, got, count)
+		}
+	}
+
+	var foreignKeys int
+	if err := destDB.QueryRow(`SELECT COUNT(*) FROM information_schema.REFERENTIAL_CONSTRAINTS
+		WHERE CONSTRAINT_SCHEMA = 'shop_copy'`).Scan(&foreignKeys); err != nil {
+		t.Fatalf("failed to count the destination foreign keys: %v", err)
+	}
+	if foreignKeys != 2 {
+		t.Errorf("destination has %d foreign keys, want 2", foreignKeys)
+	}
+
+	var totalCents int
+	if err := destDB.QueryRow("SELECT total_cents FROM orders WHERE id = 2").Scan(&totalCents); err != nil {
+		t.Fatalf("failed to read the generated column: %v", err)
+	}
+	if totalCents != 1900 {
+		t.Errorf("generated total_cents = %d, want 1900", totalCents)
+	}
+
+	rows, err := destDB.Query("SELECT id, payload FROM order_items ORDER BY id")
+	if err != nil {
+		t.Fatalf("failed to read the copied payloads: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var payload []byte
+		if err := rows.Scan(&id, &payload); err != nil {
+			t.Fatalf("failed to scan a copied payload: %v", err)
+		}
+		if want := payloads[id%len(payloads)]; !bytes.Equal(payload, want) {
+			t.Errorf("order_items %d payload = %x, want %x", id, payload, want)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("failed to read the copied payloads: %v", err)
+	}
+}
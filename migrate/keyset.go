@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// pagingKey returns the columns a table is paged by, its OrderBy or else its primary key, and
+// their positions in the selected columns. It returns nil when the table has no key fully
+// selected, it is then read in a single pass.
+func (dm *DatabaseMigrator) pagingKey(tableName string, columns []string) ([]string, []int, error) {
+	pkColumns, ordered := dm.config.OrderBy[tableName]
+	if !ordered {
+		var err error
+		pkColumns, err = dm.GetPrimaryKeyColumns(tableName)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(pkColumns) == 0 {
+		return nil, nil, nil
+	}
+
+	indexes := make([]int, len(pkColumns))
+	for i, pk := range pkColumns {
+		indexes[i] = -1
+		for j, col := range columns {
+			if col == pk {
+				indexes[i] = j
+			}
+		}
+		if indexes[i] < 0 && ordered {
+			return nil, nil, fmt.Errorf("orderBy of table %s: column %s is not copied", tableName, pk)
+		}
+		if indexes[i] < 0 {
+			return nil, nil, nil
+		}
+	}
+	return pkColumns, indexes, nil
+}
+
+// pageQuery builds the SELECT for the next batch of a table, which starts after lastKey in key
+// order, so every batch is an index range scan instead of re-reading the skipped rows as
+// OFFSET does
+func (dm *DatabaseMigrator) pageQuery(tableName string, columns, pkColumns []string, condition string,
+	args []interface{}, lastKey []interface{}, limit int) (string, []interface{}) {
+	query := fmt.Sprintf("SELECT %s FROM %s", quoteList(dm.source, columns), dm.source.quote(tableName))
+	queryArgs := append([]interface{}(nil), args...)
+
+	var conditions []string
+	if condition != "" {
+		conditions = append(conditions, "("+condition+")")
+	}
+	if lastKey != nil {
+		params := make([]string, len(pkColumns))
+		for i := range params {
+			params[i] = dm.source.placeholder(len(queryArgs) + i + 1)
+		}
+		if len(pkColumns) == 1 {
+			conditions = append(conditions, fmt.Sprintf("%s > %s", dm.source.quote(pkColumns[0]), params[0]))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("(%s) > (%s)", quoteList(dm.source, pkColumns), strings.Join(params, ", ")))
+		}
+		queryArgs = append(queryArgs, lastKey...)
+	}
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	return query + fmt.Sprintf(" ORDER BY %s LIMIT %d", quoteList(dm.source, pkColumns), limit), queryArgs
+}
+
+// scanQuery builds the SELECT reading a table without a key in one pass, of at most limit rows
+// unless it is 0
+func (dm *DatabaseMigrator) scanQuery(tableName string, columns []string, condition string, limit int) string {
+	query := fmt.Sprintf("SELECT %s FROM %s", quoteList(dm.source, columns), dm.source.quote(tableName))
+	if condition != "" {
+		query += " WHERE " + condition
+	}
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	return query
+}
+
+// randomSampleQuery builds the SELECT of limit random rows of a table, for SampleStrategy random
+func (dm *DatabaseMigrator) randomSampleQuery(tableName string, columns []string, condition string,
+	args []interface{}, limit int) (string, []interface{}) {
+	query := fmt.Sprintf("SELECT %s FROM %s", quoteList(dm.source, columns), dm.source.quote(tableName))
+	if condition != "" {
+		query += " WHERE " + condition
+	}
+	return query + fmt.Sprintf(" ORDER BY %s LIMIT %d", dm.source.randomOrder(), limit), args
+}
+
+// sampleStrategy returns the SampleStrategy in effect, head unless set
+func (dm *DatabaseMigrator) sampleStrategy() string {
+	if dm.config.SampleStrategy == "" {
+		return sampleHead
+	}
+	return dm.config.SampleStrategy
+}
+
+// rowKey copies the primary key values out of a scanned row
+func rowKey(values []interface{}, keyIndexes []int) []interface{} {
+	key := make([]interface{}, len(keyIndexes))
+	for i, idx := range keyIndexes {
+		key[i] = values[idx]
+	}
+	return key
+}
+
+// keyText converts a key to the text form stored in the checkpoint, which both
+// MySQL and Postgres accept back as a bind parameter for the key columns
+func keyText(key []interface{}) []string {
+	if key == nil {
+		return nil
+	}
+	text := make([]string, len(key))
+	for i, val := range key {
+		text[i] = profileText(val)
+	}
+	return text
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// MigrationManifest records the destination state right after a migration so it can be re-verified later
+type MigrationManifest struct {
+	CreatedAt           time.Time       `json:"createdAt"`
+	SourceDatabase      string          `json:"sourceDatabase"`
+	DestinationDatabase string          `json:"destinationDatabase"`
+	Tables              []ManifestTable `json:"tables"`
+}
+
+// ManifestTable is the expected state of one destination table
+type ManifestTable struct {
+	Name     string `json:"name"`
+	Rows     int    `json:"rows"`
+	Checksum int64  `json:"checksum"`
+}
+
+// destinationTableState reads the current row count and CHECKSUM TABLE value of a destination table
+func (dm *DatabaseMigrator) destinationTableState(tableName string) (ManifestTable, error) {
+	state := ManifestTable{Name: tableName}
+	ctx, cancel := dm.queryContext()
+	defer cancel()
+
+	query := fmt.Sprintf("SELECT COUNT(*) FROM `%s`", tableName)
+	if err := dm.destDB.QueryRowContext(ctx, query).Scan(&state.Rows); err != nil {
+		return state, fmt.Errorf("failed to get destination row count for table %s: %v", tableName, err)
+	}
+
+	var table string
+	var checksum sql.NullInt64
+	query = fmt.Sprintf("CHECKSUM TABLE `%s`", tableName)
+	if err := dm.destDB.QueryRowContext(ctx, query).Scan(&table, &checksum); err != nil {
+		return state, fmt.Errorf("failed to checksum destination table %s: %v", tableName, err)
+	}
+	state.Checksum = checksum.Int64
+
+	return state, nil
+}
+
+// WriteManifest records the row count and checksum of every migrated table in the destination
+func (dm *DatabaseMigrator) WriteManifest(path string, tables []string) error {
+	manifest := MigrationManifest{
+		CreatedAt:           time.Now(),
+		SourceDatabase:      dm.config.Source.Database,
+		DestinationDatabase: dm.config.Destination.Database,
+	}
+
+	for _, tableName := range tables {
+		state, err := dm.destinationTableState(tableName)
+		if err != nil {
+			return err
+		}
+		manifest.Tables = append(manifest.Tables, state)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+
+	dm.logger.Log(fmt.Sprintf("Wrote manifest for %d tables to %s", len(manifest.Tables), path))
+	return nil
+}
+
+// VerifyManifest re-checks the destination against a manifest written by a previous migration,
+// without migrating anything, and returns an error naming every table that diverged
+func (dm *DatabaseMigrator) VerifyManifest(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %v", err)
+	}
+
+	var manifest MigrationManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("failed to decode manifest: %v", err)
+	}
+
+	dm.logger.Log(fmt.Sprintf("Verifying %d tables against manifest from %s",
+		len(manifest.Tables), manifest.CreatedAt.Format("2006-01-02 15:04:05")))
+
+	var diverged []string
+	for _, expected := range manifest.Tables {
+		current, err := dm.destinationTableState(expected.Name)
+		if err != nil {
+			dm.logger.Log(fmt.Sprintf("Table %s: %v", expected.Name, err))
+			diverged = append(diverged, expected.Name)
+			continue
+		}
+
+		if current.Rows != expected.Rows || current.Checksum != expected.Checksum {
+			dm.logger.Log(fmt.Sprintf("Table %s diverged: rows %d -> %d, checksum %d -> %d",
+				expected.Name, expected.Rows, current.Rows, expected.Checksum, current.Checksum))
+			diverged = append(diverged, expected.Name)
+			continue
+		}
+
+		dm.logger.Log(fmt.Sprintf("Table %s matches manifest (%d rows)", expected.Name, current.Rows))
+	}
+
+	if len(diverged) > 0 {
+		return fmt.Errorf("%d tables diverge from manifest: %s", len(diverged), strings.Join(diverged, ", "))
+	}
+
+	dm.logger.Log("Destination matches manifest")
+	return nil
+}
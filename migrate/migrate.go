@@ -1,60 +1,195 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/duymanh3602/migrate-tool/internal/env"
+	applog "github.com/duymanh3602/migrate-tool/internal/log"
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	// defaultMaxReprepareAttempts caps how often a table's insert statement is re-prepared after a dropped connection
+	defaultMaxReprepareAttempts = 3
+
+	// defaultCheckpointFile is used when Resume is set without a CheckpointFile
+	defaultCheckpointFile = "migration.checkpoint.json"
 )
 
 // DatabaseConfig holds connection configuration
 type DatabaseConfig struct {
-	Host     string
-	Port     string
-	Username string
-	Password string
-	Database string
+	// Driver is "mysql" (the default), "postgres" or, for the destination only, "sqlite",
+	// in which case Database is the path of the SQLite file
+	Driver   string `yaml:"driver"`
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Database string `yaml:"database"`
+
+	// MaxOpenConns and MaxIdleConns size the connection pool, by default two connections per table
+	// copied or indexed in parallel plus two, all kept idle. ConnMaxLifetime closes connections
+	// older than it, 0 keeps them. A SQLite destination always uses a single connection.
+	MaxOpenConns    int           `yaml:"maxOpenConns"`
+	MaxIdleConns    int           `yaml:"maxIdleConns"`
+	ConnMaxLifetime time.Duration `yaml:"connMaxLifetime"`
+
+	// TLS, when set, connects to a MySQL or Postgres server over TLS
+	TLS *TLSConfig `yaml:"tls"`
+
+	// withoutForeignKeyChecks opens every MySQL connection of the pool with foreign_key_checks=0,
+	// which is a session variable, so that parallel workers all copy without the checks
+	withoutForeignKeyChecks bool
 }
 
 // MigrationConfig holds migration settings
 type MigrationConfig struct {
-	Source      DatabaseConfig
-	Destination DatabaseConfig
-	BatchSize   int
-	SkipTables  []string
-	LogFile     string
-}
-
-// Logger handles logging to file and console
-type Logger struct {
-	file *os.File
-}
-
-func NewLogger(filename string) (*Logger, error) {
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		return nil, err
-	}
-	return &Logger{file: file}, nil
-}
-
-func (l *Logger) Log(message string) {
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	logMsg := fmt.Sprintf("[%s] %s\n", timestamp, message)
-	fmt.Print(logMsg)
-	if l.file != nil {
-		l.file.WriteString(logMsg)
-	}
-}
-
-func (l *Logger) Close() {
-	if l.file != nil {
-		l.file.Close()
-	}
+	Source      DatabaseConfig `yaml:"source"`
+	Destination DatabaseConfig `yaml:"destination"`
+	BatchSize   int            `yaml:"batchSize"`
+	SkipTables  []string       `yaml:"skipTables"`
+	OnlyTables  []string       `yaml:"onlyTables"`
+	LogFile     string         `yaml:"logFile"`
+	// LogLevel is the lowest level written out of debug, info (default), warn and error,
+	// and LogFormat either text (default) or json for one JSON object per line
+	LogLevel  string `yaml:"logLevel"`
+	LogFormat string `yaml:"logFormat"`
+
+	// RowFilters maps a table name to a SQL condition selecting the rows to copy, e.g. "TenantId = 5".
+	// Tables not listed are copied in full.
+	RowFilters map[string]string `yaml:"rowFilters"`
+
+	// OrderBy maps a table name to the columns its rows are paged by instead of its primary key,
+	// e.g. those of a unique index of a table without one. Together they must be unique, or rows
+	// sharing a value across a batch boundary are skipped.
+	OrderBy map[string][]string `yaml:"orderBy"`
+
+	// ColumnMappings maps a table name to the columns renamed or dropped in the destination.
+	// The schema of a mapped table is generated from its columns, as between different drivers.
+	ColumnMappings map[string]ColumnMapping `yaml:"columnMappings"`
+
+	// SampleRates maps a table name to the fraction (0-1) of its rows to copy.
+	// Tables not listed are copied in full.
+	SampleRates map[string]float64 `yaml:"sampleRates"`
+	// SampleSeed selects a different but reproducible sample of rows
+	SampleSeed int64 `yaml:"sampleSeed"`
+	// SampleFollowReferences also copies rows that reference already-sampled parent rows.
+	// SampleMaxReferencedKeys caps the distinct parent keys kept per referenced column, and so
+	// the memory they use and the bind parameters of the child queries, defaultSampleMaxReferencedKeys
+	// when 0. Rows referencing the parent rows sampled past it are not followed.
+	SampleFollowReferences  bool `yaml:"sampleFollowReferences"`
+	SampleMaxReferencedKeys int  `yaml:"sampleMaxReferencedKeys"`
+
+	// MaxRowsPerTable caps the rows copied from each table, 0 copies them all. SampleStrategy picks
+	// which ones: the first rows in key order (head, the default) or a random selection (random),
+	// which is read in a single query and so holds the whole sample in memory.
+	MaxRowsPerTable int    `yaml:"maxRowsPerTable"`
+	SampleStrategy  string `yaml:"sampleStrategy"`
+
+	// PhaseBudgets limits the duration of the schema, data, indexes, constraints and verification phases.
+	// MaintenanceWindow, when set, bounds the whole migration: a phase whose budget
+	// no longer fits in the remaining window is skipped or aborts per its budget.
+	PhaseBudgets      map[string]PhaseBudget `yaml:"phaseBudgets"`
+	MaintenanceWindow time.Duration          `yaml:"maintenanceWindow"`
+
+	// DeferIndexes creates tables without their secondary indexes and foreign keys and
+	// builds them after the data is loaded, IndexConcurrency tables at a time (1 = serial)
+	DeferIndexes     bool `yaml:"deferIndexes"`
+	IndexConcurrency int  `yaml:"indexConcurrency"`
+
+	// MaxReprepareAttempts caps how many times MigrateTableData re-prepares its insert
+	// statement and retries the current batch after the destination connection drops (default 3)
+	MaxReprepareAttempts int `yaml:"maxReprepareAttempts"`
+
+	// ProfileSampleSize is the number of rows ProfileTables samples per table (default 1000)
+	ProfileSampleSize int `yaml:"profileSampleSize"`
+
+	// ManifestFile, when set, receives the destination row counts and checksums after Migrate
+	ManifestFile string `yaml:"manifestFile"`
+
+	// AllowCircularDependencies orders tables whose foreign keys form a cycle anyway, logging a
+	// warning, instead of failing. Their rows are copied while foreign key checks are disabled.
+	AllowCircularDependencies bool `yaml:"allowCircularDependencies"`
+
+	// SkipExistingTables keeps destination tables that already exist instead of failing to create them
+	SkipExistingTables bool `yaml:"skipExistingTables"`
+
+	// TruncateBeforeInsert empties each destination table before copying its rows, so a re-run
+	// gives a clean copy. This is destructive: any data already in those tables is lost.
+	TruncateBeforeInsert bool `yaml:"truncateBeforeInsert"`
+
+	// SkipExistingRows loads the destination primary keys first and skips source rows
+	// that already exist. Tables without a single integer primary key are upserted instead.
+	SkipExistingRows bool `yaml:"skipExistingRows"`
+
+	// Concurrency is the number of tables whose data is copied at the same time (default 1).
+	// A table only starts once every table it references has been copied.
+	Concurrency int `yaml:"concurrency"`
+	// TableWorkers splits the copy of a table with a single integer primary key into that many
+	// key ranges between MIN and MAX, copied in parallel (default 1). Helps when one table
+	// dwarfs the others. Such a table is not checkpointed within, a resume copies it again.
+	TableWorkers int `yaml:"tableWorkers"`
+
+	// Resume continues a failed migration from CheckpointFile, skipping completed tables and
+	// continuing partial ones from their saved offset. Progress is checkpointed whenever
+	// Resume or CheckpointFile is set: after every table, and every CheckpointEvery batches.
+	Resume          bool   `yaml:"resume"`
+	CheckpointFile  string `yaml:"checkpointFile"`
+	CheckpointEvery int    `yaml:"checkpointEvery"`
+
+	// ContinueOnError keeps migrating when the destination rejects a row. The rows of a
+	// rejected batch are retried one at a time and those still failing are written to
+	// FailedRowsFile (default failed_rows.jsonl); Migrate then returns a *FailedRowsError.
+	ContinueOnError bool   `yaml:"continueOnError"`
+	FailedRowsFile  string `yaml:"failedRowsFile"`
+
+	// SchemaOnly only creates the tables, with their indexes and foreign keys, without copying rows.
+	// DataOnly only copies rows into tables that already exist in the destination.
+	SchemaOnly bool `yaml:"schemaOnly"`
+	DataOnly   bool `yaml:"dataOnly"`
+
+	// TargetMySQLVersion, e.g. 5.7, rewrites the SHOW CREATE TABLE statements of the source for an
+	// older MySQL destination, replacing or dropping the syntax that version rejects
+	TargetMySQLVersion string `yaml:"targetMySQLVersion"`
+
+	// CheckSchema compares the columns of the destination tables rows are copied into, under
+	// DataOnly or SkipExistingTables, with the source before copying any, and aborts the
+	// migration when their differences would make the inserts fail
+	CheckSchema bool `yaml:"checkSchema"`
+
+	// CSVNull is written by ExportTableCSV for NULL values, which is an empty field when unset
+	CSVNull string `yaml:"csvNull"`
+
+	// DryRun makes Migrate only log the tables it would create, in dependency order, and their row counts
+	DryRun bool `yaml:"dryRun"`
+
+	// Verify runs VerifyMigration at the end of Migrate; VerifyChecksum also compares
+	// CHECKSUM TABLE between MySQL databases, or a hash of the primary keys otherwise
+	Verify         bool `yaml:"verify"`
+	VerifyChecksum bool `yaml:"verifyChecksum"`
+
+	// Progress replaces the per-batch log lines with a progress bar when stdout is a terminal
+	Progress bool `yaml:"progress"`
+
+	// ConnectMaxAttempts (default 5) and ConnectRetryDelay (default 1s, doubling per attempt)
+	// control how long NewDatabaseMigrator waits for a database that is not reachable yet
+	ConnectMaxAttempts int           `yaml:"connectMaxAttempts"`
+	ConnectRetryDelay  time.Duration `yaml:"connectRetryDelay"`
+	// QueryTimeout bounds every SQL statement, a data batch counting as one; 0 means no limit
+	QueryTimeout time.Duration `yaml:"queryTimeout"`
 }
 
 // ForeignKeyInfo represents a foreign key constraint
@@ -65,58 +200,177 @@ type ForeignKeyInfo struct {
 	ReferencedColumn string
 }
 
+// ColumnInfo describes a table column as reported by SHOW COLUMNS
+type ColumnInfo struct {
+	Name     string
+	Type     string
+	Nullable bool
+	Key      string
+	Default  sql.NullString
+	Extra    string
+}
+
 // DatabaseMigrator handles the migration process
 type DatabaseMigrator struct {
-	sourceDB *sql.DB
-	destDB   *sql.DB
+	sourceDB Querier
+	destDB   Querier
 	config   MigrationConfig
-	logger   *Logger
+	logger   *applog.Logger
+
+	// SQL dialects of the source and destination drivers
+	source dialect
+	dest   dialect
+
+	// destChecksOff is set when every connection of destDB has foreign key checks disabled
+	destChecksOff bool
+
+	// definitions stripped from CREATE TABLE by DeferIndexes, per table
+	deferred map[string]deferredDefinitions
+
+	// rows rejected under ContinueOnError, nil when it is off
+	failedRows *failedRowLog
+
+	// ctx is the base context of every SQL operation, cancelling it stops the migration
+	ctx context.Context
+
+	// tables copied so far, for the MigrationReport
+	report *reportCollector
+
+	// transformers rewrite values before insert, see RegisterTransformer
+	transformers map[string]ValueTransformer
+
+	// progress of a resumable migration, nil when checkpointing is off
+	checkpoint *checkpoint
+
+	// cached result of destinationSupportsSRID
+	sridMu           sync.Mutex
+	destSupportsSRID *bool
+
+	// sampling state, see prepareSampling. sampleMu guards sampledKeys, which the parallel key
+	// ranges of a table record into.
+	sampleRefs  map[string][]ForeignKeyInfo
+	sampleMu    sync.Mutex
+	sampledKeys map[string]map[string]*sampledKeySet
 }
 
-func NewDatabaseMigrator(config MigrationConfig) (*DatabaseMigrator, error) {
-	logger, err := NewLogger(config.LogFile)
+// NewDatabaseMigrator connects to the source and destination databases of config
+func NewDatabaseMigrator(ctx context.Context, config MigrationConfig) (*DatabaseMigrator, error) {
+	migrator, err := newMigrator(ctx, config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create logger: %v", err)
-	}
-
-	migrator := &DatabaseMigrator{
-		config: config,
-		logger: logger,
+		return nil, err
 	}
 
 	// Connect to source database
-	sourceDSN := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true",
-		config.Source.Username, config.Source.Password,
-		config.Source.Host, config.Source.Port, config.Source.Database)
-
-	migrator.sourceDB, err = sql.Open("mysql", sourceDSN)
+	sourceDB, err := migrator.source.open(config.Source)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to source database: %v", err)
 	}
+	migrator.configurePool(migrator.source, sourceDB, config.Source)
+	migrator.sourceDB = sourceDB
+
+	// Connect to destination database. Rows are only ever written with foreign key checks
+	// disabled, and being a session variable they are disabled on every pooled connection.
+	destConfig := config.Destination
+	destConfig.withoutForeignKeyChecks = true
+	destDB, err := migrator.dest.open(destConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to destination database: %v", err)
+	}
+	migrator.configurePool(migrator.dest, destDB, config.Destination)
+	migrator.destDB = destDB
+	migrator.destChecksOff = true
+
+	// Test connections, waiting for databases that are still starting up
+	if err := migrator.connectWithRetry("source", migrator.sourceDB); err != nil {
+		return nil, err
+	}
+
+	if err := migrator.connectWithRetry("destination", migrator.destDB); err != nil {
+		return nil, err
+	}
+
+	migrator.logger.Log("Successfully connected to both databases")
+	return migrator, nil
+}
+
+// configurePool applies the pool settings of cfg to db, defaulting them from the concurrency
+func (dm *DatabaseMigrator) configurePool(d dialect, db *sql.DB, cfg DatabaseConfig) {
+	// SQLite serializes writers, its dialect already limits the pool to one connection
+	if d.name() == driverSQLite {
+		return
+	}
+
+	maxOpen := cfg.MaxOpenConns
+	if maxOpen == 0 {
+		workers := max(max(dm.config.Concurrency, 1)*max(dm.config.TableWorkers, 1), dm.config.IndexConcurrency)
+		maxOpen = 2*workers + 2
+	}
+	maxIdle := cfg.MaxIdleConns
+	if maxIdle == 0 {
+		maxIdle = maxOpen
+	}
 
-	// Connect to destination database
-	destDSN := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true",
-		config.Destination.Username, config.Destination.Password,
-		config.Destination.Host, config.Destination.Port, config.Destination.Database)
+	db.SetMaxOpenConns(maxOpen)
+	db.SetMaxIdleConns(maxIdle)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	dm.logger.Debug(fmt.Sprintf("Connection pool for %s: %d open, %d idle connections", cfg.Database, maxOpen, maxIdle))
+}
 
-	migrator.destDB, err = sql.Open("mysql", destDSN)
+// NewDatabaseMigratorWithDB returns a migrator using the given connections instead of opening
+// the ones in config, whose drivers still select the SQL dialects. Foreign key checks are then
+// disabled with a statement, which only applies to one connection of a destination *sql.DB pool.
+func NewDatabaseMigratorWithDB(ctx context.Context, config MigrationConfig, sourceDB, destDB Querier) (*DatabaseMigrator, error) {
+	migrator, err := newMigrator(ctx, config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to destination database: %v", err)
+		return nil, err
 	}
+	migrator.sourceDB = sourceDB
+	migrator.destDB = destDB
+	return migrator, nil
+}
 
-	// Test connections
-	if err := migrator.sourceDB.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping source database: %v", err)
+// newMigrator sets up a migrator without its database connections
+func newMigrator(ctx context.Context, config MigrationConfig) (*DatabaseMigrator, error) {
+	logger, err := applog.New(config.LogFile, config.LogLevel, config.LogFormat)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create logger: %v", err)
 	}
 
-	if err := migrator.destDB.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping destination database: %v", err)
+	migrator := &DatabaseMigrator{
+		config:       config,
+		logger:       logger,
+		deferred:     make(map[string]deferredDefinitions),
+		ctx:          ctx,
+		transformers: defaultTransformers(),
+		report:       &reportCollector{},
+	}
+	if config.ContinueOnError {
+		migrator.failedRows = newFailedRowLog(config.FailedRowsFile)
 	}
 
-	logger.Log("Successfully connected to both databases")
+	migrator.source, err = dialectFor(config.Source.Driver)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source driver: %v", err)
+	}
+	migrator.dest, err = dialectFor(config.Destination.Driver)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination driver: %v", err)
+	}
 	return migrator, nil
 }
 
+// queryContext returns the context of one SQL operation, limited to QueryTimeout when it is set
+func (dm *DatabaseMigrator) queryContext() (context.Context, context.CancelFunc) {
+	return dm.withQueryTimeout(dm.ctx)
+}
+
+func (dm *DatabaseMigrator) withQueryTimeout(parent context.Context) (context.Context, context.CancelFunc) {
+	if dm.config.QueryTimeout > 0 {
+		return context.WithTimeout(parent, dm.config.QueryTimeout)
+	}
+	return context.WithCancel(parent)
+}
+
 func (dm *DatabaseMigrator) Close() {
 	if dm.sourceDB != nil {
 		dm.sourceDB.Close()
@@ -124,6 +378,9 @@ func (dm *DatabaseMigrator) Close() {
 	if dm.destDB != nil {
 		dm.destDB.Close()
 	}
+	if dm.failedRows != nil {
+		dm.failedRows.Close()
+	}
 	if dm.logger != nil {
 		dm.logger.Close()
 	}
@@ -131,20 +388,30 @@ func (dm *DatabaseMigrator) Close() {
 
 // GetTables retrieves all table names from source database
 func (dm *DatabaseMigrator) GetTables() ([]string, error) {
-	query := "SHOW TABLES"
-	rows, err := dm.sourceDB.Query(query)
+	tables, _, err := dm.selectTables()
+	return tables, err
+}
+
+// selectTables returns the source tables to migrate and how many SkipTables and OnlyTables leave out
+func (dm *DatabaseMigrator) selectTables() ([]string, int, error) {
+	ctx, cancel := dm.queryContext()
+	defer cancel()
+
+	allTables, err := dm.source.listTables(ctx, dm.sourceDB)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get tables: %v", err)
+		return nil, 0, err
 	}
-	defer rows.Close()
+	sourceTables := len(allTables)
 
-	var tables []string
-	for rows.Next() {
-		var tableName string
-		if err := rows.Scan(&tableName); err != nil {
-			return nil, fmt.Errorf("failed to scan table name: %v", err)
+	if len(dm.config.OnlyTables) > 0 {
+		allTables, err = onlyTables(allTables, dm.config.OnlyTables)
+		if err != nil {
+			return nil, 0, err
 		}
+	}
 
+	var tables []string
+	for _, tableName := range allTables {
 		// Skip tables if they're in the skip list
 		skip := false
 		for _, skipTable := range dm.config.SkipTables {
@@ -159,15 +426,99 @@ func (dm *DatabaseMigrator) GetTables() ([]string, error) {
 		}
 	}
 
-	return tables, nil
+	return tables, sourceTables - len(tables), nil
+}
+
+// onlyTables keeps the tables listed in only, in source order, failing if one is not in the source
+func onlyTables(tables, only []string) ([]string, error) {
+	listed := make(map[string]bool, len(only))
+	for _, t := range only {
+		listed[t] = true
+	}
+
+	var selected []string
+	for _, t := range tables {
+		if listed[t] {
+			selected = append(selected, t)
+			delete(listed, t)
+		}
+	}
+	for _, t := range only {
+		if listed[t] {
+			return nil, fmt.Errorf("table %s in onlyTables does not exist in the source database", t)
+		}
+	}
+	return selected, nil
 }
 
-// GetTableSchema retrieves the CREATE TABLE statement for a table
+// warnMissingDependencies warns about the tables referenced through foreign keys that are neither
+// being migrated nor in the destination, since inserting the referencing rows may then fail
+func (dm *DatabaseMigrator) warnMissingDependencies(tables []string) error {
+	migrating := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		migrating[t] = true
+	}
+
+	checked := make(map[string]bool)
+	for _, tableName := range tables {
+		fks, err := dm.GetTableForeignKeys(tableName)
+		if err != nil {
+			return err
+		}
+		for _, fk := range fks {
+			referenced := fk.ReferencedTable
+			if migrating[referenced] || checked[referenced] {
+				continue
+			}
+			checked[referenced] = true
+
+			exists, err := dm.destTableExists(referenced)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				dm.logger.Warn(fmt.Sprintf("Table %s references %s, which is not being migrated and does not exist in the destination",
+					tableName, referenced))
+			}
+		}
+	}
+	return nil
+}
+
+// GetTableSchema retrieves the CREATE TABLE statement for a table. Between two MySQL
+// databases this is SHOW CREATE TABLE, otherwise, or when ColumnMappings has the table,
+// it is generated for the destination driver.
 func (dm *DatabaseMigrator) GetTableSchema(tableName string) (string, error) {
+	if dm.generatesSchema(tableName) {
+		columns, err := dm.GetTableColumnInfo(tableName)
+		if err != nil {
+			return "", err
+		}
+		columns = dm.destColumnInfos(tableName, dm.mappedColumns(tableName, columns))
+		sourceKey, err := dm.GetPrimaryKeyColumns(tableName)
+		if err != nil {
+			return "", err
+		}
+		var primaryKey []string
+		for _, col := range sourceKey {
+			if !dm.isExcludedColumn(tableName, col) {
+				primaryKey = append(primaryKey, dm.destColumnName(tableName, col))
+			}
+		}
+		createStmt, err := buildCreateTable(dm.source, dm.dest, tableName, columns, primaryKey)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate schema for table %s: %v", tableName, err)
+		}
+		return createStmt, nil
+	}
+
 	query := fmt.Sprintf("SHOW CREATE TABLE `%s`", tableName)
 	var table, createStmt string
 
-	err := dm.sourceDB.QueryRow(query).Scan(&table, &createStmt)
+	ctx, cancel := dm.queryContext()
+	defer cancel()
+
+	err := dm.sourceDB.QueryRowContext(ctx, query).Scan(&table, &createStmt)
 	if err != nil {
 		return "", fmt.Errorf("failed to get schema for table %s: %v", tableName, err)
 	}
@@ -175,187 +526,243 @@ func (dm *DatabaseMigrator) GetTableSchema(tableName string) (string, error) {
 	return createStmt, nil
 }
 
+// generatesSchema reports whether the CREATE TABLE of a table is generated from its columns
+// instead of copied with SHOW CREATE TABLE
+func (dm *DatabaseMigrator) generatesSchema(tableName string) bool {
+	return dm.source.name() != driverMySQL || dm.dest.name() != driverMySQL || dm.hasColumnMapping(tableName)
+}
+
 // CreateTable creates a table in the destination database
 func (dm *DatabaseMigrator) CreateTable(createStmt string) error {
-	_, err := dm.destDB.Exec(createStmt)
+	ctx, cancel := dm.queryContext()
+	defer cancel()
+
+	_, err := dm.destDB.ExecContext(ctx, createStmt)
 	if err != nil {
 		return fmt.Errorf("failed to create table: %v", err)
 	}
 	return nil
 }
 
-// GetTableRowCount gets the total number of rows in a table
+// GetTableRowCount gets the number of rows of a table to migrate, which is all of them unless RowFilters
+// has the table, at most MaxRowsPerTable
 func (dm *DatabaseMigrator) GetTableRowCount(tableName string) (int, error) {
-	query := fmt.Sprintf("SELECT COUNT(*) FROM `%s`", tableName)
+	count, err := dm.sourceRowCount(tableName)
+	if err != nil {
+		return 0, err
+	}
+	return dm.capRows(count), nil
+}
+
+// capRows limits a row count to MaxRowsPerTable
+func (dm *DatabaseMigrator) capRows(count int) int {
+	if dm.config.MaxRowsPerTable > 0 {
+		return min(count, dm.config.MaxRowsPerTable)
+	}
+	return count
+}
+
+// sourceRowCount counts the rows of a table matching its RowFilters condition
+func (dm *DatabaseMigrator) sourceRowCount(tableName string) (int, error) {
+	filter, err := dm.rowFilter(tableName)
+	if err != nil {
+		return 0, err
+	}
+
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s", dm.source.quote(tableName))
+	if filter != "" {
+		query += " WHERE " + filter
+	}
 	var count int
-	err := dm.sourceDB.QueryRow(query).Scan(&count)
+	ctx, cancel := dm.queryContext()
+	defer cancel()
+
+	err = dm.sourceDB.QueryRowContext(ctx, query).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get row count for table %s: %v", tableName, err)
 	}
 	return count, nil
 }
 
+// countRows counts the rows of a table matching a WHERE clause
+func (dm *DatabaseMigrator) countRows(tableName, whereClause string, args []interface{}) (int, error) {
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s%s", dm.source.quote(tableName), whereClause)
+	var count int
+	ctx, cancel := dm.queryContext()
+	defer cancel()
+
+	err := dm.sourceDB.QueryRowContext(ctx, query, args...).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count rows for table %s: %v", tableName, err)
+	}
+	return count, nil
+}
+
 // GetTableColumns retrieves column names for a table
 func (dm *DatabaseMigrator) GetTableColumns(tableName string) ([]string, error) {
-	query := fmt.Sprintf("SHOW COLUMNS FROM `%s`", tableName)
-	rows, err := dm.sourceDB.Query(query)
+	infos, err := dm.GetTableColumnInfo(tableName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get columns for table %s: %v", tableName, err)
+		return nil, err
 	}
-	defer rows.Close()
 
 	var columns []string
-	for rows.Next() {
-		var field, typ, null, key, defaultVal, extra sql.NullString
-		if err := rows.Scan(&field, &typ, &null, &key, &defaultVal, &extra); err != nil {
-			return nil, fmt.Errorf("failed to scan column info: %v", err)
-		}
-		columns = append(columns, field.String)
+	for _, info := range infos {
+		columns = append(columns, info.Name)
 	}
 
 	return columns, nil
 }
 
-// GetTableForeignKeys retrieves foreign key information for a table
-func (dm *DatabaseMigrator) GetTableForeignKeys(tableName string) ([]ForeignKeyInfo, error) {
-	query := `
-		SELECT 
-			COLUMN_NAME,
-			REFERENCED_TABLE_NAME,
-			REFERENCED_COLUMN_NAME
-		FROM INFORMATION_SCHEMA.KEY_COLUMN_USAGE 
-		WHERE TABLE_SCHEMA = ? 
-		AND TABLE_NAME = ? 
-		AND REFERENCED_TABLE_NAME IS NOT NULL`
-
-	rows, err := dm.sourceDB.Query(query, dm.config.Source.Database, tableName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get foreign keys for table %s: %v", tableName, err)
-	}
-	defer rows.Close()
-
-	var foreignKeys []ForeignKeyInfo
-	for rows.Next() {
-		var columnName, referencedTable, referencedColumn sql.NullString
-		if err := rows.Scan(&columnName, &referencedTable, &referencedColumn); err != nil {
-			return nil, fmt.Errorf("failed to scan foreign key info: %v", err)
-		}
-
-		if referencedTable.Valid && referencedColumn.Valid {
-			foreignKeys = append(foreignKeys, ForeignKeyInfo{
-				TableName:        tableName,
-				ColumnName:       columnName.String,
-				ReferencedTable:  referencedTable.String,
-				ReferencedColumn: referencedColumn.String,
-			})
+// destTableExists reports whether the destination already has a table
+func (dm *DatabaseMigrator) destTableExists(tableName string) (bool, error) {
+	ctx, cancel := dm.queryContext()
+	defer cancel()
+
+	tables, err := dm.dest.listTables(ctx, dm.destDB)
+	if err != nil {
+		return false, fmt.Errorf("failed to list destination tables: %v", err)
+	}
+	for _, t := range tables {
+		if strings.EqualFold(t, tableName) {
+			return true, nil
 		}
 	}
-
-	return foreignKeys, nil
+	return false, nil
 }
 
-// SortTablesByDependencies sorts tables so that tables without dependencies come first
-func (dm *DatabaseMigrator) SortTablesByDependencies(tables []string) ([]string, error) {
-	// Build dependency map
-	dependencies := make(map[string][]string)
-	allForeignKeys := make(map[string][]ForeignKeyInfo)
+// GetTableColumnInfo retrieves the definition of every column in a source table
+func (dm *DatabaseMigrator) GetTableColumnInfo(tableName string) ([]ColumnInfo, error) {
+	ctx, cancel := dm.queryContext()
+	defer cancel()
 
-	// Get foreign keys for all tables
-	for _, tableName := range tables {
-		fks, err := dm.GetTableForeignKeys(tableName)
-		if err != nil {
-			return nil, err
-		}
-		allForeignKeys[tableName] = fks
+	return dm.source.columnInfo(ctx, dm.sourceDB, tableName)
+}
 
-		var deps []string
-		for _, fk := range fks {
-			// Skip self-referencing foreign keys (they don't prevent table creation)
-			if fk.ReferencedTable == tableName {
-				continue
-			}
+// GetTableForeignKeys retrieves foreign key information for a table
+func (dm *DatabaseMigrator) GetTableForeignKeys(tableName string) ([]ForeignKeyInfo, error) {
+	ctx, cancel := dm.queryContext()
+	defer cancel()
 
-			// Only include dependencies that are in our table list
-			for _, t := range tables {
-				if t == fk.ReferencedTable {
-					deps = append(deps, fk.ReferencedTable)
-					break
-				}
-			}
-		}
-		dependencies[tableName] = deps
-	}
+	return dm.source.foreignKeys(ctx, dm.sourceDB, dm.config.Source.Database, tableName)
+}
 
-	// Topological sort
-	var result []string
-	visited := make(map[string]bool)
-	temp := make(map[string]bool)
+// SortTablesByDependencies sorts tables so that tables without dependencies come first
+func (dm *DatabaseMigrator) SortTablesByDependencies(tables []string) ([]string, error) {
+	return sortTablesByDependencies(dm, tables)
+}
 
-	var visit func(string) error
-	visit = func(tableName string) error {
-		if temp[tableName] {
-			return fmt.Errorf("circular dependency detected involving table %s", tableName)
-		}
-		if visited[tableName] {
-			return nil
-		}
+// GetTableDependencies maps each table to the tables in the list it references through foreign keys
+func (dm *DatabaseMigrator) GetTableDependencies(tables []string) (map[string][]string, error) {
+	return tableDependencies(dm, tables)
+}
 
-		temp[tableName] = true
+// DisableForeignKeyChecks disables foreign key checks temporarily. Connections opened by
+// NewDatabaseMigrator already have them disabled, so this only applies to the destination
+// given to NewDatabaseMigratorWithDB.
+func (dm *DatabaseMigrator) DisableForeignKeyChecks() error {
+	if dm.destChecksOff {
+		return nil
+	}
+	ctx, cancel := dm.queryContext()
+	defer cancel()
 
-		for _, dep := range dependencies[tableName] {
-			if err := visit(dep); err != nil {
-				return err
-			}
-		}
+	err := dm.dest.setForeignKeyChecks(ctx, dm.destDB, false)
+	if err != nil {
+		return fmt.Errorf("failed to disable foreign key checks: %v", err)
+	}
+	return nil
+}
 
-		temp[tableName] = false
-		visited[tableName] = true
-		result = append(result, tableName)
+// EnableForeignKeyChecks re-enables foreign key checks. The connections opened by
+// NewDatabaseMigrator keep them disabled until they are closed.
+func (dm *DatabaseMigrator) EnableForeignKeyChecks() error {
+	if dm.destChecksOff {
 		return nil
 	}
+	// Also runs when the migration was cancelled, so the destination isn't left without checks
+	ctx, cancel := dm.withQueryTimeout(context.WithoutCancel(dm.ctx))
+	defer cancel()
 
-	for _, tableName := range tables {
-		if !visited[tableName] {
-			if err := visit(tableName); err != nil {
-				return nil, err
-			}
-		}
+	err := dm.dest.setForeignKeyChecks(ctx, dm.destDB, true)
+	if err != nil {
+		return fmt.Errorf("failed to enable foreign key checks: %v", err)
 	}
-
-	return result, nil
+	return nil
 }
 
-// DisableForeignKeyChecks disables foreign key checks temporarily
-func (dm *DatabaseMigrator) DisableForeignKeyChecks() error {
-	_, err := dm.destDB.Exec("SET FOREIGN_KEY_CHECKS = 0")
+// truncateDestTable empties a destination table on a connection with foreign key checks disabled,
+// which MySQL requires to truncate a table other tables reference
+func (dm *DatabaseMigrator) truncateDestTable(ctx context.Context, tableName string) error {
+	pool, pooled := dm.destDB.(*sql.DB)
+	if dm.destChecksOff || !pooled {
+		_, err := dm.destDB.ExecContext(ctx, dm.dest.truncate(tableName))
+		return err
+	}
+
+	// DisableForeignKeyChecks only reached one connection of the pool, pin one for the statement
+	conn, err := pool.Conn(ctx)
 	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := dm.dest.setForeignKeyChecks(ctx, conn, false); err != nil {
 		return fmt.Errorf("failed to disable foreign key checks: %v", err)
 	}
-	return nil
+	defer dm.dest.setForeignKeyChecks(context.WithoutCancel(ctx), conn, true)
+
+	_, err = conn.ExecContext(ctx, dm.dest.truncate(tableName))
+	return err
 }
 
-// EnableForeignKeyChecks re-enables foreign key checks
-func (dm *DatabaseMigrator) EnableForeignKeyChecks() error {
-	_, err := dm.destDB.Exec("SET FOREIGN_KEY_CHECKS = 1")
-	if err != nil {
-		return fmt.Errorf("failed to enable foreign key checks: %v", err)
+// checkCircularDependencyCopy refuses AllowCircularDependencies when only one connection of the
+// destination pool is sure to have foreign key checks disabled while tables are copied in
+// parallel, as the rows of a cycle would then fail their foreign keys on the other connections
+func (dm *DatabaseMigrator) checkCircularDependencyCopy() error {
+	if dm.destChecksOff || dm.dest.name() != driverMySQL {
+		return nil
+	}
+	if _, pooled := dm.destDB.(*sql.DB); !pooled {
+		return nil
+	}
+	if max(dm.config.Concurrency, 1)*max(dm.config.TableWorkers, 1) > 1 {
+		return fmt.Errorf("allowCircularDependencies needs foreign key checks disabled on every destination connection: " +
+			"open the destination with foreign_key_checks=0 or set concurrency and tableWorkers to 1")
 	}
 	return nil
 }
 
-// MigrateTableData migrates data from source to destination table in batches
-func (dm *DatabaseMigrator) MigrateTableData(tableName string) error {
-	dm.logger.Log(fmt.Sprintf("Starting data migration for table: %s", tableName))
+// MigrateTableData migrates data from source to destination table in batches,
+// stopping between batches once ctx is cancelled
+func (dm *DatabaseMigrator) MigrateTableData(ctx context.Context, tableName string) error {
+	logger := dm.logger.ForTable(tableName)
+	tableStart := time.Now()
+
+	logger.Log(fmt.Sprintf("Starting data migration for table: %s", tableName))
 
 	// Get table columns
-	columns, err := dm.GetTableColumns(tableName)
+	columnInfos, err := dm.GetTableColumnInfo(tableName)
 	if err != nil {
 		return err
 	}
+	columnInfos = dm.mappedColumns(tableName, columnInfos)
+	// Generated columns are computed again by the destination from the copied ones
+	copied, generated := splitGeneratedColumns(columnInfos)
+	if len(generated) > 0 {
+		columnInfos = copied
+		logger.Log(fmt.Sprintf("Table %s: not copying generated columns %s", tableName, strings.Join(generated, ", ")))
+	}
+	columns := make([]string, len(columnInfos))
+	for i, info := range columnInfos {
+		columns[i] = info.Name
+	}
+	// Rows are read under the source column names and inserted under the destination ones
+	destInfos := dm.destColumnInfos(tableName, columnInfos)
+	destColumns := make([]string, len(destInfos))
+	for i, info := range destInfos {
+		destColumns[i] = info.Name
+	}
 
-	columnNames := strings.Join(columns, "`, `")
-	placeholders := strings.Repeat("?,", len(columns))
-	placeholders = placeholders[:len(placeholders)-1] // Remove last comma
+	placeholders := placeholderList(dm.dest, len(columns))
 
 	// Get total row count
 	totalRows, err := dm.GetTableRowCount(tableName)
@@ -363,212 +770,581 @@ func (dm *DatabaseMigrator) MigrateTableData(tableName string) error {
 		return err
 	}
 
-	dm.logger.Log(fmt.Sprintf("Table %s has %d rows to migrate", tableName, totalRows))
-
-	if totalRows == 0 {
-		dm.logger.Log(fmt.Sprintf("Table %s is empty, skipping data migration", tableName))
-		return nil
+	sampleCondition, whereArgs, err := dm.sampleClause(tableName, columns)
+	if err != nil {
+		return err
 	}
 
-	// Prepare insert statement
-	insertQuery := fmt.Sprintf("INSERT INTO `%s` (`%s`) VALUES (%s)",
-		tableName, columnNames, placeholders)
-
-	insertStmt, err := dm.destDB.Prepare(insertQuery)
+	// Rows are selected by the RowFilters condition, sampled among the rows it matches
+	condition, err := dm.rowFilter(tableName)
 	if err != nil {
-		return fmt.Errorf("failed to prepare insert statement: %v", err)
+		return err
+	}
+	if condition != "" {
+		logger.Log(fmt.Sprintf("Table %s: copying the rows matching %s", tableName, condition))
 	}
-	defer insertStmt.Close()
+	if sampleCondition != "" {
+		if condition != "" {
+			condition = "(" + condition + ") AND (" + sampleCondition + ")"
+		} else {
+			condition = sampleCondition
+		}
 
-	// Migrate data in batches
-	offset := 0
-	migratedRows := 0
+		sourceRows := totalRows
+		totalRows, err = dm.countRows(tableName, " WHERE "+condition, whereArgs)
+		if err != nil {
+			return err
+		}
+		logger.Log(fmt.Sprintf("Table %s: sampling %d of %d rows", tableName, totalRows, sourceRows))
+		totalRows = dm.capRows(totalRows)
+	}
+	randomSample := dm.config.MaxRowsPerTable > 0 && dm.config.SampleStrategy == sampleRandom
+	if dm.config.MaxRowsPerTable > 0 {
+		logger.Log(fmt.Sprintf("Table %s: copying at most %d rows (%s)", tableName, dm.config.MaxRowsPerTable, dm.sampleStrategy()))
+	}
 
-	for offset < totalRows {
-		selectQuery := fmt.Sprintf("SELECT `%s` FROM `%s` LIMIT %d OFFSET %d",
-			columnNames, tableName, dm.config.BatchSize, offset)
+	logger.Log(fmt.Sprintf("Table %s has %d rows to migrate", tableName, totalRows))
 
-		rows, err := dm.sourceDB.Query(selectQuery)
+	// A resumed table keeps the rows copied by the previous run
+	if dm.config.TruncateBeforeInsert && !dm.config.DryRun && !dm.checkpoint.table(tableName).DataStarted {
+		truncateCtx, cancel := dm.withQueryTimeout(ctx)
+		err := dm.truncateDestTable(truncateCtx, tableName)
+		cancel()
 		if err != nil {
-			return fmt.Errorf("failed to select data from table %s: %v", tableName, err)
+			return fmt.Errorf("failed to truncate destination table %s: %v", tableName, err)
 		}
+		logger.Log(fmt.Sprintf("Table %s: truncated destination table before copying", tableName))
+	}
 
-		// Process batch
-		for rows.Next() {
-			// Create slice to hold values
-			values := make([]interface{}, len(columns))
-			valuePtrs := make([]interface{}, len(columns))
-			for i := range values {
-				valuePtrs[i] = &values[i]
-			}
+	if totalRows == 0 {
+		logger.Log(fmt.Sprintf("Table %s is empty, skipping data migration", tableName))
+		dm.report.addTable(TableReport{Table: tableName, Duration: time.Since(tableStart)})
+		return nil
+	}
 
-			// Scan values
-			if err := rows.Scan(valuePtrs...); err != nil {
-				rows.Close()
-				return fmt.Errorf("failed to scan row: %v", err)
-			}
+	// Prepare insert statement
+	insertQuery := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		dm.dest.quote(tableName), quoteList(dm.dest, destColumns), placeholders)
+
+	var existingKeys *keySet
+	pkIndex := -1
+	if dm.config.SkipExistingRows {
+		var pkColumn string
+		existingKeys, pkColumn, err = dm.loadExistingKeys(tableName)
+		if err != nil {
+			return err
+		}
 
-			// Process values to handle invalid dates and other problematic values
-			for i, val := range values {
-				if val != nil {
-					colName := columns[i]
-					switch v := val.(type) {
-					case string:
-						if v == "0000-00-00" || v == "0000-00-00 00:00:00" {
-							if tableName == "AspNetUsers" && colName == "Birthday" {
-								values[i] = "1970-01-01"
-							} else {
-								values[i] = nil
-							}
-						}
-					case []byte:
-						str := string(v)
-						if str == "0000-00-00" || str == "0000-00-00 00:00:00" {
-							if tableName == "AspNetUsers" && colName == "Birthday" {
-								values[i] = "1970-01-01"
-							} else {
-								values[i] = nil
-							}
-						}
-					case time.Time:
-						if v.IsZero() || v.Year() == 0 {
-							if tableName == "AspNetUsers" && colName == "Birthday" {
-								values[i] = "1970-01-01"
-							} else {
-								values[i] = nil
-							}
-						}
-					}
+		if existingKeys == nil {
+			logger.Log(fmt.Sprintf("Table %s has no single integer primary key, upserting rows instead", tableName))
+			insertQuery = dm.dest.upsert(tableName, destColumns, placeholders)
+		} else {
+			logger.Log(fmt.Sprintf("Table %s: %d rows already exist in destination", tableName, existingKeys.Len()))
+			for i, col := range columns {
+				if col == pkColumn {
+					pkIndex = i
 				}
 			}
+		}
+	}
 
-			// Insert into destination
-			_, err := insertStmt.Exec(values...)
-			if err != nil {
-				rows.Close()
-				return fmt.Errorf("failed to insert row: %v", err)
-			}
+	// A resumed table may already hold rows copied after its last checkpoint, so upsert them
+	saved := dm.checkpoint.table(tableName)
+	if saved.DataStarted && !dm.config.SkipExistingRows {
+		logger.Log(fmt.Sprintf("Table %s: resuming from row %d", tableName, saved.Offset))
+		insertQuery = dm.dest.upsert(tableName, destColumns, placeholders)
+	}
+	if err := dm.checkpoint.update(tableName, func(t *TableCheckpoint) { t.DataStarted = true }); err != nil {
+		return err
+	}
 
-			migratedRows++
-		}
+	maxReprepares := dm.config.MaxReprepareAttempts
+	if maxReprepares <= 0 {
+		maxReprepares = defaultMaxReprepareAttempts
+	}
+
+	srids, err := dm.spatialColumnSRIDs(tableName, columns)
+	if err != nil {
+		return err
+	}
 
-		rows.Close()
-		offset += dm.config.BatchSize
+	pkColumns, keyIndexes, err := dm.pagingKey(tableName, columns)
+	if err != nil {
+		return err
+	}
+	if pkColumns == nil && !randomSample {
+		logger.Warn(fmt.Sprintf("Table %s has no primary key, keyset pagination is unavailable so it is copied in a single pass (set orderBy to page it)", tableName))
+	}
 
-		// Log progress
-		progress := float64(migratedRows) / float64(totalRows) * 100
-		dm.logger.Log(fmt.Sprintf("Table %s: %d/%d rows migrated (%.2f%%)",
-			tableName, migratedRows, totalRows, progress))
+	tc := &tableCopy{
+		tableName:     tableName,
+		logger:        logger,
+		columns:       columns,
+		columnInfos:   columnInfos,
+		condition:     condition,
+		whereArgs:     whereArgs,
+		pkColumns:     pkColumns,
+		keyIndexes:    keyIndexes,
+		transformers:  dm.columnTransformers(tableName, columnInfos),
+		srids:         srids,
+		existingKeys:  existingKeys,
+		pkIndex:       pkIndex,
+		insertQuery:   insertQuery,
+		maxReprepares: maxReprepares,
+		sampled:       sampleCondition != "",
+		randomSample:  randomSample,
+		totalRows:     totalRows,
+		bar:           dm.newTableProgress(tableName, totalRows, saved.Offset),
+	}
+	if tc.bar != nil {
+		defer tc.bar.finish()
 	}
 
-	dm.logger.Log(fmt.Sprintf("Completed data migration for table: %s (%d rows)", tableName, migratedRows))
+	var stats copyStats
+	if workers := dm.tableWorkers(tc, saved); workers > 1 {
+		stats, err = dm.copyKeyRanges(ctx, tc, workers)
+	} else {
+		stats, err = dm.copyBatches(ctx, tc, saved)
+	}
+	if err != nil {
+		return err
+	}
+	migratedRows, skippedRows, rejectedRows := stats.migrated, stats.skipped, stats.rejected
+
+	finishCtx, cancel := dm.withQueryTimeout(ctx)
+	defer cancel()
+	if err := dm.dest.finishTable(finishCtx, dm.destDB, tableName, destInfos); err != nil {
+		return err
+	}
+	if err := dm.preserveAutoIncrement(finishCtx, tableName, columnInfos); err != nil {
+		return err
+	}
+
+	if sampleCondition != "" {
+		logger.Log(fmt.Sprintf("Table %s: sampled %d rows", tableName, migratedRows))
+	}
+	if existingKeys != nil {
+		logger.Log(fmt.Sprintf("Table %s: %d rows inserted, %d skipped as already existing", tableName, migratedRows, skippedRows))
+	}
+	if rejectedRows > 0 {
+		logger.Warn(fmt.Sprintf("Table %s: %d rows were rejected and written to %s", tableName, rejectedRows, dm.failedRows.path))
+	}
+	logger.Log(fmt.Sprintf("Completed data migration for table: %s (%d rows)", tableName, migratedRows))
+	dm.report.addTable(TableReport{
+		Table:        tableName,
+		RowsMigrated: migratedRows,
+		RowsSkipped:  skippedRows,
+		RowsRejected: rejectedRows,
+		Bytes:        stats.bytes,
+		Duration:     time.Since(tableStart),
+	})
 	return nil
 }
 
+// insertBatch inserts rows with insertStmt inside a single transaction, rolling it back on the first error
+func (dm *DatabaseMigrator) insertBatch(ctx context.Context, insertStmt *sql.Stmt, batch [][]interface{}) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	tx, err := dm.destDB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	stmt := tx.Stmt(insertStmt)
+	defer stmt.Close()
+
+	for i, values := range batch {
+		if _, err := stmt.ExecContext(ctx, values...); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to insert row %d of the batch: %w", i+1, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit batch: %w", err)
+	}
+	return nil
+}
+
+// isBrokenConnection reports whether an error means the connection (and any statement
+// prepared on it) is unusable, as opposed to the row itself being rejected
+func isBrokenConnection(err error) bool {
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		strings.Contains(err.Error(), "statement is closed")
+}
+
+// isZeroDate reports whether a scanned value is one of MySQL's invalid zero dates
+func isZeroDate(val interface{}) bool {
+	switch v := val.(type) {
+	case string:
+		return v == "0000-00-00" || v == "0000-00-00 00:00:00"
+	case []byte:
+		str := string(v)
+		return str == "0000-00-00" || str == "0000-00-00 00:00:00"
+	case time.Time:
+		return v.IsZero() || v.Year() == 0
+	}
+	return false
+}
+
 // MigrateTable migrates both schema and data for a single table
 func (dm *DatabaseMigrator) MigrateTable(tableName string) error {
 	dm.logger.Log(fmt.Sprintf("Starting migration for table: %s", tableName))
 
+	if err := dm.MigrateTableSchema(tableName); err != nil {
+		return err
+	}
+
+	// Migrate table data
+	if err := dm.MigrateTableData(dm.ctx, tableName); err != nil {
+		return fmt.Errorf("failed to migrate data for table %s: %v", tableName, err)
+	}
+
+	return nil
+}
+
+// MigrateTableSchema creates a single table in the destination from its source schema. With
+// SkipExistingTables a table the destination already has is kept and only its data is copied.
+func (dm *DatabaseMigrator) MigrateTableSchema(tableName string) error {
+	if dm.config.SkipExistingTables {
+		exists, err := dm.destTableExists(tableName)
+		if err != nil {
+			return err
+		}
+		if exists {
+			dm.logger.Log(fmt.Sprintf("Table %s already exists in destination, keeping it and only migrating data", tableName))
+			return nil
+		}
+		dm.logger.Log(fmt.Sprintf("Table %s does not exist in destination, creating it", tableName))
+	}
+
 	// Get and create table schema
 	createStmt, err := dm.GetTableSchema(tableName)
 	if err != nil {
 		return err
 	}
 
+	createStmt, err = dm.rewriteSpatialDDL(tableName, createStmt)
+	if err != nil {
+		return err
+	}
+	createStmt, err = dm.rewriteVersionDDL(tableName, createStmt)
+	if err != nil {
+		return err
+	}
+
+	if dm.config.DeferIndexes {
+		var deferred deferredDefinitions
+		createStmt, deferred = splitDeferredDefinitions(createStmt)
+		dm.deferred[tableName] = deferred
+		dm.logger.Log(fmt.Sprintf("Deferred %d indexes and %d foreign keys of table %s",
+			len(deferred.Indexes), len(deferred.Constraints), tableName))
+	}
+
 	if err := dm.CreateTable(createStmt); err != nil {
 		return fmt.Errorf("failed to create table %s: %v", tableName, err)
 	}
 
+	// A generated schema only has the primary key, so add the source indexes to it
+	if dm.generatesSchema(tableName) {
+		if err := dm.MigrateIndexes(tableName); err != nil {
+			return err
+		}
+	}
+
 	dm.logger.Log(fmt.Sprintf("Created table schema for: %s", tableName))
+	return nil
+}
 
-	// Migrate table data
-	if err := dm.MigrateTableData(tableName); err != nil {
-		return fmt.Errorf("failed to migrate data for table %s: %v", tableName, err)
+// migrateSchemaStep creates one table in the schema phase, unless the previous run already did
+func (dm *DatabaseMigrator) migrateSchemaStep(tableName string) error {
+	if saved := dm.checkpoint.table(tableName); saved.SchemaCreated {
+		dm.logger.Log(fmt.Sprintf("Table %s was created by the previous run, skipping schema", tableName))
+		if saved.Deferred != nil {
+			dm.deferred[tableName] = *saved.Deferred
+		}
+		return nil
 	}
 
-	return nil
+	if err := dm.MigrateTableSchema(tableName); err != nil {
+		return fmt.Errorf("migration failed for table %s: %v", tableName, err)
+	}
+	return dm.checkpoint.update(tableName, func(t *TableCheckpoint) {
+		t.SchemaCreated = true
+		if deferred, ok := dm.deferred[tableName]; ok {
+			t.Deferred = &deferred
+		}
+	})
 }
 
-// Migrate performs the complete database migration
-func (dm *DatabaseMigrator) Migrate() error {
+// migrateData runs the data phase, copying Concurrency tables at a time, each once the tables it references are done
+func (dm *DatabaseMigrator) migrateData(phases *phaseRunner, sortedTables []string, dependencies map[string][]string) error {
+	workers := max(dm.config.Concurrency, 1)
+	if workers > 1 {
+		dm.logger.Log(fmt.Sprintf("Migrating table data with %d workers", workers))
+	}
+	var started atomic.Int32
+	return phases.runConcurrent(phaseData, sortedTables, dependencies, workers, func(ctx context.Context, tableName string) error {
+		n := started.Add(1)
+		if dm.checkpoint.table(tableName).Completed {
+			dm.logger.Log(fmt.Sprintf("Table %d/%d: %s was completed by the previous run, skipping", n, len(sortedTables), tableName))
+			dm.report.skipTables(1)
+			return nil
+		}
+		dm.logger.Log(fmt.Sprintf("Migrating table %d/%d: %s", n, len(sortedTables), tableName))
+
+		if err := dm.MigrateTableData(ctx, tableName); err != nil {
+			return fmt.Errorf("migration failed for table %s: %v", tableName, err)
+		}
+		return dm.checkpoint.update(tableName, func(t *TableCheckpoint) { t.Completed = true })
+	})
+}
+
+// Migrate performs the complete database migration and reports what it copied. When the
+// migrator's context is cancelled the tables being copied finish their current batch, foreign
+// key checks are re-enabled and the progress is saved to the checkpoint file.
+func (dm *DatabaseMigrator) Migrate() (MigrationReport, error) {
+	ctx := dm.ctx
 	dm.logger.Log("Starting database migration")
 	startTime := time.Now()
 
 	// Get all tables
-	tables, err := dm.GetTables()
+	tables, excluded, err := dm.selectTables()
 	if err != nil {
-		return fmt.Errorf("failed to get tables: %v", err)
+		return dm.report.build(startTime), fmt.Errorf("failed to get tables: %v", err)
 	}
+	dm.report.skipTables(excluded)
 
 	dm.logger.Log(fmt.Sprintf("Found %d tables to migrate: %v", len(tables), tables))
+	if len(dm.config.OnlyTables) > 0 {
+		if err := dm.warnMissingDependencies(tables); err != nil {
+			return dm.report.build(startTime), fmt.Errorf("failed to check table dependencies: %v", err)
+		}
+	}
 
 	// Sort tables by dependencies
 	dm.logger.Log("Analyzing table dependencies...")
-	sortedTables, err := dm.SortTablesByDependencies(tables)
+	dependencies, err := dm.GetTableDependencies(tables)
+	if err != nil {
+		return dm.report.build(startTime), fmt.Errorf("failed to get table dependencies: %v", err)
+	}
+	if dm.config.AllowCircularDependencies {
+		if err := dm.checkCircularDependencyCopy(); err != nil {
+			return dm.report.build(startTime), err
+		}
+		var cycles [][]string
+		dependencies, cycles = breakDependencyCycles(tables, dependencies)
+		for _, cycle := range cycles {
+			dm.logger.Warn(fmt.Sprintf("Circular foreign keys %s, relying on disabled foreign key checks to copy them",
+				strings.Join(cycle, " -> ")))
+		}
+	}
+	sortedTables, err := sortByDependencies(tables, dependencies)
 	if err != nil {
-		return fmt.Errorf("failed to sort tables by dependencies: %v", err)
+		var cycleErr *CycleError
+		if errors.As(err, &cycleErr) {
+			return dm.report.build(startTime), fmt.Errorf("failed to sort tables by dependencies: %v (set allowCircularDependencies to migrate them anyway)", err)
+		}
+		return dm.report.build(startTime), fmt.Errorf("failed to sort tables by dependencies: %v", err)
 	}
 
 	dm.logger.Log(fmt.Sprintf("Tables sorted by dependencies: %v", sortedTables))
 
+	if dm.config.DryRun {
+		return dm.report.build(startTime), dm.logDryRun(sortedTables, dependencies)
+	}
+
+	if dm.config.CheckSchema {
+		if err := dm.checkSchemas(sortedTables); err != nil {
+			return dm.report.build(startTime), err
+		}
+	}
+
+	// Progress is always tracked, so an interrupted migration can be resumed,
+	// but only written as it goes when checkpointing is enabled
+	path := dm.config.CheckpointFile
+	if path == "" {
+		path = defaultCheckpointFile
+	}
+	dm.checkpoint, err = openCheckpoint(path, dm.config.Resume, dm.config.Resume || dm.config.CheckpointFile != "")
+	if err != nil {
+		return dm.report.build(startTime), err
+	}
+	if dm.checkpoint.resumed {
+		dm.logger.Log(fmt.Sprintf("Resuming migration from checkpoint %s", path))
+	}
+
+	finished := false
+	defer func() {
+		if finished || ctx.Err() == nil {
+			return
+		}
+		if err := dm.checkpoint.save(); err != nil {
+			dm.logger.Error(fmt.Sprintf("Migration interrupted, failed to save progress: %v", err))
+			return
+		}
+		dm.logger.Warn(fmt.Sprintf("Migration interrupted, progress saved to %s, run again with -resume to continue", path))
+	}()
+
+	if err := dm.prepareSampling(sortedTables); err != nil {
+		return dm.report.build(startTime), fmt.Errorf("failed to prepare sampling: %v", err)
+	}
+
 	// Disable foreign key checks during migration
 	dm.logger.Log("Disabling foreign key checks for migration...")
 	if err := dm.DisableForeignKeyChecks(); err != nil {
-		return fmt.Errorf("failed to disable foreign key checks: %v", err)
+		return dm.report.build(startTime), fmt.Errorf("failed to disable foreign key checks: %v", err)
+	}
+
+	phases := newPhaseRunner(ctx, dm, startTime)
+	defer phases.logSummary()
+
+	// Create every table, then copy the data, each in dependency order
+	if dm.config.DataOnly {
+		dm.logger.Log("Data only migration, expecting the destination tables to exist")
+	} else {
+		err = phases.run(phaseSchema, sortedTables, dm.migrateSchemaStep)
+	}
+	if err != nil {
+		// Re-enable foreign key checks before returning error
+		dm.EnableForeignKeyChecks()
+		return dm.report.build(startTime), err
 	}
 
-	// Migrate each table in dependency order
-	for i, tableName := range sortedTables {
-		dm.logger.Log(fmt.Sprintf("Migrating table %d/%d: %s", i+1, len(sortedTables), tableName))
+	if dm.config.SchemaOnly {
+		dm.logger.Log("Schema only migration, not copying any rows")
+	} else {
+		err = dm.migrateData(phases, sortedTables, dependencies)
+	}
+	if err != nil {
+		// Re-enable foreign key checks before returning error
+		dm.EnableForeignKeyChecks()
+		return dm.report.build(startTime), err
+	}
 
-		if err := dm.MigrateTable(tableName); err != nil {
-			// Re-enable foreign key checks before returning error
+	if dm.config.DeferIndexes {
+		err = phases.run(phaseIndexes, []string{"indexes"}, func(string) error {
+			return dm.BuildDeferredIndexes(sortedTables)
+		})
+		if err == nil {
+			err = phases.run(phaseConstraints, []string{"constraints"}, func(string) error {
+				return dm.AddDeferredConstraints(sortedTables)
+			})
+		}
+		if err != nil {
 			dm.EnableForeignKeyChecks()
-			return fmt.Errorf("migration failed for table %s: %v", tableName, err)
+			return dm.report.build(startTime), err
 		}
 	}
 
 	// Re-enable foreign key checks
 	dm.logger.Log("Re-enabling foreign key checks...")
 	if err := dm.EnableForeignKeyChecks(); err != nil {
-		return fmt.Errorf("failed to enable foreign key checks: %v", err)
+		return dm.report.build(startTime), fmt.Errorf("failed to enable foreign key checks: %v", err)
+	}
+
+	var verificationSteps []string
+	if dm.config.Verify {
+		verificationSteps = append(verificationSteps, "verify")
+	}
+	if dm.config.ManifestFile != "" {
+		verificationSteps = append(verificationSteps, "manifest")
+	}
+	if len(verificationSteps) > 0 {
+		err = phases.run(phaseVerification, verificationSteps, func(step string) error {
+			if step == "verify" {
+				return dm.VerifyMigration(sortedTables)
+			}
+			if err := dm.WriteManifest(dm.config.ManifestFile, sortedTables); err != nil {
+				return fmt.Errorf("failed to write manifest: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return dm.report.build(startTime), err
+		}
+	}
+
+	// A finished migration must not be resumed
+	finished = true
+	if err := dm.checkpoint.remove(); err != nil {
+		return dm.report.build(startTime), err
 	}
 
 	duration := time.Since(startTime)
+	if dm.failedRows != nil {
+		if failures := dm.failedRows.failures(); failures != nil {
+			dm.logger.Warn(fmt.Sprintf("Database migration completed in %v, but %v", duration, failures))
+			return dm.report.build(startTime), failures
+		}
+	}
 	dm.logger.Log(fmt.Sprintf("Database migration completed successfully in %v", duration))
-	return nil
+	report := dm.report.build(startTime)
+	dm.logger.Log(report.String())
+	return report, nil
 }
 
 func main() {
-	config := MigrationConfig{
-		Source: DatabaseConfig{
-			Host:     "localhost",
-			Port:     "3306",
-			Username: "root",
-			Password: "",
-			Database: "lms_dev",
-		},
-		Destination: DatabaseConfig{
-			Host:     "localhost",
-			Port:     "3306",
-			Username: "root",
-			Password: "",
-			Database: "lms_dev",
-		},
-		BatchSize:  1000,
-		SkipTables: []string{},
-		LogFile:    "migration.log",
-	}
-
-	migrator, err := NewDatabaseMigrator(config)
+	if err := env.Load(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	config, opts, err := parseFlags(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// The first SIGINT or SIGTERM stops the migration cleanly, a second one kills it
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	migrator, err := NewDatabaseMigrator(ctx, config)
 	if err != nil {
 		log.Fatalf("Failed to create migrator: %v", err)
 	}
 	defer migrator.Close()
 
-	if err := migrator.Migrate(); err != nil {
+	if opts.VerifyOnly {
+		if err := migrator.VerifyManifest(config.ManifestFile); err != nil {
+			log.Fatalf("Verification failed: %v", err)
+		}
+		fmt.Println("Verification completed successfully!")
+		return
+	}
+
+	report, err := migrator.Migrate()
+	if opts.SummaryJSON != "" {
+		if err := writeSummaryJSON(opts.SummaryJSON, report, err, ctx.Err() != nil); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+	}
+	if err != nil {
+		var failures *FailedRowsError
+		if errors.As(err, &failures) {
+			fmt.Fprintf(os.Stderr, "Migration completed with errors: %v\n", failures)
+			os.Exit(1)
+		}
+		if ctx.Err() != nil {
+			fmt.Fprintf(os.Stderr, "Migration interrupted: %v\n", err)
+			migrator.Close()
+			os.Exit(130)
+		}
 		log.Fatalf("Migration failed: %v", err)
 	}
 
+	if config.DryRun {
+		fmt.Println("Dry run completed successfully!")
+		return
+	}
+	fmt.Println(report)
 	fmt.Println("Migration completed successfully!")
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// keyRange is an inclusive range of an integer primary key
+type keyRange struct {
+	lo, hi int64
+}
+
+// tableWorkers returns how many goroutines copy a table: TableWorkers when its rows can be split
+// by a single integer primary key, otherwise 1. Key ranges are not checkpointed, so a resumed
+// table, a sample and a MaxRowsPerTable cap are copied one batch after the other.
+func (dm *DatabaseMigrator) tableWorkers(tc *tableCopy, saved TableCheckpoint) int {
+	if dm.config.TableWorkers <= 1 || len(tc.pkColumns) != 1 {
+		return 1
+	}
+	if saved.Offset > 0 || saved.LastKey != nil || tc.sampled || dm.config.MaxRowsPerTable > 0 {
+		return 1
+	}
+	if !isIntegerType(tc.columnInfos[tc.keyIndexes[0]].Type) {
+		return 1
+	}
+	return dm.config.TableWorkers
+}
+
+// keyRanges splits the primary key values of the rows to copy into at most workers ranges
+// of the same width between MIN and MAX, none when there are no rows
+func (dm *DatabaseMigrator) keyRanges(ctx context.Context, tc *tableCopy, workers int) ([]keyRange, error) {
+	pk := dm.source.quote(tc.pkColumns[0])
+	query := fmt.Sprintf("SELECT MIN(%s), MAX(%s) FROM %s", pk, pk, dm.source.quote(tc.tableName))
+	if tc.condition != "" {
+		query += " WHERE " + tc.condition
+	}
+
+	queryCtx, cancel := dm.withQueryTimeout(ctx)
+	defer cancel()
+	var lo, hi sql.NullInt64
+	if err := dm.sourceDB.QueryRowContext(queryCtx, query, tc.whereArgs...).Scan(&lo, &hi); err != nil {
+		return nil, fmt.Errorf("failed to get the key range of table %s: %v", tc.tableName, err)
+	}
+	if !lo.Valid || !hi.Valid {
+		return nil, nil
+	}
+
+	// The width is computed unsigned, so keys spanning the whole int64 range don't overflow
+	width := uint64(hi.Int64-lo.Int64)/uint64(workers) + 1
+	var ranges []keyRange
+	for start := lo.Int64; ; {
+		end := hi.Int64
+		if uint64(hi.Int64-start) >= width {
+			end = start + int64(width) - 1
+		}
+		ranges = append(ranges, keyRange{lo: start, hi: end})
+		if end == hi.Int64 {
+			return ranges, nil
+		}
+		start = end + 1
+	}
+}
+
+// copyKeyRanges copies a table with workers goroutines, each reading and inserting the rows
+// of one key range in batches with its own insert statement. The first error stops the others.
+func (dm *DatabaseMigrator) copyKeyRanges(ctx context.Context, tc *tableCopy, workers int) (copyStats, error) {
+	var stats copyStats
+	ranges, err := dm.keyRanges(ctx, tc, workers)
+	if err != nil {
+		return stats, err
+	}
+	tc.logger.Log(fmt.Sprintf("Table %s: copying %d key ranges in parallel", tc.tableName, len(ranges)))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// read and migrated are shared by the workers for the progress
+	var read, migrated atomic.Int64
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for _, r := range ranges {
+		wg.Add(1)
+		go func(r keyRange) {
+			defer wg.Done()
+			rangeStats, err := dm.copyKeyRange(ctx, tc, r, &read, &migrated)
+
+			mu.Lock()
+			defer mu.Unlock()
+			stats.add(rangeStats)
+			if err != nil && firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return stats, firstErr
+	}
+	return stats, nil
+}
+
+// copyKeyRange copies the rows of a table whose primary key is within r. Like copyBatches,
+// a started batch is finished when ctx is cancelled.
+func (dm *DatabaseMigrator) copyKeyRange(ctx context.Context, tc *tableCopy, r keyRange, read, migrated *atomic.Int64) (copyStats, error) {
+	var stats copyStats
+
+	ins, err := dm.newBatchInserter(ctx, tc)
+	if err != nil {
+		return stats, err
+	}
+	defer func() {
+		ins.close()
+	}()
+
+	// The range bounds follow the filter arguments, pageQuery numbers its own after them
+	pk := dm.source.quote(tc.pkColumns[0])
+	condition := fmt.Sprintf("%s >= %s AND %s <= %s",
+		pk, dm.source.placeholder(len(tc.whereArgs)+1), pk, dm.source.placeholder(len(tc.whereArgs)+2))
+	if tc.condition != "" {
+		condition = "(" + tc.condition + ") AND " + condition
+	}
+	args := append(append([]interface{}(nil), tc.whereArgs...), r.lo, r.hi)
+
+	var lastKey []interface{}
+	cancelBatch := func() {}
+	defer func() { cancelBatch() }()
+	for {
+		cancelBatch()
+		var batchCtx context.Context
+		batchCtx, cancelBatch = dm.withQueryTimeout(context.WithoutCancel(ctx))
+
+		if err := ctx.Err(); err != nil {
+			return stats, fmt.Errorf("data migration of table %s stopped in key range %d-%d: %v", tc.tableName, r.lo, r.hi, err)
+		}
+
+		selectQuery, selectArgs := dm.pageQuery(tc.tableName, tc.columns, tc.pkColumns, condition, args, lastKey, dm.config.BatchSize)
+		rows, err := dm.sourceDB.QueryContext(batchCtx, selectQuery, selectArgs...)
+		if err != nil {
+			return stats, fmt.Errorf("failed to select data from table %s: %v", tc.tableName, err)
+		}
+		batch, err := dm.readBatch(tc, rows)
+		if err != nil {
+			return stats, err
+		}
+		at := fmt.Sprintf("key %d", r.lo)
+		if lastKey != nil {
+			at = "key " + profileText(lastKey[0])
+		}
+		if batch.lastKey != nil {
+			lastKey = batch.lastKey
+		}
+		stats.skipped += batch.skipped
+		stats.rejected += batch.rejected
+
+		inserted, err := dm.insertRows(batchCtx, tc, ins, batch.values, at)
+		stats.rejected += len(batch.values) - len(inserted)
+		if err != nil {
+			return stats, err
+		}
+		dm.countInserted(tc, &stats, inserted)
+
+		dm.logTableProgress(tc, int(read.Add(int64(batch.read))), int(migrated.Add(int64(len(inserted)))))
+
+		if batch.read < dm.config.BatchSize {
+			return stats, nil
+		}
+	}
+}
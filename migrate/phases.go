@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Migration phases, in the order Migrate runs them
+const (
+	phaseSchema       = "schema"
+	phaseData         = "data"
+	phaseIndexes      = "indexes"
+	phaseConstraints  = "constraints"
+	phaseVerification = "verification"
+)
+
+// Phase outcomes reported by Migrate
+const (
+	phaseCompleted = "completed"
+	phasePartial   = "partial"
+	phaseSkipped   = "skipped"
+	phaseAborted   = "aborted"
+)
+
+// PhaseBudget limits how long a migration phase may run
+type PhaseBudget struct {
+	Limit time.Duration `yaml:"limit"`
+	// SkipOnExceeded skips the rest of the phase with a warning instead of aborting the migration
+	SkipOnExceeded bool `yaml:"skipOnExceeded"`
+}
+
+// PhaseResult reports how a phase ended
+type PhaseResult struct {
+	Name     string
+	Status   string
+	Steps    int
+	Duration time.Duration
+}
+
+// phaseRunner runs the migration phases, enforcing PhaseBudgets and the MaintenanceWindow.
+// Budgets are checked between steps (tables), so a step that is running is never interrupted.
+type phaseRunner struct {
+	// ctx stops every phase from starting further steps once it is cancelled
+	ctx     context.Context
+	dm      *DatabaseMigrator
+	start   time.Time
+	results []PhaseResult
+}
+
+func newPhaseRunner(ctx context.Context, dm *DatabaseMigrator, start time.Time) *phaseRunner {
+	return &phaseRunner{ctx: ctx, dm: dm, start: start}
+}
+
+// exceeded handles a phase running out of time, returning a non-nil error when the migration must abort
+func (pr *phaseRunner) exceeded(result *PhaseResult, budget PhaseBudget, reason string) error {
+	if budget.SkipOnExceeded {
+		pr.dm.logger.Warn(fmt.Sprintf("%s, skipping the rest of the %s phase", reason, result.Name))
+		if result.Steps == 0 {
+			result.Status = phaseSkipped
+		} else {
+			result.Status = phasePartial
+		}
+		return nil
+	}
+
+	result.Status = phaseAborted
+	return fmt.Errorf("%s, aborting migration", reason)
+}
+
+func (pr *phaseRunner) run(name string, steps []string, step func(string) error) error {
+	return pr.runConcurrent(name, steps, nil, 1, func(_ context.Context, s string) error {
+		return step(s)
+	})
+}
+
+// runConcurrent runs the steps of a phase on up to workers goroutines, starting a step
+// only once the steps it depends on have finished. A failing step cancels the context
+// passed to the steps still running, while a phase out of budget lets them finish and
+// only stops the remaining steps from starting.
+func (pr *phaseRunner) runConcurrent(name string, steps []string, dependencies map[string][]string, workers int,
+	step func(ctx context.Context, s string) error) error {
+	budget := pr.dm.config.PhaseBudgets[name]
+	window := pr.dm.config.MaintenanceWindow
+	phaseStart := time.Now()
+	result := PhaseResult{Name: name, Status: phaseCompleted}
+	defer func() {
+		result.Duration = time.Since(phaseStart)
+		pr.results = append(pr.results, result)
+	}()
+
+	// Don't start a phase the maintenance window has no room left for
+	if window > 0 && budget.Limit > 0 && window-time.Since(pr.start) < budget.Limit {
+		reason := fmt.Sprintf("maintenance window has %v left, less than the %v budget of the %s phase",
+			(window - time.Since(pr.start)).Round(time.Second), budget.Limit, name)
+		return pr.exceeded(&result, budget, reason)
+	}
+
+	var mu sync.Mutex
+	stopReason := ""
+	err := runWithDependencies(pr.ctx, steps, dependencies, workers, func(ctx context.Context, s string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		mu.Lock()
+		if stopReason == "" && budget.Limit > 0 && time.Since(phaseStart) > budget.Limit {
+			stopReason = fmt.Sprintf("%s phase exceeded its budget of %v", name, budget.Limit)
+		}
+		if stopReason == "" && window > 0 && time.Since(pr.start) > window {
+			stopReason = fmt.Sprintf("maintenance window of %v exceeded during %s phase", window, name)
+		}
+		stopped := stopReason != ""
+		mu.Unlock()
+		if stopped {
+			return nil
+		}
+
+		if err := step(ctx, s); err != nil {
+			return err
+		}
+		mu.Lock()
+		result.Steps++
+		mu.Unlock()
+		return nil
+	})
+
+	if err == nil && stopReason != "" {
+		return pr.exceeded(&result, budget, stopReason)
+	}
+	if err != nil {
+		result.Status = phaseAborted
+		return err
+	}
+
+	return nil
+}
+
+// logSummary reports which phases completed
+func (pr *phaseRunner) logSummary() {
+	for _, result := range pr.results {
+		pr.dm.logger.Log(fmt.Sprintf("Phase %s: %s (%d steps in %v)",
+			result.Name, result.Status, result.Steps, result.Duration.Round(time.Millisecond)))
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// postgresDialect migrates from or into the current schema (normally public) of a PostgreSQL database
+type postgresDialect struct{}
+
+func (postgresDialect) name() string { return driverPostgres }
+
+func (postgresDialect) open(cfg DatabaseConfig) (*sql.DB, error) {
+	dsn := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(cfg.Username, cfg.Password),
+		Host:   net.JoinHostPort(cfg.Host, cfg.Port),
+		Path:   "/" + cfg.Database,
+	}
+	if cfg.TLS != nil {
+		dsn.RawQuery = postgresTLSParams(cfg.TLS).Encode()
+	}
+	return sql.Open("pgx", dsn.String())
+}
+
+func (postgresDialect) quote(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+func (postgresDialect) placeholder(n int) string { return "$" + strconv.Itoa(n) }
+
+func (postgresDialect) randomOrder() string { return "random()" }
+
+// sampleHash reads the first 32 bits of the MD5 of the values, Postgres having no CRC32
+func (d postgresDialect) sampleHash(seed string, columns []string) (string, error) {
+	return fmt.Sprintf("('x' || substr(md5(concat_ws(',', %s::text, %s)), 1, 8))::bit(32)::bigint",
+		seed, quoteList(d, columns)), nil
+}
+
+// upsert keeps the existing row, which for a resumed table holds the same source values
+func (d postgresDialect) upsert(tableName string, columns []string, placeholders string) string {
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT DO NOTHING",
+		d.quote(tableName), quoteList(d, columns), placeholders)
+}
+
+func (d postgresDialect) truncate(tableName string) string {
+	return "TRUNCATE TABLE " + d.quote(tableName)
+}
+
+func (postgresDialect) listTables(ctx context.Context, db Querier) ([]string, error) {
+	query := `
+		SELECT table_name
+		FROM information_schema.tables
+		WHERE table_schema = current_schema()
+		AND table_type = 'BASE TABLE'
+		ORDER BY table_name`
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tables: %v", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			return nil, fmt.Errorf("failed to scan table name: %v", err)
+		}
+		tables = append(tables, tableName)
+	}
+	return tables, rows.Err()
+}
+
+// columnInfo reports columns with their format_type() type, flagging identity and serial columns as auto_increment
+func (d postgresDialect) columnInfo(ctx context.Context, db Querier, tableName string) ([]ColumnInfo, error) {
+	query := `
+		SELECT
+			a.attname,
+			format_type(a.atttypid, a.atttypmod),
+			NOT a.attnotnull,
+			pg_get_expr(ad.adbin, ad.adrelid),
+			a.attidentity <> '' OR COALESCE(pg_get_expr(ad.adbin, ad.adrelid), '') LIKE 'nextval(%',
+			a.attgenerated = 's'
+		FROM pg_attribute a
+		LEFT JOIN pg_attrdef ad ON ad.adrelid = a.attrelid AND ad.adnum = a.attnum
+		WHERE a.attrelid = $1::regclass
+		AND a.attnum > 0
+		AND NOT a.attisdropped
+		ORDER BY a.attnum`
+
+	rows, err := db.QueryContext(ctx, query, d.quote(tableName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns for table %s: %v", tableName, err)
+	}
+	defer rows.Close()
+
+	var columns []ColumnInfo
+	for rows.Next() {
+		var info ColumnInfo
+		var identity, generated bool
+		if err := rows.Scan(&info.Name, &info.Type, &info.Nullable, &info.Default, &identity, &generated); err != nil {
+			return nil, fmt.Errorf("failed to scan column info: %v", err)
+		}
+		if identity {
+			info.Extra = "auto_increment"
+		}
+		// Named as in SHOW COLUMNS, the default of a generated column is its expression
+		if generated {
+			info.Extra = "STORED GENERATED"
+		}
+		columns = append(columns, info)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get columns for table %s: %v", tableName, err)
+	}
+
+	pkColumns, err := d.primaryKey(ctx, db, tableName)
+	if err != nil {
+		return nil, err
+	}
+	for i := range columns {
+		for _, pk := range pkColumns {
+			if columns[i].Name == pk {
+				columns[i].Key = "PRI"
+			}
+		}
+	}
+
+	return columns, nil
+}
+
+func (d postgresDialect) primaryKey(ctx context.Context, db Querier, tableName string) ([]string, error) {
+	query := `
+		SELECT a.attname
+		FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+		WHERE i.indrelid = $1::regclass
+		AND i.indisprimary
+		ORDER BY array_position(i.indkey::int2[], a.attnum)`
+
+	rows, err := db.QueryContext(ctx, query, d.quote(tableName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get primary key for table %s: %v", tableName, err)
+	}
+	defer rows.Close()
+
+	var pkColumns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, fmt.Errorf("failed to scan key info: %v", err)
+		}
+		pkColumns = append(pkColumns, column)
+	}
+
+	return pkColumns, rows.Err()
+}
+
+func (postgresDialect) foreignKeys(ctx context.Context, db Querier, _ string, tableName string) ([]ForeignKeyInfo, error) {
+	query := `
+		SELECT
+			kcu.column_name,
+			ccu.table_name,
+			ccu.column_name
+		FROM information_schema.table_constraints tc
+		JOIN information_schema.key_column_usage kcu
+			ON kcu.constraint_schema = tc.constraint_schema AND kcu.constraint_name = tc.constraint_name
+		JOIN information_schema.constraint_column_usage ccu
+			ON ccu.constraint_schema = tc.constraint_schema AND ccu.constraint_name = tc.constraint_name
+		WHERE tc.constraint_type = 'FOREIGN KEY'
+		AND tc.table_schema = current_schema()
+		AND tc.table_name = $1`
+
+	rows, err := db.QueryContext(ctx, query, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get foreign keys for table %s: %v", tableName, err)
+	}
+	defer rows.Close()
+
+	return scanForeignKeys(rows, tableName)
+}
+
+// indexes reads the plain column indexes of a table; expression and partial indexes are left out
+func (d postgresDialect) indexes(ctx context.Context, db Querier, tableName string) ([]IndexInfo, error) {
+	query := `
+		SELECT i.relname, ix.indisunique, am.amname, a.attname
+		FROM pg_index ix
+		JOIN pg_class i ON i.oid = ix.indexrelid
+		JOIN pg_am am ON am.oid = i.relam
+		JOIN LATERAL unnest(ix.indkey) WITH ORDINALITY AS k(attnum, ord) ON true
+		JOIN pg_attribute a ON a.attrelid = ix.indrelid AND a.attnum = k.attnum
+		WHERE ix.indrelid = $1::regclass
+		AND NOT ix.indisprimary
+		AND ix.indexprs IS NULL
+		AND ix.indpred IS NULL
+		ORDER BY i.relname, k.ord`
+
+	rows, err := db.QueryContext(ctx, query, d.quote(tableName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get indexes for table %s: %v", tableName, err)
+	}
+	defer rows.Close()
+
+	var indexes []IndexInfo
+	for rows.Next() {
+		var name, method, column string
+		var unique bool
+		if err := rows.Scan(&name, &unique, &method, &column); err != nil {
+			return nil, fmt.Errorf("failed to scan index info: %v", err)
+		}
+		if n := len(indexes); n == 0 || indexes[n-1].Name != name {
+			indexes = append(indexes, IndexInfo{Name: name, Unique: unique, Type: strings.ToUpper(method)})
+		}
+		indexes[len(indexes)-1].Columns = append(indexes[len(indexes)-1].Columns, column)
+	}
+
+	return indexes, rows.Err()
+}
+
+// createIndex prefixes MySQL index names with the table name, since Postgres index
+// names must be unique across the schema
+func (d postgresDialect) createIndex(tableName string, idx IndexInfo) (string, error) {
+	if idx.Type == "FULLTEXT" || idx.Type == "SPATIAL" {
+		return "", fmt.Errorf("%s indexes have no Postgres equivalent", idx.Type)
+	}
+
+	name := idx.Name
+	if idx.Type == "BTREE" || idx.Type == "HASH" {
+		if !strings.HasPrefix(name, tableName+"_") {
+			name = tableName + "_" + name
+		}
+	}
+
+	columns := make([]string, len(idx.Columns))
+	for i, col := range idx.Columns {
+		// Postgres has no prefix indexes, index the whole column
+		col, _, _ = strings.Cut(col, "(")
+		columns[i] = d.quote(col)
+	}
+
+	unique := ""
+	if idx.Unique {
+		unique = "UNIQUE "
+	}
+	return fmt.Sprintf("CREATE %sINDEX %s ON %s (%s)",
+		unique, d.quote(name), d.quote(tableName), strings.Join(columns, ", ")), nil
+}
+
+// setForeignKeyChecks is a no-op: tables created in Postgres by the migrator carry no
+// foreign keys, and data is copied in dependency order
+func (postgresDialect) setForeignKeyChecks(context.Context, Querier, bool) error { return nil }
+
+// mysqlTypePattern splits a SHOW COLUMNS type such as "int(10) unsigned" or "enum('a','b')"
+var mysqlTypePattern = regexp.MustCompile(`^([a-z]+)(\(([^)]*)\))?(.*)$`)
+
+// columnType maps MySQL types to their closest Postgres equivalent; AUTO_INCREMENT
+// columns become identity columns, which still accept the copied key values
+func (postgresDialect) columnType(source dialect, info ColumnInfo) (string, error) {
+	if source.name() == driverPostgres {
+		return info.Type, nil
+	}
+
+	match := mysqlTypePattern.FindStringSubmatch(strings.ToLower(info.Type))
+	if match == nil {
+		return "", fmt.Errorf("unrecognized MySQL type %s", info.Type)
+	}
+	base, args := match[1], match[3]
+	unsigned := strings.Contains(match[4], "unsigned")
+
+	var typ string
+	switch base {
+	case "tinyint", "smallint", "year":
+		typ = "smallint"
+		if unsigned && base == "smallint" {
+			typ = "integer"
+		}
+	case "mediumint", "int", "integer":
+		typ = "integer"
+		if unsigned && base != "mediumint" {
+			typ = "bigint"
+		}
+	case "bigint":
+		typ = "bigint"
+		if unsigned {
+			typ = "numeric(20)"
+		}
+	case "decimal", "numeric":
+		typ = "numeric"
+		if args != "" {
+			typ += "(" + args + ")"
+		}
+	case "float":
+		typ = "real"
+	case "double", "real":
+		typ = "double precision"
+	case "char":
+		typ = "character(" + args + ")"
+	case "varchar":
+		typ = "character varying(" + args + ")"
+	case "tinytext", "text", "mediumtext", "longtext", "enum", "set":
+		typ = "text"
+	case "json":
+		typ = "jsonb"
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob", "bit",
+		"geometry", "point", "linestring", "polygon", "multipoint", "multilinestring", "multipolygon", "geometrycollection":
+		typ = "bytea"
+	case "date":
+		typ = "date"
+	case "datetime", "timestamp":
+		typ = "timestamp"
+		if args != "" {
+			typ += "(" + args + ")"
+		}
+	case "time":
+		typ = "time"
+		if args != "" {
+			typ += "(" + args + ")"
+		}
+	default:
+		return "", fmt.Errorf("no Postgres equivalent for MySQL type %s", info.Type)
+	}
+
+	if isAutoIncrement(info) {
+		typ += " GENERATED BY DEFAULT AS IDENTITY"
+	}
+	return typ, nil
+}
+
+// prepareValues passes the []byte values the MySQL driver returns for textual and
+// numeric columns as strings, so Postgres parses them instead of expecting bytea
+func (postgresDialect) prepareValues(columns []ColumnInfo, values []interface{}) {
+	for i, val := range values {
+		b, ok := val.([]byte)
+		if !ok || isBinaryType(columns[i].Type) {
+			continue
+		}
+		values[i] = string(b)
+	}
+}
+
+// isBinaryType reports whether a column holds raw bytes rather than text
+func isBinaryType(columnType string) bool {
+	columnType = strings.ToLower(columnType)
+	for _, marker := range []string{"blob", "binary", "bytea", "bit", "geometry", "point", "linestring", "polygon"} {
+		if strings.Contains(columnType, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// finishTable moves the identity sequences past the highest copied key, since
+// explicitly inserted values don't advance them
+func (d postgresDialect) finishTable(ctx context.Context, db Querier, tableName string, columns []ColumnInfo) error {
+	for _, col := range columns {
+		if !isAutoIncrement(col) {
+			continue
+		}
+		query := fmt.Sprintf("SELECT setval(pg_get_serial_sequence($1, $2), COALESCE(MAX(%s), 0) + 1, false) FROM %s",
+			d.quote(col.Name), d.quote(tableName))
+		if _, err := db.ExecContext(ctx, query, d.quote(tableName), col.Name); err != nil {
+			return fmt.Errorf("failed to reset sequence of %s.%s: %v", tableName, col.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// defaultProfileSampleSize is the number of rows ProfileTables reads per table when ProfileSampleSize is unset
+const defaultProfileSampleSize = 1000
+
+// largeValueThreshold flags columns holding values big enough to slow the copy down
+const largeValueThreshold = 1 << 20
+
+// TableProfile summarizes a sample of a source table
+type TableProfile struct {
+	Table       string
+	TotalRows   int
+	SampledRows int
+	Columns     []ColumnProfile
+}
+
+// ColumnProfile summarizes the sampled values of one column
+type ColumnProfile struct {
+	Name      string
+	Type      string
+	NullRate  float64
+	ZeroDates int
+	MaxLength int
+	// DistinctEstimate counts the distinct values in the sample
+	DistinctEstimate int
+}
+
+// ProfileTables samples every source table and reports per-column statistics, to spot
+// all-NULL columns, zero dates, large values and high-cardinality text before migrating.
+// It only reads from the source.
+func (dm *DatabaseMigrator) ProfileTables() ([]TableProfile, error) {
+	tables, err := dm.GetTables()
+	if err != nil {
+		return nil, err
+	}
+
+	sampleSize := dm.config.ProfileSampleSize
+	if sampleSize <= 0 {
+		sampleSize = defaultProfileSampleSize
+	}
+
+	var profiles []TableProfile
+	for _, tableName := range tables {
+		profile, err := dm.profileTable(tableName, sampleSize)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, profile)
+		dm.logProfile(profile)
+	}
+
+	return profiles, nil
+}
+
+func (dm *DatabaseMigrator) profileTable(tableName string, sampleSize int) (TableProfile, error) {
+	profile := TableProfile{Table: tableName}
+
+	infos, err := dm.GetTableColumnInfo(tableName)
+	if err != nil {
+		return profile, err
+	}
+
+	profile.TotalRows, err = dm.sourceRowCount(tableName)
+	if err != nil {
+		return profile, err
+	}
+
+	columns := make([]string, len(infos))
+	for i, info := range infos {
+		columns[i] = info.Name
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s LIMIT %d", quoteList(dm.source, columns), dm.source.quote(tableName), sampleSize)
+	ctx, cancel := dm.queryContext()
+	defer cancel()
+	rows, err := dm.sourceDB.QueryContext(ctx, query)
+	if err != nil {
+		return profile, fmt.Errorf("failed to sample table %s: %v", tableName, err)
+	}
+	defer rows.Close()
+
+	nulls := make([]int, len(columns))
+	zeroDates := make([]int, len(columns))
+	maxLengths := make([]int, len(columns))
+	distinct := make([]map[uint64]struct{}, len(columns))
+	for i := range distinct {
+		distinct[i] = make(map[uint64]struct{})
+	}
+
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		valuePtrs := make([]interface{}, len(columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return profile, fmt.Errorf("failed to scan row: %v", err)
+		}
+		profile.SampledRows++
+
+		for i, val := range values {
+			if val == nil {
+				nulls[i]++
+				continue
+			}
+			if isZeroDate(val) {
+				zeroDates[i]++
+			}
+
+			text := profileText(val)
+			maxLengths[i] = max(maxLengths[i], len(text))
+
+			h := fnv.New64a()
+			h.Write([]byte(text))
+			distinct[i][h.Sum64()] = struct{}{}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return profile, fmt.Errorf("failed to sample table %s: %v", tableName, err)
+	}
+
+	for i, info := range infos {
+		col := ColumnProfile{
+			Name:             info.Name,
+			Type:             info.Type,
+			ZeroDates:        zeroDates[i],
+			MaxLength:        maxLengths[i],
+			DistinctEstimate: len(distinct[i]),
+		}
+		if profile.SampledRows > 0 {
+			col.NullRate = float64(nulls[i]) / float64(profile.SampledRows)
+		}
+		profile.Columns = append(profile.Columns, col)
+	}
+
+	return profile, nil
+}
+
+func profileText(val interface{}) string {
+	switch v := val.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.999999")
+	}
+	return fmt.Sprint(val)
+}
+
+func (dm *DatabaseMigrator) logProfile(profile TableProfile) {
+	dm.logger.Log(fmt.Sprintf("Profile of table %s: sampled %d of %d rows",
+		profile.Table, profile.SampledRows, profile.TotalRows))
+
+	if profile.SampledRows == 0 {
+		return
+	}
+
+	for _, col := range profile.Columns {
+		var notes []string
+		if col.NullRate == 1 {
+			notes = append(notes, "all NULL")
+		}
+		if col.ZeroDates > 0 {
+			notes = append(notes, fmt.Sprintf("%d zero dates", col.ZeroDates))
+		}
+		if col.MaxLength >= largeValueThreshold {
+			notes = append(notes, fmt.Sprintf("large values up to %d bytes", col.MaxLength))
+		}
+		isText := strings.Contains(col.Type, "char") || strings.Contains(col.Type, "text")
+		if isText && profile.SampledRows > 1 && col.DistinctEstimate == profile.SampledRows {
+			notes = append(notes, "high-cardinality text")
+		}
+
+		line := fmt.Sprintf("  %s %s: %.1f%% NULL, max length %d, ~%d distinct",
+			col.Name, col.Type, col.NullRate*100, col.MaxLength, col.DistinctEstimate)
+		if len(notes) > 0 {
+			line += " [" + strings.Join(notes, ", ") + "]"
+		}
+		dm.logger.Log(line)
+	}
+}
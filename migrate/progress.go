@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	applog "github.com/duymanh3602/migrate-tool/internal/log"
+)
+
+const progressBarWidth = 30
+
+// tableProgress renders the progress of one table as the logger's status line
+type tableProgress struct {
+	logger *applog.Logger
+	table  string
+	total  int
+	// initial rows were copied by a previous run and do not count towards the rate
+	initial int
+	start   time.Time
+}
+
+// isTerminal reports whether f is an interactive terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// newTableProgress returns the progress bar of a table, or nil when Progress is off or stdout is not a terminal
+func (dm *DatabaseMigrator) newTableProgress(tableName string, totalRows, initial int) *tableProgress {
+	if !dm.config.Progress || !isTerminal(os.Stdout) {
+		return nil
+	}
+	return &tableProgress{logger: dm.logger, table: tableName, total: totalRows, initial: initial, start: time.Now()}
+}
+
+// update redraws the bar with done of the total rows copied
+func (p *tableProgress) update(done int) {
+	done = min(done, p.total)
+	fraction := float64(done) / float64(p.total)
+	filled := int(fraction * progressBarWidth)
+
+	elapsed := time.Since(p.start)
+	rate := float64(done-p.initial) / elapsed.Seconds()
+	eta := "?"
+	if rate > 0 {
+		eta = time.Duration(float64(p.total-done) / rate * float64(time.Second)).Round(time.Second).String()
+	}
+
+	p.logger.SetStatus(fmt.Sprintf("%s [%s%s] %d/%d %.1f%% %.0f rows/s ETA %s",
+		p.table, strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled),
+		done, p.total, fraction*100, rate, eta))
+}
+
+// finish removes the bar once the table is done
+func (p *tableProgress) finish() {
+	p.logger.SetStatus("")
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Querier is the database access the migrator uses. *sql.DB implements it, and tests can
+// pass their own implementation to NewDatabaseMigratorWithDB.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	PingContext(ctx context.Context) error
+	Close() error
+}
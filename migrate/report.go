@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TableReport is what Migrate copied of one table
+type TableReport struct {
+	Table        string
+	RowsMigrated int
+	// RowsSkipped already existed in the destination under SkipExistingRows
+	RowsSkipped int
+	// RowsRejected were written to FailedRowsFile under ContinueOnError
+	RowsRejected int
+	// Bytes estimates the size of the copied values
+	Bytes    int64
+	Duration time.Duration
+}
+
+// MigrationReport summarizes a Migrate run. When Migrate fails it holds the tables copied so far.
+type MigrationReport struct {
+	// Tables lists the copied tables in the order they finished
+	Tables []TableReport
+	// SkippedTables counts the source tables left out by SkipTables or OnlyTables and the
+	// tables a resumed migration had already completed
+	SkippedTables int
+	RowsMigrated  int
+	FailedRows    int
+	Bytes         int64
+	StartedAt     time.Time
+	Duration      time.Duration
+}
+
+func (r MigrationReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Migrated %d rows (~%s) of %d tables in %v", r.RowsMigrated, formatBytes(r.Bytes), len(r.Tables), r.Duration.Round(time.Millisecond))
+	if r.SkippedTables > 0 {
+		fmt.Fprintf(&b, ", %d tables skipped", r.SkippedTables)
+	}
+	if r.FailedRows > 0 {
+		fmt.Fprintf(&b, ", %d rows failed", r.FailedRows)
+	}
+	for _, t := range r.Tables {
+		fmt.Fprintf(&b, "\n  %s: %d rows (~%s) in %v", t.Table, t.RowsMigrated, formatBytes(t.Bytes), t.Duration.Round(time.Millisecond))
+		if t.RowsSkipped > 0 {
+			fmt.Fprintf(&b, ", %d skipped", t.RowsSkipped)
+		}
+		if t.RowsRejected > 0 {
+			fmt.Fprintf(&b, ", %d rejected", t.RowsRejected)
+		}
+	}
+	return b.String()
+}
+
+// reportCollector gathers the TableReports of the tables copied concurrently
+type reportCollector struct {
+	mu            sync.Mutex
+	tables        []TableReport
+	skippedTables int
+}
+
+func (c *reportCollector) addTable(t TableReport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tables = append(c.tables, t)
+}
+
+func (c *reportCollector) skipTables(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.skippedTables += n
+}
+
+func (c *reportCollector) build(startTime time.Time) MigrationReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := MigrationReport{
+		Tables:        append([]TableReport(nil), c.tables...),
+		SkippedTables: c.skippedTables,
+		StartedAt:     startTime,
+		Duration:      time.Since(startTime),
+	}
+	for _, t := range c.tables {
+		report.RowsMigrated += t.RowsMigrated
+		report.FailedRows += t.RowsRejected
+		report.Bytes += t.Bytes
+	}
+	return report
+}
+
+// valueSize estimates the bytes a scanned value takes on the wire
+func valueSize(val interface{}) int64 {
+	switch v := val.(type) {
+	case nil:
+		return 0
+	case []byte:
+		return int64(len(v))
+	case string:
+		return int64(len(v))
+	case bool:
+		return 1
+	}
+	// numbers and times
+	return 8
+}
+
+func rowSize(values []interface{}) int64 {
+	var size int64
+	for _, v := range values {
+		size += valueSize(v)
+	}
+	return size
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
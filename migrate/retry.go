@@ -0,0 +1,16 @@
+package main
+
+import (
+	appdb "github.com/duymanh3602/migrate-tool/internal/db"
+)
+
+// connectWithRetry pings db until it answers, retrying transient failures up to ConnectMaxAttempts
+// times with an exponential backoff starting at ConnectRetryDelay
+func (dm *DatabaseMigrator) connectWithRetry(name string, db Querier) error {
+	retry := appdb.Retry{
+		MaxAttempts: dm.config.ConnectMaxAttempts,
+		Delay:       dm.config.ConnectRetryDelay,
+		Warn:        dm.logger.Warn,
+	}
+	return appdb.ConnectWithRetry(dm.ctx, name+" database", retry, db.PingContext, appdb.IsTransientSQLError)
+}
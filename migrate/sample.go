@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// sampleBuckets is the resolution of the hash-based sampling clause
+	sampleBuckets = 1000000
+
+	// defaultSampleMaxReferencedKeys is the SampleMaxReferencedKeys of a config leaving it 0
+	defaultSampleMaxReferencedKeys = 10000
+
+	// maxSampleArgs keeps a sampling condition under the 65535 bind parameters MySQL and
+	// Postgres accept in a statement, with room for the arguments the queries add to it
+	maxSampleArgs = 60000
+)
+
+// sampledKeySet holds the distinct values of a referenced column among the sampled rows, up to a limit
+type sampledKeySet struct {
+	seen    map[interface{}]struct{}
+	keys    []interface{}
+	limit   int
+	dropped int
+}
+
+func newSampledKeySet(limit int) *sampledKeySet {
+	return &sampledKeySet{seen: make(map[interface{}]struct{}), limit: limit}
+}
+
+func (s *sampledKeySet) add(value interface{}) {
+	// []byte values can't be map keys, compare them as strings
+	key := value
+	if b, ok := value.([]byte); ok {
+		key = string(b)
+	}
+	if _, ok := s.seen[key]; ok {
+		return
+	}
+	if len(s.keys) >= s.limit {
+		s.dropped++
+		return
+	}
+	s.seen[key] = struct{}{}
+	s.keys = append(s.keys, value)
+}
+
+// GetPrimaryKeyColumns retrieves the primary key column names of a table in key order
+func (dm *DatabaseMigrator) GetPrimaryKeyColumns(tableName string) ([]string, error) {
+	ctx, cancel := dm.queryContext()
+	defer cancel()
+	return dm.source.primaryKey(ctx, dm.sourceDB, tableName)
+}
+
+// sampleClause builds the WHERE condition selecting the sampled subset of a table.
+// It returns an empty condition when the table is not sampled.
+//
+// Rows are picked by hashing the primary key (or every column when there is no
+// primary key) together with SampleSeed, so the same seed always selects the same
+// rows and the count query agrees with the paginated selects.
+func (dm *DatabaseMigrator) sampleClause(tableName string, columns []string) (string, []interface{}, error) {
+	rate, ok := dm.config.SampleRates[tableName]
+	if !ok || rate >= 1 {
+		return "", nil, nil
+	}
+	if rate < 0 {
+		return "", nil, fmt.Errorf("invalid sample rate %v for table %s: must be between 0 and 1", rate, tableName)
+	}
+
+	hashColumns, err := dm.GetPrimaryKeyColumns(tableName)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(hashColumns) == 0 {
+		hashColumns = columns
+	}
+
+	// The seed is hashed as text, which MySQL and Postgres concatenate alike
+	hash, err := dm.source.sampleHash(dm.source.placeholder(1), hashColumns)
+	if err != nil {
+		return "", nil, err
+	}
+	clause := fmt.Sprintf("MOD(%s, %d) < %s", hash, sampleBuckets, dm.source.placeholder(2))
+	args := []interface{}{strconv.FormatInt(dm.config.SampleSeed, 10), int64(rate * sampleBuckets)}
+
+	// Pull in the rows pointing at parent rows that were sampled before this table
+	if dm.config.SampleFollowReferences {
+		dm.sampleMu.Lock()
+		defer dm.sampleMu.Unlock()
+		for _, fk := range dm.sampleRefs[tableName] {
+			set := dm.sampledKeys[fk.ReferencedTable][fk.ReferencedColumn]
+			if set == nil || len(set.keys) == 0 {
+				continue
+			}
+			keys := set.keys
+			if room := max(maxSampleArgs-len(args), 0); len(keys) > room {
+				dm.logger.Warn(fmt.Sprintf("Table %s: following %d of the %d sampled %s.%s keys, a statement takes at most %d parameters",
+					tableName, room, len(keys), fk.ReferencedTable, fk.ReferencedColumn, maxSampleArgs))
+				keys = keys[:room]
+			}
+			if set.dropped > 0 {
+				dm.logger.Warn(fmt.Sprintf("Table %s: %d more sampled %s.%s values were past sampleMaxReferencedKeys and are not followed",
+					tableName, set.dropped, fk.ReferencedTable, fk.ReferencedColumn))
+			}
+			if len(keys) == 0 {
+				continue
+			}
+			placeholders := make([]string, len(keys))
+			for i := range keys {
+				placeholders[i] = dm.source.placeholder(len(args) + i + 1)
+			}
+			clause += fmt.Sprintf(" OR %s IN (%s)", dm.source.quote(fk.ColumnName), strings.Join(placeholders, ", "))
+			args = append(args, keys...)
+		}
+	}
+
+	return clause, args, nil
+}
+
+// prepareSampling records, for every sampled table, which of its columns are
+// referenced by other sampled tables so MigrateTableData can keep their values
+func (dm *DatabaseMigrator) prepareSampling(tables []string) error {
+	dm.sampleRefs = make(map[string][]ForeignKeyInfo)
+	dm.sampledKeys = make(map[string]map[string]*sampledKeySet)
+	limit := dm.config.SampleMaxReferencedKeys
+	if limit == 0 {
+		limit = defaultSampleMaxReferencedKeys
+	}
+
+	if len(dm.config.SampleRates) == 0 || !dm.config.SampleFollowReferences {
+		return nil
+	}
+
+	for _, tableName := range tables {
+		if _, ok := dm.config.SampleRates[tableName]; !ok {
+			continue
+		}
+
+		fks, err := dm.GetTableForeignKeys(tableName)
+		if err != nil {
+			return err
+		}
+
+		for _, fk := range fks {
+			if _, ok := dm.config.SampleRates[fk.ReferencedTable]; !ok || fk.ReferencedTable == tableName {
+				continue
+			}
+			dm.sampleRefs[tableName] = append(dm.sampleRefs[tableName], fk)
+			if dm.sampledKeys[fk.ReferencedTable] == nil {
+				dm.sampledKeys[fk.ReferencedTable] = make(map[string]*sampledKeySet)
+			}
+			if dm.sampledKeys[fk.ReferencedTable][fk.ReferencedColumn] == nil {
+				dm.sampledKeys[fk.ReferencedTable][fk.ReferencedColumn] = newSampledKeySet(limit)
+			}
+		}
+	}
+
+	return nil
+}
+
+// recordSampledKeys keeps the referenced column values of a migrated sampled row
+func (dm *DatabaseMigrator) recordSampledKeys(tableName string, columns []string, values []interface{}) {
+	dm.sampleMu.Lock()
+	defer dm.sampleMu.Unlock()
+	keyColumns, ok := dm.sampledKeys[tableName]
+	if !ok {
+		return
+	}
+
+	for i, col := range columns {
+		if set, ok := keyColumns[col]; ok && values[i] != nil {
+			set.add(values[i])
+		}
+	}
+}
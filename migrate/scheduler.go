@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// stepResult is the outcome of one step run by runWithDependencies
+type stepResult struct {
+	step string
+	err  error
+}
+
+// runWithDependencies runs step for every entry of steps using up to workers goroutines.
+// A step only starts once all the steps it depends on have finished, and steps that
+// become ready are started in the order they appear in steps. The first error cancels
+// ctx, no further steps are started, and it is returned once the running steps return.
+func runWithDependencies(ctx context.Context, steps []string, dependencies map[string][]string, workers int,
+	step func(ctx context.Context, s string) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	position := make(map[string]int, len(steps))
+	for i, s := range steps {
+		position[s] = i
+	}
+
+	// Only dependencies on steps that are part of this run have to be waited for
+	pending := make(map[string]int, len(steps))
+	dependents := make(map[string][]string)
+	var ready []string
+	for _, s := range steps {
+		for _, dep := range dependencies[s] {
+			if _, ok := position[dep]; ok && dep != s {
+				pending[s]++
+				dependents[dep] = append(dependents[dep], s)
+			}
+		}
+		if pending[s] == 0 {
+			ready = append(ready, s)
+		}
+	}
+
+	results := make(chan stepResult)
+	running := 0
+	var firstErr error
+
+	for running > 0 || (firstErr == nil && len(ready) > 0) {
+		for firstErr == nil && running < workers && len(ready) > 0 {
+			s := ready[0]
+			ready = ready[1:]
+			running++
+			go func() {
+				results <- stepResult{step: s, err: step(ctx, s)}
+			}()
+		}
+
+		result := <-results
+		running--
+		if result.err != nil {
+			if firstErr == nil {
+				firstErr = result.err
+				cancel()
+			}
+			continue
+		}
+
+		for _, next := range dependents[result.step] {
+			pending[next]--
+			if pending[next] == 0 {
+				ready = insertByPosition(ready, next, position)
+			}
+		}
+	}
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range steps {
+		if pending[s] > 0 {
+			return fmt.Errorf("step %s never became ready, its dependencies form a cycle", s)
+		}
+	}
+	return nil
+}
+
+// insertByPosition adds s to the ready queue, keeping the queue in the original step order
+func insertByPosition(ready []string, s string, position map[string]int) []string {
+	i := len(ready)
+	for i > 0 && position[ready[i-1]] > position[s] {
+		i--
+	}
+	ready = append(ready, "")
+	copy(ready[i+1:], ready[i:])
+	ready[i] = s
+	return ready
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// a column definition line of SHOW CREATE TABLE carrying an SRID constraint,
+	// either plain or inside the /*!80003 ... */ versioned comment MySQL 8 emits, matched case-insensitively
+	sridColumnPattern = regexp.MustCompile("(?mi)^\\s*`([^`]+)`\\s+(geometry|point|linestring|polygon|multipoint|multilinestring|multipolygon|geometrycollection|geomcollection)\\b.*?\\bSRID\\s+(\\d+)")
+	sridClausePattern = regexp.MustCompile(`(?i)\s*(/\*!80003\s+SRID\s+\d+\s*\*/|\bSRID\s+\d+)`)
+)
+
+// spatialSRIDs returns the SRID constraint of every constrained spatial column in a CREATE TABLE statement
+func spatialSRIDs(createStmt string) map[string]uint32 {
+	srids := make(map[string]uint32)
+	for _, match := range sridColumnPattern.FindAllStringSubmatch(createStmt, -1) {
+		srid, err := strconv.ParseUint(match[3], 10, 32)
+		if err != nil {
+			continue
+		}
+		srids[match[1]] = uint32(srid)
+	}
+	return srids
+}
+
+// destinationSupportsSRID reports whether the destination server accepts SRID column
+// constraints, which were added in MySQL 8.0.3
+func (dm *DatabaseMigrator) destinationSupportsSRID() (bool, error) {
+	dm.sridMu.Lock()
+	defer dm.sridMu.Unlock()
+	if dm.destSupportsSRID != nil {
+		return *dm.destSupportsSRID, nil
+	}
+
+	var version string
+	ctx, cancel := dm.queryContext()
+	defer cancel()
+	if err := dm.destDB.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version); err != nil {
+		return false, fmt.Errorf("failed to get destination version: %v", err)
+	}
+
+	supported := false
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) == 3 && !strings.Contains(strings.ToLower(version), "mariadb") {
+		major, _ := strconv.Atoi(parts[0])
+		minor, _ := strconv.Atoi(parts[1])
+		patch, _ := strconv.Atoi(leadingDigits(parts[2]))
+		supported = major > 8 || (major == 8 && (minor > 0 || patch >= 3))
+	}
+
+	dm.destSupportsSRID = &supported
+	return supported, nil
+}
+
+func leadingDigits(s string) string {
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	return s[:end]
+}
+
+// rewriteSpatialDDL strips SRID constraints from a CREATE TABLE statement when the
+// destination does not support them
+func (dm *DatabaseMigrator) rewriteSpatialDDL(tableName, createStmt string) (string, error) {
+	srids := spatialSRIDs(createStmt)
+	if len(srids) == 0 {
+		return createStmt, nil
+	}
+
+	supported, err := dm.destinationSupportsSRID()
+	if err != nil {
+		return "", err
+	}
+	if supported {
+		return createStmt, nil
+	}
+
+	for column, srid := range srids {
+		dm.logger.Warn(fmt.Sprintf("Destination does not support SRID constraints, dropping SRID %d from %s.%s",
+			srid, tableName, column))
+	}
+	return sridClausePattern.ReplaceAllString(createStmt, ""), nil
+}
+
+// spatialColumnSRIDs maps the index of every SRID-constrained column to its SRID,
+// or returns nil when the destination will not enforce the constraint
+func (dm *DatabaseMigrator) spatialColumnSRIDs(tableName string, columns []string) (map[int]uint32, error) {
+	createStmt, err := dm.GetTableSchema(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	srids := spatialSRIDs(createStmt)
+	if len(srids) == 0 {
+		return nil, nil
+	}
+
+	supported, err := dm.destinationSupportsSRID()
+	if err != nil || !supported {
+		return nil, err
+	}
+
+	byIndex := make(map[int]uint32)
+	for i, col := range columns {
+		if srid, ok := srids[col]; ok {
+			byIndex[i] = srid
+		}
+	}
+	return byIndex, nil
+}
+
+// applySRIDs makes geometry values carry the SRID of their column. MySQL geometry values are
+// a 4-byte little-endian SRID followed by WKB, and a constrained column rejects any other SRID.
+func applySRIDs(srids map[int]uint32, values []interface{}) {
+	for i, srid := range srids {
+		geom, ok := values[i].([]byte)
+		if !ok || len(geom) < 4 || binary.LittleEndian.Uint32(geom[:4]) == srid {
+			continue
+		}
+		fixed := make([]byte, len(geom))
+		copy(fixed, geom)
+		binary.LittleEndian.PutUint32(fixed[:4], srid)
+		values[i] = fixed
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	applog "github.com/duymanh3602/migrate-tool/internal/log"
+)
+
+const spatialTableDDL = "CREATE TABLE `places` (\n" +
+	"  `id` int NOT NULL AUTO_INCREMENT,\n" +
+	"  `location` point NOT NULL /*!80003 SRID 4326 */,\n" +
+	"  `area` polygon NOT NULL SRID 3857,\n" +
+	"  `shape` geometry DEFAULT NULL,\n" +
+	"  PRIMARY KEY (`id`),\n" +
+	"  SPATIAL KEY `location` (`location`)\n" +
+	") ENGINE=InnoDB"
+
+func TestSpatialSRIDs(t *testing.T) {
+	got := spatialSRIDs(spatialTableDDL)
+	if want := map[string]uint32{"location": 4326, "area": 3857}; !reflect.DeepEqual(got, want) {
+		t.Errorf("spatialSRIDs() = %v, want %v", got, want)
+	}
+}
+
+func TestRewriteSpatialDDL(t *testing.T) {
+	withoutSRID := "CREATE TABLE `shapes` (\n  `shape` geometry DEFAULT NULL\n) ENGINE=InnoDB"
+	stripped := strings.NewReplacer(" /*!80003 SRID 4326 */", "", " SRID 3857", "").Replace(spatialTableDDL)
+
+	tests := []struct {
+		name      string
+		supported bool
+		ddl       string
+		want      string
+	}{
+		{name: "destination without SRID support", supported: false, ddl: spatialTableDDL, want: stripped},
+		{name: "destination with SRID support", supported: true, ddl: spatialTableDDL, want: spatialTableDDL},
+		{name: "column without SRID", supported: false, ddl: withoutSRID, want: withoutSRID},
+		{name: "plain POINT NOT NULL SRID 4326", supported: false,
+			ddl:  "CREATE TABLE `t` (\n  `p` POINT NOT NULL SRID 4326\n)",
+			want: "CREATE TABLE `t` (\n  `p` POINT NOT NULL\n)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			supported := tt.supported
+			dm := &DatabaseMigrator{logger: applog.NewConsole(applog.LevelError), destSupportsSRID: &supported}
+			got, err := dm.rewriteSpatialDDL("t", tt.ddl)
+			if err != nil {
+				t.Fatalf("rewriteSpatialDDL() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("rewriteSpatialDDL() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// geometryValue is a MySQL geometry value: the SRID, then a WKB point
+func geometryValue(srid uint32) []byte {
+	value := binary.LittleEndian.AppendUint32(nil, srid)
+	value = append(value, 0x01)                                         // little-endian WKB
+	value = binary.LittleEndian.AppendUint32(value, 1)                  // point
+	value = binary.LittleEndian.AppendUint64(value, 0x4024000000000000) // x = 10
+	return binary.LittleEndian.AppendUint64(value, 0x4034000000000000)  // y = 20
+}
+
+func TestApplySRIDs(t *testing.T) {
+	source := geometryValue(0)
+	matching := geometryValue(4326)
+	values := []interface{}{source, matching, nil, []byte{0x01}}
+
+	applySRIDs(map[int]uint32{0: 4326, 1: 4326, 2: 4326, 3: 4326}, values)
+
+	got, ok := values[0].([]byte)
+	if !ok || binary.LittleEndian.Uint32(got[:4]) != 4326 {
+		t.Fatalf("value = %v, want SRID 4326", values[0])
+	}
+	if !bytes.Equal(got[4:], source[4:]) {
+		t.Errorf("WKB = %x, want %x unchanged", got[4:], source[4:])
+	}
+	if binary.LittleEndian.Uint32(source[:4]) != 0 {
+		t.Error("the scanned value was modified in place")
+	}
+	if !bytes.Equal(values[1].([]byte), matching) {
+		t.Errorf("value with the column SRID = %x, want %x", values[1], matching)
+	}
+	if values[2] != nil || !bytes.Equal(values[3].([]byte), []byte{0x01}) {
+		t.Errorf("NULL and short values = %v, %v, want them unchanged", values[2], values[3])
+	}
+}
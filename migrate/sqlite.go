@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteDialect writes a snapshot into a single SQLite file, named by DatabaseConfig.Database.
+// It is only supported as a destination.
+type sqliteDialect struct{}
+
+func (sqliteDialect) name() string { return driverSQLite }
+
+// open allows a single connection, since SQLite serializes writers and concurrent ones fail with SQLITE_BUSY
+func (sqliteDialect) open(cfg DatabaseConfig) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", cfg.Database)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+func (sqliteDialect) quote(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+func (sqliteDialect) placeholder(int) string { return "?" }
+
+func (sqliteDialect) randomOrder() string { return "random()" }
+
+// sampleHash fails, SQLite is only a destination and has no hash function to sample it with
+func (sqliteDialect) sampleHash(string, []string) (string, error) {
+	return "", fmt.Errorf("SQLite has no hash function to sample rows with")
+}
+
+// upsert keeps the existing row, which for a resumed table holds the same source values
+func (d sqliteDialect) upsert(tableName string, columns []string, placeholders string) string {
+	return fmt.Sprintf("INSERT OR IGNORE INTO %s (%s) VALUES (%s)", d.quote(tableName), quoteList(d, columns), placeholders)
+}
+
+// truncate deletes the rows, SQLite has no TRUNCATE but optimizes an unconditional DELETE the same way
+func (d sqliteDialect) truncate(tableName string) string {
+	return "DELETE FROM " + d.quote(tableName)
+}
+
+func (sqliteDialect) listTables(ctx context.Context, db Querier) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tables: %v", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			return nil, fmt.Errorf("failed to scan table name: %v", err)
+		}
+		tables = append(tables, tableName)
+	}
+	return tables, rows.Err()
+}
+
+// sqliteColumn is a row of PRAGMA table_info
+type sqliteColumn struct {
+	name       string
+	typ        string
+	notNull    bool
+	defaultVal sql.NullString
+	pk         int
+}
+
+func (d sqliteDialect) tableInfo(ctx context.Context, db Querier, tableName string) ([]sqliteColumn, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", d.quote(tableName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns for table %s: %v", tableName, err)
+	}
+	defer rows.Close()
+
+	var columns []sqliteColumn
+	for rows.Next() {
+		var cid int
+		var col sqliteColumn
+		if err := rows.Scan(&cid, &col.name, &col.typ, &col.notNull, &col.defaultVal, &col.pk); err != nil {
+			return nil, fmt.Errorf("failed to scan column info: %v", err)
+		}
+		columns = append(columns, col)
+	}
+	return columns, rows.Err()
+}
+
+func (d sqliteDialect) columnInfo(ctx context.Context, db Querier, tableName string) ([]ColumnInfo, error) {
+	cols, err := d.tableInfo(ctx, db, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]ColumnInfo, len(cols))
+	for i, col := range cols {
+		columns[i] = ColumnInfo{Name: col.name, Type: col.typ, Nullable: !col.notNull, Default: col.defaultVal}
+		if col.pk > 0 {
+			columns[i].Key = "PRI"
+		}
+	}
+	return columns, nil
+}
+
+func (d sqliteDialect) primaryKey(ctx context.Context, db Querier, tableName string) ([]string, error) {
+	cols, err := d.tableInfo(ctx, db, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	// pk is the 1-based position of the column in the primary key
+	var keys []string
+	for pos := 1; ; pos++ {
+		found := false
+		for _, col := range cols {
+			if col.pk == pos {
+				keys = append(keys, col.name)
+				found = true
+			}
+		}
+		if !found {
+			return keys, nil
+		}
+	}
+}
+
+// foreignKeys returns none: tables created in SQLite by the migrator carry no foreign keys
+func (sqliteDialect) foreignKeys(context.Context, Querier, string, string) ([]ForeignKeyInfo, error) {
+	return nil, nil
+}
+
+// indexes reads the indexes created with CREATE INDEX, leaving out the automatic ones backing constraints
+func (d sqliteDialect) indexes(ctx context.Context, db Querier, tableName string) ([]IndexInfo, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA index_list(%s)", d.quote(tableName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get indexes for table %s: %v", tableName, err)
+	}
+
+	var indexes []IndexInfo
+	for rows.Next() {
+		var seq int
+		var name, origin string
+		var unique, partial bool
+		if err := rows.Scan(&seq, &name, &unique, &origin, &partial); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan index info: %v", err)
+		}
+		if origin == "c" && !partial {
+			indexes = append(indexes, IndexInfo{Name: name, Unique: unique, Type: "BTREE"})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get indexes for table %s: %v", tableName, err)
+	}
+
+	for i := range indexes {
+		cols, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA index_info(%s)", d.quote(indexes[i].Name)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get columns of index %s: %v", indexes[i].Name, err)
+		}
+		for cols.Next() {
+			var seqno, cid int
+			var column sql.NullString
+			if err := cols.Scan(&seqno, &cid, &column); err != nil {
+				cols.Close()
+				return nil, fmt.Errorf("failed to scan index column: %v", err)
+			}
+			indexes[i].Columns = append(indexes[i].Columns, column.String)
+		}
+		cols.Close()
+	}
+	return indexes, nil
+}
+
+// createIndex prefixes index names with the table name, since SQLite index names must be unique
+// across the database
+func (d sqliteDialect) createIndex(tableName string, idx IndexInfo) (string, error) {
+	if idx.Type == "FULLTEXT" || idx.Type == "SPATIAL" {
+		return "", fmt.Errorf("%s indexes have no SQLite equivalent", idx.Type)
+	}
+
+	name := idx.Name
+	if !strings.HasPrefix(name, tableName+"_") {
+		name = tableName + "_" + name
+	}
+
+	columns := make([]string, len(idx.Columns))
+	for i, col := range idx.Columns {
+		// SQLite has no prefix indexes, index the whole column
+		col, _, _ = strings.Cut(col, "(")
+		columns[i] = d.quote(col)
+	}
+
+	unique := ""
+	if idx.Unique {
+		unique = "UNIQUE "
+	}
+	return fmt.Sprintf("CREATE %sINDEX %s ON %s (%s)",
+		unique, d.quote(name), d.quote(tableName), strings.Join(columns, ", ")), nil
+}
+
+// setForeignKeyChecks is a no-op: SQLite only enforces foreign keys when enabled per connection,
+// and the tables created by the migrator carry none
+func (sqliteDialect) setForeignKeyChecks(context.Context, Querier, bool) error { return nil }
+
+// columnType maps a source type to the SQLite type with the same affinity. Dates and times are
+// stored as TEXT. An INTEGER primary key already takes the next rowid, so AUTO_INCREMENT is dropped.
+func (sqliteDialect) columnType(_ dialect, info ColumnInfo) (string, error) {
+	typ := strings.ToLower(info.Type)
+	base, _, _ := strings.Cut(typ, "(")
+	base = strings.TrimSpace(base)
+
+	switch {
+	case isBinaryType(typ):
+		return "BLOB", nil
+	case base == "year" || base == "boolean" || strings.Contains(base, "int") && base != "interval":
+		return "INTEGER", nil
+	case strings.Contains(base, "float") || strings.Contains(base, "double") || base == "real":
+		return "REAL", nil
+	case base == "decimal" || base == "numeric":
+		return "NUMERIC", nil
+	}
+	return "TEXT", nil
+}
+
+// prepareValues passes the []byte values the MySQL driver returns for textual and numeric
+// columns as strings, so SQLite doesn't store them as blobs
+func (sqliteDialect) prepareValues(columns []ColumnInfo, values []interface{}) {
+	for i, val := range values {
+		b, ok := val.([]byte)
+		if !ok || isBinaryType(columns[i].Type) {
+			continue
+		}
+		values[i] = string(b)
+	}
+}
+
+func (sqliteDialect) finishTable(context.Context, Querier, string, []ColumnInfo) error { return nil }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"time"
+)
+
+// version is the release the tool was built from, set with -ldflags "-X main.version=v1.2.3".
+// Unset, the module version recorded by go install is reported.
+var version string
+
+func toolVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "devel"
+}
+
+// Outcomes of a run written to the summary
+const (
+	summaryCompleted   = "completed"
+	summaryWithErrors  = "completedWithErrors"
+	summaryInterrupted = "interrupted"
+	summaryFailed      = "failed"
+)
+
+// tableSummary is a TableReport in the JSON summary
+type tableSummary struct {
+	Table           string  `json:"table"`
+	RowsMigrated    int     `json:"rowsMigrated"`
+	RowsSkipped     int     `json:"rowsSkipped"`
+	RowsRejected    int     `json:"rowsRejected"`
+	Bytes           int64   `json:"bytes"`
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+// migrationSummary is the JSON summary of a run written by -summary-json, for automation
+type migrationSummary struct {
+	Version         string         `json:"version"`
+	Status          string         `json:"status"`
+	Error           string         `json:"error,omitempty"`
+	StartedAt       time.Time      `json:"startedAt"`
+	FinishedAt      time.Time      `json:"finishedAt"`
+	DurationSeconds float64        `json:"durationSeconds"`
+	RowsMigrated    int            `json:"rowsMigrated"`
+	FailedRows      int            `json:"failedRows"`
+	Bytes           int64          `json:"bytes"`
+	SkippedTables   int            `json:"skippedTables"`
+	Tables          []tableSummary `json:"tables"`
+}
+
+// writeSummaryJSON writes the report of a Migrate run that ended with err to path, interrupted
+// telling whether it was stopped by a signal
+func writeSummaryJSON(path string, report MigrationReport, err error, interrupted bool) error {
+	summary := migrationSummary{
+		Version:         toolVersion(),
+		Status:          summaryCompleted,
+		StartedAt:       report.StartedAt,
+		FinishedAt:      report.StartedAt.Add(report.Duration),
+		DurationSeconds: report.Duration.Seconds(),
+		RowsMigrated:    report.RowsMigrated,
+		FailedRows:      report.FailedRows,
+		Bytes:           report.Bytes,
+		SkippedTables:   report.SkippedTables,
+		Tables:          make([]tableSummary, len(report.Tables)),
+	}
+	for i, t := range report.Tables {
+		summary.Tables[i] = tableSummary{
+			Table:           t.Table,
+			RowsMigrated:    t.RowsMigrated,
+			RowsSkipped:     t.RowsSkipped,
+			RowsRejected:    t.RowsRejected,
+			Bytes:           t.Bytes,
+			DurationSeconds: t.Duration.Seconds(),
+		}
+	}
+
+	if err != nil {
+		summary.Error = err.Error()
+		var failures *FailedRowsError
+		switch {
+		case errors.As(err, &failures):
+			summary.Status = summaryWithErrors
+		case interrupted:
+			summary.Status = summaryInterrupted
+		default:
+			summary.Status = summaryFailed
+		}
+	}
+
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode summary: %v", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write summary: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"sync/atomic"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// TLSConfig connects to a database over TLS, e.g. a managed server that requires it
+type TLSConfig struct {
+	// CACert is a PEM bundle verifying the server, the system roots are used when empty
+	CACert string `yaml:"caCert"`
+	// ClientCert and ClientKey hold the client certificate, for servers requiring one
+	ClientCert string `yaml:"clientCert"`
+	ClientKey  string `yaml:"clientKey"`
+	// SkipVerify accepts any server certificate, only for testing
+	SkipVerify bool `yaml:"skipVerify"`
+}
+
+func (c *TLSConfig) build(serverName string) (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: serverName, InsecureSkipVerify: c.SkipVerify}
+
+	if c.CACert != "" {
+		pem, err := os.ReadFile(c.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CACert)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if c.ClientCert != "" || c.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+var mysqlTLSConfigs atomic.Int64
+
+// registerMySQLTLS registers the TLS settings of cfg with the MySQL driver and returns the name
+// the DSN selects them by
+func registerMySQLTLS(cfg DatabaseConfig) (string, error) {
+	tlsConfig, err := cfg.TLS.build(cfg.Host)
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("migrate-%d", mysqlTLSConfigs.Add(1))
+	if err := mysql.RegisterTLSConfig(name, tlsConfig); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// postgresTLSParams maps the TLS settings to the libpq parameters pgx reads from the DSN
+func postgresTLSParams(c *TLSConfig) url.Values {
+	params := url.Values{}
+	if c.SkipVerify {
+		params.Set("sslmode", "require")
+	} else {
+		params.Set("sslmode", "verify-full")
+	}
+	if c.CACert != "" {
+		params.Set("sslrootcert", c.CACert)
+	}
+	if c.ClientCert != "" {
+		params.Set("sslcert", c.ClientCert)
+	}
+	if c.ClientKey != "" {
+		params.Set("sslkey", c.ClientKey)
+	}
+	return params
+}
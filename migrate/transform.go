@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+)
+
+// ValueTransformer rewrites one scanned source value before it is inserted into the destination
+type ValueTransformer func(value interface{}) (interface{}, error)
+
+// anyColumn registers a transformer for every column of every table
+const anyColumn = "*"
+
+// RegisterTransformer sets the transformer applied to a column, keyed as table.column.
+// Passing "*" as table and column sets the transformer of every column that has none of its own;
+// a column's own transformer replaces it. Register transformers before calling Migrate.
+func (dm *DatabaseMigrator) RegisterTransformer(tableName, column string, transform ValueTransformer) {
+	key := anyColumn
+	if tableName != anyColumn || column != anyColumn {
+		key = tableName + "." + column
+	}
+	dm.transformers[key] = transform
+}
+
+// defaultTransformers replaces the MySQL zero dates the destination would reject
+func defaultTransformers() map[string]ValueTransformer {
+	return map[string]ValueTransformer{
+		anyColumn:              zeroDateTo(nil),
+		"AspNetUsers.Birthday": zeroDateTo("1970-01-01"),
+	}
+}
+
+// zeroDateTo returns a transformer replacing zero dates with replacement
+func zeroDateTo(replacement interface{}) ValueTransformer {
+	return func(value interface{}) (interface{}, error) {
+		if value != nil && isZeroDate(value) {
+			return replacement, nil
+		}
+		return value, nil
+	}
+}
+
+// columnTransformers looks up the transformer of each column of a table, nil when it has none.
+// Binary columns only get their own transformer: their bytes are not text, and a blob that
+// happens to read as a zero date must not be replaced.
+func (dm *DatabaseMigrator) columnTransformers(tableName string, columns []ColumnInfo) []ValueTransformer {
+	transformers := make([]ValueTransformer, len(columns))
+	for i, column := range columns {
+		if transform, ok := dm.transformers[tableName+"."+column.Name]; ok {
+			transformers[i] = transform
+		} else if !isBinaryType(column.Type) {
+			transformers[i] = dm.transformers[anyColumn]
+		}
+	}
+	return transformers
+}
+
+// transformRow applies the column transformers to a scanned row, in place
+func transformRow(columns []string, transformers []ValueTransformer, values []interface{}) error {
+	for i, transform := range transformers {
+		if transform == nil {
+			continue
+		}
+		value, err := transform(values[i])
+		if err != nil {
+			return fmt.Errorf("failed to transform column %s: %v", columns[i], err)
+		}
+		values[i] = value
+	}
+	return nil
+}
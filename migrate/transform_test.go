@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestColumnTransformersSkipBinaryColumns(t *testing.T) {
+	dm := &DatabaseMigrator{transformers: defaultTransformers()}
+	dm.RegisterTransformer("files", "thumbnail", func(value interface{}) (interface{}, error) { return "own", nil })
+	columns := []ColumnInfo{
+		{Name: "created", Type: "datetime"},
+		{Name: "payload", Type: "blob"},
+		{Name: "hash", Type: "varbinary(32)"},
+		{Name: "thumbnail", Type: "mediumblob"},
+	}
+	zeroDate := []byte("0000-00-00 00:00:00")
+	values := []interface{}{zeroDate, zeroDate, zeroDate, zeroDate}
+
+	transformers := dm.columnTransformers("files", columns)
+	if err := transformRow([]string{"created", "payload", "hash", "thumbnail"}, transformers, values); err != nil {
+		t.Fatalf("transformRow() error = %v", err)
+	}
+	if values[0] != nil {
+		t.Errorf("datetime zero date = %v, want nil", values[0])
+	}
+	for i := 1; i <= 2; i++ {
+		if got, ok := values[i].([]byte); !ok || string(got) != string(zeroDate) {
+			t.Errorf("%s = %v, want its bytes unchanged", columns[i].Name, values[i])
+		}
+	}
+	if values[3] != "own" {
+		t.Errorf("thumbnail = %v, want its own transformer applied", values[3])
+	}
+}
+
+func TestCSVField(t *testing.T) {
+	tests := []struct {
+		name   string
+		val    interface{}
+		binary bool
+		want   string
+	}{
+		{name: "NULL", val: nil, want: `\N`},
+		{name: "NULL binary", val: nil, binary: true, want: `\N`},
+		{name: "text bytes", val: []byte("héllo"), want: "héllo"},
+		{name: "blob bytes", val: []byte{0x00, 0xff, 0x10, 'a'}, binary: true, want: "AP8QYQ=="},
+		{name: "blob reading as a zero date", val: []byte("0000-00-00"), binary: true, want: "MDAwMC0wMC0wMA=="},
+		{name: "number", val: int64(42), want: "42"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := csvField(tt.val, tt.binary, `\N`); got != tt.want {
+				t.Errorf("csvField(%v, %v) = %q, want %q", tt.val, tt.binary, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// countTableRows counts every row of a table in either database
+func countTableRows(ctx context.Context, db Querier, d dialect, tableName string) (int, error) {
+	var count int
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s", d.quote(tableName))
+	if err := db.QueryRowContext(ctx, query).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to get row count for table %s: %v", tableName, err)
+	}
+	return count, nil
+}
+
+// checksumTable returns CHECKSUM TABLE for MySQL databases, and otherwise a hash of the
+// table's primary keys in key order, which catches missing or extra rows
+func checksumTable(ctx context.Context, db Querier, d dialect, tableName string, pkColumns []string, useChecksumTable bool) (uint64, error) {
+	if useChecksumTable {
+		var table string
+		var checksum sql.NullInt64
+		if err := db.QueryRowContext(ctx, fmt.Sprintf("CHECKSUM TABLE %s", d.quote(tableName))).Scan(&table, &checksum); err != nil {
+			return 0, fmt.Errorf("failed to checksum table %s: %v", tableName, err)
+		}
+		return uint64(checksum.Int64), nil
+	}
+
+	if len(pkColumns) == 0 {
+		return 0, fmt.Errorf("table %s has no primary key to checksum", tableName)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s ORDER BY %s",
+		quoteList(d, pkColumns), d.quote(tableName), quoteList(d, pkColumns))
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read primary keys of table %s: %v", tableName, err)
+	}
+	defer rows.Close()
+
+	h := fnv.New64a()
+	values := make([]interface{}, len(pkColumns))
+	valuePtrs := make([]interface{}, len(pkColumns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return 0, fmt.Errorf("failed to scan primary key: %v", err)
+		}
+		for _, val := range values {
+			h.Write([]byte(profileText(val)))
+			h.Write([]byte{0})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read primary keys of table %s: %v", tableName, err)
+	}
+
+	return h.Sum64(), nil
+}
+
+// VerifyMigration compares the row count of every table in the source and destination and,
+// with VerifyChecksum, their checksums. Sampled and filtered tables are not compared. It returns an error
+// listing the tables that differ.
+func (dm *DatabaseMigrator) VerifyMigration(tables []string) error {
+	dm.logger.Log(fmt.Sprintf("Verifying %d migrated tables", len(tables)))
+
+	// CHECKSUM TABLE values are only comparable between two MySQL servers
+	useChecksumTable := dm.source.name() == driverMySQL && dm.dest.name() == driverMySQL
+
+	var mismatches []string
+	cancel := func() {}
+	defer func() { cancel() }()
+	for _, tableName := range tables {
+		cancel()
+		var ctx context.Context
+		ctx, cancel = dm.queryContext()
+
+		if rate, ok := dm.config.SampleRates[tableName]; ok && rate < 1 {
+			dm.logger.Log(fmt.Sprintf("Table %s is sampled, not verifying it", tableName))
+			continue
+		}
+		if dm.config.RowFilters[tableName] != "" {
+			dm.logger.Log(fmt.Sprintf("Table %s is filtered, not verifying it", tableName))
+			continue
+		}
+
+		sourceRows, err := countTableRows(ctx, dm.sourceDB, dm.source, tableName)
+		if err != nil {
+			return err
+		}
+		destRows, err := countTableRows(ctx, dm.destDB, dm.dest, tableName)
+		if err != nil {
+			return err
+		}
+		if dm.config.MaxRowsPerTable > 0 && sourceRows > dm.config.MaxRowsPerTable {
+			dm.logger.Log(fmt.Sprintf("Table %s has more than maxRowsPerTable rows, not verifying it", tableName))
+			continue
+		}
+		if sourceRows != destRows {
+			mismatch := fmt.Sprintf("%s (%d source rows, %d destination rows)", tableName, sourceRows, destRows)
+			dm.logger.Log(fmt.Sprintf("MISMATCH: table %s", mismatch))
+			mismatches = append(mismatches, mismatch)
+			continue
+		}
+
+		if dm.config.VerifyChecksum && dm.hasColumnMapping(tableName) {
+			dm.logger.Log(fmt.Sprintf("Table %s has mapped columns, only comparing row counts", tableName))
+		} else if dm.config.VerifyChecksum {
+			pkColumns, err := dm.GetPrimaryKeyColumns(tableName)
+			if err != nil {
+				return err
+			}
+			sourceSum, err := checksumTable(ctx, dm.sourceDB, dm.source, tableName, pkColumns, useChecksumTable)
+			if err != nil {
+				return err
+			}
+			destSum, err := checksumTable(ctx, dm.destDB, dm.dest, tableName, pkColumns, useChecksumTable)
+			if err != nil {
+				return err
+			}
+			if sourceSum != destSum {
+				mismatch := fmt.Sprintf("%s (checksum %d in source, %d in destination)", tableName, sourceSum, destSum)
+				dm.logger.Log(fmt.Sprintf("MISMATCH: table %s", mismatch))
+				mismatches = append(mismatches, mismatch)
+				continue
+			}
+		}
+
+		dm.logger.Log(fmt.Sprintf("Table %s verified (%d rows)", tableName, destRows))
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%d tables differ between source and destination: %s", len(mismatches), strings.Join(mismatches, "; "))
+	}
+	dm.logger.Log("Verification passed: destination matches source")
+	return nil
+}
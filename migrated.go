@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// oldIdIndexName is the unique index ensureOldIdIndex creates on the target collection
+const oldIdIndexName = "TenantId_1_OldId_1_unique"
+
+// ensureOldIdIndex creates a unique index on TenantId and OldId of the target collection, so the
+// database rejects a second copy of a source row however often the migration is run
+func ensureOldIdIndex(ctx context.Context, collection *mongo.Collection) error {
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "TenantId", Value: 1}, {Key: "OldId", Value: 1}},
+		Options: options.Index().SetName(oldIdIndexName).SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create unique index on TenantId and OldId of %s, it may hold duplicate items (re-run with ForceRemigrate): %v",
+			collection.Name(), err)
+	}
+	return nil
+}
+
+// upsertItems writes the documents of items keyed on their OldId and TenantId, leaving alone
+// the items the collection already has. migratedItems skips the items of a previous run before
+// they are batched; the upsert covers the ones written meanwhile, e.g. by a concurrent run. The
+// writes are unordered, so an item MongoDB rejects doesn't stop the rest of the batch. It returns
+// the items inserted, the items found already there with their stored ids, and the messages of
+// the rejected ones, by their index in items.
+func upsertItems(ctx context.Context, collection *mongo.Collection, items []CourseLessonItem, docs []interface{}) ([]CourseLessonItem, []CourseLessonItem, map[int]string, error) {
+	models := make([]mongo.WriteModel, len(docs))
+	for i, doc := range docs {
+		models[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"OldId": items[i].OldId, "TenantId": items[i].TenantId}).
+			SetUpdate(bson.M{"$setOnInsert": doc}).
+			SetUpsert(true)
+	}
+	result, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	failed, err := failedWrites(err)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("MongoDB bulk upsert error: %v", err)
+	}
+
+	inserted := make([]CourseLessonItem, 0, len(result.UpsertedIDs))
+	var existing []CourseLessonItem
+	for i, item := range items {
+		if _, ok := result.UpsertedIDs[int64(i)]; ok {
+			inserted = append(inserted, item)
+		} else if _, ok := failed[i]; !ok {
+			existing = append(existing, item)
+		}
+	}
+	if len(existing) > 0 {
+		log.Printf("%d items of the batch were already in %s, keeping them", len(existing), collection.Name())
+		if err := loadStoredIds(ctx, collection, existing); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return inserted, existing, failed, nil
+}
+
+// loadStoredIds replaces the ids of items, generated for this run, by those of their documents
+// in collection, matched on OldId and TenantId
+func loadStoredIds(ctx context.Context, collection *mongo.Collection, items []CourseLessonItem) error {
+	type key struct{ tenantId, oldId int }
+	oldIds := make([]int, len(items))
+	for i, item := range items {
+		oldIds[i] = item.OldId
+	}
+	cursor, err := collection.Find(ctx, bson.M{"OldId": bson.M{"$in": oldIds}},
+		options.Find().SetProjection(bson.M{"_id": 1, "OldId": 1, "TenantId": 1, "CourseLessonItemId": 1}))
+	if err != nil {
+		return fmt.Errorf("failed to read the stored ids of existing items: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	stored := make(map[key]CourseLessonItemIDs, len(items))
+	for cursor.Next(ctx) {
+		var doc struct {
+			Id                 primitive.ObjectID `bson:"_id"`
+			OldId              int                `bson:"OldId"`
+			TenantId           int                `bson:"TenantId"`
+			CourseLessonItemId string             `bson:"CourseLessonItemId"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return fmt.Errorf("failed to decode the stored ids of existing items: %v", err)
+		}
+		stored[key{doc.TenantId, doc.OldId}] = CourseLessonItemIDs{CourseLessonItemId: doc.CourseLessonItemId, Id: doc.Id}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to read the stored ids of existing items: %v", err)
+	}
+
+	for i := range items {
+		ids, ok := stored[key{items[i].TenantId, items[i].OldId}]
+		if !ok {
+			return fmt.Errorf("item %d was reported in %s but could not be read back", items[i].OldId, collection.Name())
+		}
+		items[i].CourseLessonItemId, items[i].Id = ids.CourseLessonItemId, ids.Id
+	}
+	return nil
+}
+
+// tenantFilter selects the documents of a tenant, or every document when tenantId is nil
+func tenantFilter(tenantId *int) bson.M {
+	if tenantId == nil {
+		return bson.M{}
+	}
+	return bson.M{"TenantId": *tenantId}
+}
+
+// migratedItems returns the ids of the items already in the target collection by OldId, so a
+// re-run migrates only the rows it is missing and still maps the others. The mapping holds every
+// item anyway, so reading them up front costs no more memory than the run itself.
+func migratedItems(ctx context.Context, collection *mongo.Collection, tenantId *int) (CourseLessonItemMapping, error) {
+	cursor, err := collection.Find(ctx, tenantFilter(tenantId),
+		options.Find().SetProjection(bson.M{"_id": 1, "OldId": 1, "CourseLessonItemId": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrated OldIds: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	migrated := make(CourseLessonItemMapping)
+	for cursor.Next(ctx) {
+		var doc struct {
+			Id                 primitive.ObjectID `bson:"_id"`
+			OldId              int                `bson:"OldId"`
+			CourseLessonItemId string             `bson:"CourseLessonItemId"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("failed to decode migrated OldId: %v", err)
+		}
+		migrated[doc.OldId] = CourseLessonItemIDs{CourseLessonItemId: doc.CourseLessonItemId, Id: doc.Id}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read migrated OldIds: %v", err)
+	}
+	return migrated, nil
+}
+
+// clearMigratedItems deletes the documents a previous run wrote to the target collection,
+// only those of the tenant when tenantId is set
+func clearMigratedItems(ctx context.Context, collection *mongo.Collection, tenantId *int) error {
+	result, err := collection.DeleteMany(ctx, tenantFilter(tenantId))
+	if err != nil {
+		return fmt.Errorf("failed to clear %s: %v", collection.Name(), err)
+	}
+	log.Printf("Cleared %d documents from %s for a full re-migration", result.DeletedCount, collection.Name())
+	return nil
+}
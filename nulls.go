@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// optionalFields returns the bson names of the omitempty fields of a struct type
+func optionalFields(t reflect.Type) []string {
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("bson")
+		name, opts, _ := strings.Cut(tag, ",")
+		if strings.Contains(opts, "omitempty") {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// nullFieldsToWrite lists the optional fields that must be present as null when nil
+func nullFieldsToWrite(fields []string, all bool, selected map[string]bool) []string {
+	var result []string
+	for _, field := range fields {
+		if all || selected[field] {
+			result = append(result, field)
+		}
+	}
+	return result
+}
+
+// withExplicitNulls marshals a value and adds every listed field that was omitted as an explicit null
+func withExplicitNulls(value interface{}, nullFields []string) (bson.D, error) {
+	data, err := bson.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal document: %v", err)
+	}
+
+	var doc bson.D
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal document: %v", err)
+	}
+
+	present := make(map[string]bool, len(doc))
+	for _, elem := range doc {
+		present[elem.Key] = true
+	}
+	for _, field := range nullFields {
+		if !present[field] {
+			doc = append(doc, bson.E{Key: field, Value: nil})
+		}
+	}
+
+	return doc, nil
+}
+
+// itemDocuments converts batches of CourseLessonItems into the documents to insert
+type itemDocuments struct {
+	// nullFields are the optional fields written as null when nil
+	nullFields []string
+	enums      *enumLabeler
+}
+
+// newItemDocuments sets up the null handling and enum labels of config
+func newItemDocuments(config CourseLessonItemConfig) itemDocuments {
+	return itemDocuments{
+		nullFields: nullFieldsToWrite(optionalFields(reflect.TypeOf(CourseLessonItem{})), config.WriteAllNullFields, config.ExplicitNullFields),
+		enums:      newEnumLabeler(config.EnumLabels, config.ReplaceEnumValues),
+	}
+}
+
+// build converts a batch into the documents to insert, applying the null handling and the enum labels
+func (d itemDocuments) build(items []CourseLessonItem) ([]interface{}, error) {
+	docs := make([]interface{}, 0, len(items))
+	if len(d.nullFields) == 0 && len(d.enums.labels) == 0 {
+		for _, item := range items {
+			docs = append(docs, item)
+		}
+		return docs, nil
+	}
+
+	for _, item := range items {
+		doc, err := withExplicitNulls(item, d.nullFields)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, d.enums.apply(doc))
+	}
+	return docs, nil
+}
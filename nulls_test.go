@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestItemDocumentsNullFields(t *testing.T) {
+	content := "text"
+	item := CourseLessonItem{Title: "Intro", Content: &content}
+
+	tests := []struct {
+		name        string
+		config      CourseLessonItemConfig
+		wantNull    []string
+		wantOmitted []string
+	}{
+		{
+			name:        "nil fields are omitted by default",
+			wantOmitted: []string{"VideoUrl", "QuestionIds", "QuestionIdsRaw", "MaxSubmitCount"},
+		},
+		{
+			name:        "selected fields are explicit null",
+			config:      CourseLessonItemConfig{ExplicitNullFields: map[string]bool{"VideoUrl": true, "Content": true}},
+			wantNull:    []string{"VideoUrl"},
+			wantOmitted: []string{"QuestionIds", "QuestionIdsRaw", "MaxSubmitCount"},
+		},
+		{
+			name:     "every optional field is explicit null",
+			config:   CourseLessonItemConfig{WriteAllNullFields: true},
+			wantNull: []string{"VideoUrl", "QuestionIds", "QuestionIdsRaw", "MaxSubmitCount"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docs, err := newItemDocuments(tt.config).build([]CourseLessonItem{item})
+			if err != nil {
+				t.Fatalf("build() error = %v", err)
+			}
+			data, err := bson.Marshal(docs[0])
+			if err != nil {
+				t.Fatalf("bson.Marshal() error = %v", err)
+			}
+			doc := bson.Raw(data)
+
+			if got := doc.Lookup("Content").StringValue(); got != content {
+				t.Errorf("Content = %q, want %q", got, content)
+			}
+			for _, field := range tt.wantNull {
+				value, err := doc.LookupErr(field)
+				if err != nil {
+					t.Errorf("%s is omitted, want an explicit null", field)
+				} else if value.Type != bson.TypeNull {
+					t.Errorf("%s = %v, want null", field, value)
+				}
+			}
+			for _, field := range tt.wantOmitted {
+				if _, err := doc.LookupErr(field); err == nil {
+					t.Errorf("%s is present, want it omitted", field)
+				}
+			}
+		})
+	}
+}
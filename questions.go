@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// parseQuestionIds reads the QuestionIds column, which holds either a JSON array such as
+// ["1","2"] or [1,2], or a comma-separated list such as 1,2. Blank entries are dropped.
+func parseQuestionIds(raw string) ([]string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(raw, "[") {
+		// numbers are kept as written, float64 would turn large ids into 1e+21
+		decoder := json.NewDecoder(strings.NewReader(raw))
+		decoder.UseNumber()
+		var values []interface{}
+		if err := decoder.Decode(&values); err != nil {
+			return nil, fmt.Errorf("invalid JSON array: %v", err)
+		}
+		ids := make([]string, 0, len(values))
+		for _, v := range values {
+			switch v := v.(type) {
+			case string:
+				if v = strings.TrimSpace(v); v != "" {
+					ids = append(ids, v)
+				}
+			case json.Number:
+				ids = append(ids, v.String())
+			default:
+				return nil, fmt.Errorf("unexpected %T in JSON array", v)
+			}
+		}
+		return ids, nil
+	}
+
+	var ids []string
+	for _, id := range strings.Split(raw, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// setQuestionIds keeps the raw QuestionIds column in QuestionIdsRaw and its parsed ids in
+// QuestionIds, which is left empty when the column cannot be parsed
+func (item *CourseLessonItem) setQuestionIds(raw string, oldId int) {
+	item.QuestionIdsRaw = &raw
+	ids, err := parseQuestionIds(raw)
+	if err != nil {
+		log.Printf("⚠️  Item %d has unparseable QuestionIds %q: %v", oldId, raw, err)
+		return
+	}
+	item.QuestionIds = ids
+}
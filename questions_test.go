@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQuestionIds(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", raw: "", want: nil},
+		{name: "blank", raw: "  \t ", want: nil},
+		{name: "single id", raw: "7", want: []string{"7"}},
+		{name: "comma-separated", raw: "1,2,3", want: []string{"1", "2", "3"}},
+		{name: "whitespace and blank entries", raw: " 1 , 2,, 3 ,", want: []string{"1", "2", "3"}},
+		{name: "JSON array of strings", raw: `["1", " 2 ", ""]`, want: []string{"1", "2"}},
+		{name: "JSON array of numbers", raw: "[1, 2]", want: []string{"1", "2"}},
+		{name: "JSON array keeps large numbers", raw: "[1000000000000000000000]", want: []string{"1000000000000000000000"}},
+		{name: "empty JSON array", raw: " [] ", want: []string{}},
+		{name: "malformed JSON array", raw: `["1", "2"`, wantErr: true},
+		{name: "JSON array of objects", raw: `[{"id": 1}]`, wantErr: true},
+		{name: "JSON array with null", raw: `[1, null]`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseQuestionIds(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseQuestionIds(%q) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseQuestionIds(%q) = %#v, want %#v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// MySQLReference is a MySQL column holding the old id of a CourseLessonItem, next to the
+// column MigrateCourseLessonItems fills with the item's new CourseLessonItemId
+type MySQLReference struct {
+	Table       string
+	OldIdColumn string
+	NewIdColumn string
+}
+
+// TranscriptReference links a Transcript row to its lesson item
+var TranscriptReference = MySQLReference{Table: "Transcript", OldIdColumn: "LessonItemId", NewIdColumn: "NewLessonItemId"}
+
+// referenceUpdateChunkSize is the number of ids set by one UPDATE ... CASE statement
+const referenceUpdateChunkSize = 500
+
+// updateMySQLReferences sets ref.NewIdColumn to the new CourseLessonItemId of every row whose
+// ref.OldIdColumn is a migrated OldId. The rows are updated referenceUpdateChunkSize ids per
+// statement, all in one transaction. It returns the number of rows changed.
+func updateMySQLReferences(ctx context.Context, mysqlDB *sql.DB, ref MySQLReference, mapping CourseLessonItemMapping) (int64, error) {
+	if len(mapping) == 0 {
+		return 0, nil
+	}
+	oldIds := make([]int, 0, len(mapping))
+	for oldId := range mapping {
+		oldIds = append(oldIds, oldId)
+	}
+	sort.Ints(oldIds)
+
+	tx, err := mysqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin %s update: %v", ref.Table, err)
+	}
+	defer tx.Rollback()
+
+	var updated int64
+	for start := 0; start < len(oldIds); start += referenceUpdateChunkSize {
+		chunk := oldIds[start:min(start+referenceUpdateChunkSize, len(oldIds))]
+
+		// UPDATE t SET new = CASE old WHEN ? THEN ? ... END WHERE old IN (?, ...)
+		var cases strings.Builder
+		args := make([]interface{}, 0, 3*len(chunk))
+		for _, oldId := range chunk {
+			cases.WriteString(" WHEN ? THEN ?")
+			args = append(args, oldId, mapping[oldId].CourseLessonItemId)
+		}
+		for _, oldId := range chunk {
+			args = append(args, oldId)
+		}
+		query := fmt.Sprintf("UPDATE `%s` SET `%s` = CASE `%s`%s END WHERE `%s` IN (%s)",
+			ref.Table, ref.NewIdColumn, ref.OldIdColumn, cases.String(), ref.OldIdColumn,
+			strings.TrimSuffix(strings.Repeat("?, ", len(chunk)), ", "))
+
+		result, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return 0, fmt.Errorf("error updating %s table: %v", ref.Table, err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("error updating %s table: %v", ref.Table, err)
+		}
+		updated += n
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit %s update: %v", ref.Table, err)
+	}
+	log.Printf("Updated %s.%s of %d rows", ref.Table, ref.NewIdColumn, updated)
+	return updated, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	appdb "github.com/duymanh3602/migrate-tool/internal/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoToMySQLConfig selects the collection copied back into MySQL by MigrateMongoToMySQL
+type MongoToMySQLConfig struct {
+	MongoURI     string
+	DatabaseName string
+	Collection   string
+	// Filter selects the documents to copy, nil copies all of them
+	Filter bson.M
+
+	MySQLDSN string
+	Table    string
+	// Fields maps top-level document fields to the table columns they are written to,
+	// e.g. {"OldId": "Id", "NewItemId": "NewItemId"}. The table's primary or a unique key
+	// must be among the columns for existing rows to be updated instead of duplicated.
+	Fields map[string]string
+
+	BatchSize int
+
+	// MongoTLS and MySQLTLS, when set, connect to the servers over TLS
+	MongoTLS *TLSOptions
+	MySQLTLS *TLSOptions
+}
+
+// MigrateMongoToMySQL upserts the mapped fields of every matching document into a MySQL table,
+// BatchSize rows per INSERT ... ON DUPLICATE KEY UPDATE. It returns the number of documents written.
+func MigrateMongoToMySQL(config MongoToMySQLConfig) (int, error) {
+	if len(config.Fields) == 0 {
+		return 0, fmt.Errorf("no fields mapped to columns of %s", config.Table)
+	}
+	batchSize := config.BatchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	filter := config.Filter
+	if filter == nil {
+		filter = bson.M{}
+	}
+
+	// Fixed column order, so every row of a batch lines up with the statement
+	fields := make([]string, 0, len(config.Fields))
+	for field := range config.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	columns := make([]string, len(fields))
+	for i, field := range fields {
+		columns[i] = config.Fields[field]
+	}
+
+	dsn, err := withMySQLTLS(config.MySQLDSN, config.MySQLTLS)
+	if err != nil {
+		return 0, err
+	}
+	mysqlDB, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return 0, fmt.Errorf("MySQL connection error: %v", err)
+	}
+	defer mysqlDB.Close()
+
+	if err := appdb.ConnectWithRetry(context.Background(), "MySQL", connectRetry, mysqlDB.PingContext, appdb.IsTransientSQLError); err != nil {
+		return 0, err
+	}
+
+	ctx := context.Background()
+	clientOptions, err := mongoClientOptions(config.MongoURI, config.MongoTLS)
+	if err != nil {
+		return 0, err
+	}
+	mongoClient, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return 0, fmt.Errorf("MongoDB connection error: %v", err)
+	}
+	defer mongoClient.Disconnect(ctx)
+
+	if err := appdb.ConnectWithRetry(ctx, "MongoDB", connectRetry, func(ctx context.Context) error {
+		return mongoClient.Ping(ctx, nil)
+	}, appdb.IsTransientMongoError); err != nil {
+		return 0, err
+	}
+
+	projection := bson.M{}
+	for _, field := range fields {
+		projection[field] = 1
+	}
+	collection := mongoClient.Database(config.DatabaseName).Collection(config.Collection)
+	cursor, err := collection.Find(ctx, filter, options.Find().SetProjection(projection).SetBatchSize(int32(batchSize)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to find documents: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	written := 0
+	var batch [][]interface{}
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return written, fmt.Errorf("failed to decode document: %v", err)
+		}
+
+		values := make([]interface{}, len(fields))
+		for i, field := range fields {
+			values[i], err = mysqlValue(doc[field])
+			if err != nil {
+				return written, fmt.Errorf("document %v: field %s: %v", doc["_id"], field, err)
+			}
+		}
+		batch = append(batch, values)
+
+		if len(batch) >= batchSize {
+			if err := upsertMySQLRows(mysqlDB, config.Table, columns, batch); err != nil {
+				return written, err
+			}
+			written += len(batch)
+			batch = batch[:0]
+			log.Printf("Wrote %d documents to %s", written, config.Table)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return written, fmt.Errorf("cursor error: %v", err)
+	}
+
+	if len(batch) > 0 {
+		if err := upsertMySQLRows(mysqlDB, config.Table, columns, batch); err != nil {
+			return written, err
+		}
+		written += len(batch)
+	}
+
+	log.Printf("✅ Wrote %d documents from %s to MySQL table %s", written, config.Collection, config.Table)
+	return written, nil
+}
+
+// upsertMySQLRows writes rows with one multi-row INSERT that updates rows whose key already exists
+func upsertMySQLRows(db *sql.DB, table string, columns []string, rows [][]interface{}) error {
+	quoted := make([]string, len(columns))
+	updates := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = "`" + strings.ReplaceAll(column, "`", "``") + "`"
+		updates[i] = fmt.Sprintf("%s = VALUES(%s)", quoted[i], quoted[i])
+	}
+	rowPlaceholders := "(" + strings.TrimSuffix(strings.Repeat("?,", len(columns)), ",") + ")"
+
+	placeholders := make([]string, len(rows))
+	args := make([]interface{}, 0, len(rows)*len(columns))
+	for i, row := range rows {
+		placeholders[i] = rowPlaceholders
+		args = append(args, row...)
+	}
+
+	query := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES %s ON DUPLICATE KEY UPDATE %s",
+		strings.ReplaceAll(table, "`", "``"), strings.Join(quoted, ", "),
+		strings.Join(placeholders, ", "), strings.Join(updates, ", "))
+	if _, err := db.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to upsert %d rows into %s: %v", len(rows), table, err)
+	}
+	return nil
+}
+
+// mysqlValue converts a decoded BSON value into one the MySQL driver accepts
+func mysqlValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case primitive.ObjectID:
+		return v.Hex(), nil
+	case primitive.DateTime:
+		return v.Time().UTC(), nil
+	case primitive.Timestamp:
+		return time.Unix(int64(v.T), 0).UTC(), nil
+	case primitive.Decimal128:
+		return v.String(), nil
+	case primitive.Binary:
+		return v.Data, nil
+	case primitive.Null, primitive.Undefined:
+		return nil, nil
+	case bson.M, bson.D, bson.A:
+		// Embedded documents and arrays are stored as JSON text
+		data, err := bson.MarshalExtJSON(bson.M{"v": v}, false, false)
+		if err != nil {
+			return nil, err
+		}
+		return strings.TrimSuffix(strings.TrimPrefix(string(data), `{"v":`), "}"), nil
+	}
+	return value, nil
+}
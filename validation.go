@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CourseLessonItemValidator checks a scanned item before it is inserted.
+// A non-nil error sends the item to the quarantine collection instead.
+type CourseLessonItemValidator func(item CourseLessonItem) error
+
+// CourseLessonItemRules are the checks of the default validator: an item needs a Title, and
+// the Type and RefId rules apply when they are set
+type CourseLessonItemRules struct {
+	// AllowedTypes lists the accepted CourseLessonItem.Type values, empty accepts every type
+	AllowedTypes map[int]bool
+	// RefTypes lists the item types that must point at another entity through RefId
+	RefTypes map[int]bool
+}
+
+// QuarantinedCourseLessonItem is an item rejected by validation, kept with the reason so the source row can be fixed
+type QuarantinedCourseLessonItem struct {
+	Item            CourseLessonItem `bson:"Item"`
+	OldId           int              `bson:"OldId"`
+	ValidationError string           `bson:"ValidationError"`
+	QuarantinedAt   time.Time        `bson:"QuarantinedAt"`
+}
+
+// Validate is the CourseLessonItemValidator of the rules
+func (r CourseLessonItemRules) Validate(item CourseLessonItem) error {
+	var errs []error
+
+	if strings.TrimSpace(item.Title) == "" {
+		errs = append(errs, errors.New("Title is empty"))
+	}
+	if len(r.AllowedTypes) > 0 && !r.AllowedTypes[item.Type] {
+		errs = append(errs, fmt.Errorf("Type %d is not allowed", item.Type))
+	}
+	if r.RefTypes[item.Type] && strings.TrimSpace(item.RefId) == "" {
+		errs = append(errs, fmt.Errorf("RefId is required for Type %d", item.Type))
+	}
+
+	return errors.Join(errs...)
+}
+
+func quarantineItem(ctx context.Context, quarantineCollection *mongo.Collection, item CourseLessonItem, validationErr error) error {
+	_, err := quarantineCollection.InsertOne(ctx, QuarantinedCourseLessonItem{
+		Item:            item,
+		OldId:           item.OldId,
+		ValidationError: validationErr.Error(),
+		QuarantinedAt:   time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("error quarantining item %d: %v", item.OldId, err)
+	}
+	return nil
+}